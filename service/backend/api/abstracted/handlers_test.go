@@ -0,0 +1,313 @@
+package abstracted
+
+import (
+	"context"
+	"encoding/json"
+	"githubclone-backend/api"
+	"githubclone-backend/api/common"
+	"githubclone-backend/cachable"
+	"githubclone-backend/fakeforge"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5"
+)
+
+// The handlers are tested against recorded GitHub responses in testdata/fixtures, no network is
+// needed. With UPSTREAM_RECORD=1 and GITHUB_TOKEN the fixtures are recorded again from GitHub.
+
+const testSessionID = "handler-test-session"
+
+// memoryBackend is a cache backend without Redis, the values are stored as JSON like in Redis
+type memoryBackend struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (m *memoryBackend) Get(key string, dest interface{}) (bool, error) {
+	m.mu.Lock()
+	data, ok := m.values[key]
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, dest)
+}
+
+func (m *memoryBackend) Set(key string, val interface{}, _ bool, _ time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.values[key] = data
+	m.mu.Unlock()
+	return nil
+}
+
+// replayRouter serves the routes with an empty cache, the upstream responses come from the fixture
+func replayRouter(t *testing.T, fixture string) *gin.Engine {
+	t.Helper()
+	mode, token := common.ModeReplay, "test-token"
+	if os.Getenv("UPSTREAM_RECORD") == "1" {
+		mode, token = common.ModeRecord, os.Getenv("GITHUB_TOKEN")
+	}
+	recorder, err := common.NewRecorder(filepath.Join("testdata", "fixtures", fixture+".json"), mode, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	common.SetUpstreamTransport(recorder)
+	t.Cleanup(func() {
+		common.SetUpstreamTransport(nil)
+		if err := recorder.Save(); err != nil {
+			t.Errorf("fixture cannot be saved: %v", err)
+		}
+	})
+	api.SetSession(testSessionID, 1, map[api.OAuthProvider]api.AccessToken{
+		api.Github: {Token: token, URL: api.GithubAddress, ConnectionID: 1},
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	facade := cachable.NewCacheFacade(context.Background(), &memoryBackend{values: make(map[string][]byte)})
+	router.Use(func(c *gin.Context) {
+		c.Set("cacheFacade", facade)
+		c.Next()
+	})
+	SetupRoutes(router)
+	return router
+}
+
+// forgeServer is a router whose upstream requests are answered by the fake forge
+type forgeServer struct {
+	forge    *fakeforge.Forge
+	backend  *memoryBackend
+	router   *gin.Engine
+	logins   []string
+	sessions map[string]string
+}
+
+// forgeRouter serves the routes with an empty cache against the fake forge, every login gets a
+// session for the GHES connection. The breakers are global, so every test uses its own host.
+func forgeRouter(t *testing.T, host string, connectionID uint, logins ...string) *forgeServer {
+	t.Helper()
+	forge, err := fakeforge.NewFromFile("../../fakeforge/testdata/seed.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	common.SetUpstreamTransport(forge)
+	t.Cleanup(func() { common.SetUpstreamTransport(nil) })
+
+	s := &forgeServer{forge: forge, backend: &memoryBackend{values: make(map[string][]byte)}, logins: logins, sessions: make(map[string]string)}
+	for _, login := range logins {
+		token, err := forge.IssueToken(login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.sessions[login] = host + "-session-" + login
+		api.SetSession(s.sessions[login], 1, map[api.OAuthProvider]api.AccessToken{
+			api.GHES: {Token: token, URL: host, ConnectionID: connectionID},
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	s.router = gin.New()
+	facade := cachable.NewCacheFacade(context.Background(), s.backend)
+	s.router.Use(func(c *gin.Context) {
+		c.Set("cacheFacade", facade)
+		c.Next()
+	})
+	SetupRoutes(s.router)
+	return s
+}
+
+// get requests the path with the session of the first login
+func (s *forgeServer) get(path string) *httptest.ResponseRecorder {
+	return s.getAs(s.logins[0], path)
+}
+
+func (s *forgeServer) getAs(login, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: s.sessions[login]})
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// TestHandlers covers every route of SetupRoutes with the recorded GitHub responses. Not in the
+// table: Gitea needs an instance, see oauthgitea_test.go, and the mirror endpoints with mirroring
+// enabled are tested in TestMirrorEndpoints.
+func TestHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		noSession  bool
+		local      bool // The session has only a local provider with one repository
+		wantStatus int
+		want       []string // Parts of the response body
+	}{
+		{
+			name:       "user",
+			path:       "/api/oauth/loggedinuser",
+			wantStatus: http.StatusOK,
+			want:       []string{`"login":"octocat"`},
+		},
+		{
+			name:       "without session",
+			path:       "/api/oauth/loggedinuser",
+			noSession:  true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "repositories",
+			path:       "/api/oauth/repositories?first=2",
+			wantStatus: http.StatusOK,
+			want:       []string{`"name":"Hello-World"`, `"hasNextPage":true`},
+		},
+		{
+			name:       "repositories all",
+			path:       "/api/oauth/repositories/all",
+			wantStatus: http.StatusOK,
+			want:       []string{`"name":"Hello-World"`, `"name":"Spoon-Knife"`, `"name":"linguist"`, `"hasNextPage":false`},
+		},
+		{
+			name:       "repositories all local",
+			path:       "/api/oauth/repositories/all",
+			local:      true,
+			wantStatus: http.StatusOK,
+			want:       []string{`"local":`, `"name":"demo"`, `"hasNextPage":false`},
+		},
+		{
+			name:       "repository",
+			path:       "/api/oauth/repository?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusOK,
+			want:       []string{`"github":`, `"name":"Hello-World"`, `"defaultBranchRef":{"name":"master"}`},
+		},
+		{
+			name:       "repository not found",
+			path:       "/api/oauth/repository?provider=github&owner=octocat&name=missing",
+			wantStatus: http.StatusNotFound,
+			want:       []string{"Could not resolve to a Repository"},
+		},
+		{
+			// Served by GetOauthRepositoryContentsAsync, GetOauthRepositoryContents has no route
+			name:       "contents",
+			path:       "/api/oauth/repositorycontents?provider=github&owner=octocat&name=Hello-World&expression=master",
+			wantStatus: http.StatusOK,
+			want:       []string{`"name":"README"`, `"partial":false`},
+		},
+		{
+			name:       "content",
+			path:       "/api/oauth/repositorycontent?provider=github&owner=octocat&name=Hello-World&content=README&expression=master",
+			wantStatus: http.StatusOK,
+			want:       []string{`"mime":"text/plain`},
+		},
+		{
+			name:       "contributors",
+			path:       "/api/oauth/repositorycontributors?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusOK,
+			want:       []string{`"totalCount":2`, `"login":"octocat"`},
+		},
+		{
+			name:       "branch commit",
+			path:       "/api/oauth/repositorybranchcommit?provider=github&owner=octocat&name=Hello-World&expression=master",
+			wantStatus: http.StatusOK,
+			want:       []string{`"oid":"7fd1a60b01f91b314f59955a4e4d4e80d8edf11d"`},
+		},
+		{
+			name:       "branches",
+			path:       "/api/oauth/repositorybranches?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusOK,
+			want:       []string{`"name":"master"`, `"name":"octocat-patch-1"`},
+		},
+		{
+			name:       "branches all",
+			path:       "/api/oauth/repositorybranches/all?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusOK,
+			want:       []string{`"name":"master"`, `"name":"test"`, `"hasNextPage":false`},
+		},
+		{
+			name:       "tags",
+			path:       "/api/oauth/repositorytags?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusOK,
+			want:       []string{`"totalCount":0`},
+		},
+		{
+			name:       "tags all",
+			path:       "/api/oauth/repositorytags/all?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusOK,
+			want:       []string{`"totalCount":0`, `"hasNextPage":false`},
+		},
+		{
+			name:       "commits",
+			path:       "/api/oauth/repositorycommits?provider=github&owner=octocat&name=Hello-World&expression=master&first=2",
+			wantStatus: http.StatusOK,
+			want:       []string{`"messageHeadline":"Merge pull request #6 from Spaceghost/patch-1"`},
+		},
+		{
+			name:       "blame",
+			path:       "/api/oauth/repositoryblame?provider=github&owner=octocat&name=Hello-World&expression=master&path=README",
+			wantStatus: http.StatusOK,
+			want:       []string{`"startingLine":1`},
+		},
+		{
+			name:       "blame without path",
+			path:       "/api/oauth/repositoryblame?provider=github&owner=octocat&name=Hello-World",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "rate limit",
+			path:       "/api/oauth/ratelimit",
+			wantStatus: http.StatusOK,
+			want:       []string{`"github":`},
+		},
+		{
+			name:       "upstream status",
+			path:       "/api/oauth/upstreamstatus",
+			wantStatus: http.StatusOK,
+			want:       []string{`"github":`, `"breaker":`},
+		},
+		{
+			name:       "mirrors disabled",
+			path:       "/api/oauth/mirrors?provider=github",
+			wantStatus: http.StatusServiceUnavailable,
+			want:       []string{"MIRROR_DIR"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := replayRouter(t, strings.ReplaceAll(tt.name, " ", "_"))
+			if tt.local {
+				root := t.TempDir()
+				if _, err := git.PlainInit(filepath.Join(root, "alice", "demo"), true); err != nil {
+					t.Fatal(err)
+				}
+				api.SetSession(testSessionID, 1, map[api.OAuthProvider]api.AccessToken{
+					api.Local: {URL: root, ConnectionID: 2},
+				})
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if !tt.noSession {
+				req.AddCookie(&http.Cookie{Name: "session_id", Value: testSessionID})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body does not contain %s: %s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
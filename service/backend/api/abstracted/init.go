@@ -5,10 +5,22 @@ import "github.com/gin-gonic/gin"
 func SetupRoutes(router *gin.Engine) {
 	router.GET("/api/oauth/loggedinuser", GetOAuthUser)
 	router.GET("/api/oauth/repositories", GetOAuthRepositories)
+	router.GET("/api/oauth/repositories/all", GetOAuthRepositoriesAll)
 	router.GET("/api/oauth/repository", GetOAuthRepository)
 	router.GET("/api/oauth/repositorycontents", GetOauthRepositoryContentsAsync)
 	// router.GET("/api/oauth/repositorycontents", GetOauthRepositoryContents)
 	router.GET("/api/oauth/repositorycontributors", GetOAuthRepositoryContributors)
 	router.GET("/api/oauth/repositorybranchcommit", GetOAuthRepositoryBranchCommit)
 	router.GET("/api/oauth/repositorycontent", GetOAuthRepositoryContent)
+	router.GET("/api/oauth/repositorybranches", GetOAuthRepositoryBranches)
+	router.GET("/api/oauth/repositorytags", GetOAuthRepositoryTags)
+	router.GET("/api/oauth/repositorybranches/all", GetOAuthRepositoryBranchesAll)
+	router.GET("/api/oauth/repositorytags/all", GetOAuthRepositoryTagsAll)
+	router.GET("/api/oauth/repositorycommits", GetOAuthRepositoryCommits)
+	router.GET("/api/oauth/repositoryblame", GetOAuthRepositoryBlame)
+	router.GET("/api/oauth/ratelimit", GetOAuthRateLimit)
+	router.GET("/api/oauth/upstreamstatus", GetOAuthUpstreamStatus)
+	router.GET("/api/oauth/mirrors", GetOAuthMirrors)
+	router.POST("/api/oauth/mirrors", CreateOAuthMirror)
+	router.DELETE("/api/oauth/mirrors", DeleteOAuthMirror)
 }
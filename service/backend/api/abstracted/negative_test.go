@@ -0,0 +1,42 @@
+package abstracted
+
+import (
+	"githubclone-backend/api/common"
+	"net/http"
+	"testing"
+)
+
+// A missing file is answered from the negative cache entry without another upstream request
+func TestNegativeCache(t *testing.T) {
+	s := forgeRouter(t, "negative.fake.forge", 6, "octocat")
+	const path = "/api/oauth/repositorycontent?provider=github_enterprise&owner=octocat&name=hello-world&expression=main&content=MISSING.md"
+
+	if w := s.get(path); w.Code != http.StatusNotFound || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: status %d, X-Cache %q: %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	requests := s.forge.Requests()
+	if w := s.get(path); w.Code != http.StatusNotFound || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("second request: status %d, X-Cache %q: %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if s.forge.Requests() != requests {
+		t.Error("the missing file was requested again")
+	}
+}
+
+func TestNegativeStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&common.UpstreamError{Status: http.StatusNotFound, Upstream: http.StatusNotFound, Message: "no rate limit here"}, http.StatusNotFound},
+		{&common.UpstreamError{Status: http.StatusConflict, Upstream: http.StatusConflict}, http.StatusConflict},
+		{&common.UpstreamError{Status: http.StatusForbidden, Upstream: http.StatusForbidden, RateLimited: true}, 0},
+		{&common.UpstreamError{Status: http.StatusForbidden, Upstream: http.StatusUnauthorized}, 0},
+		{&common.UpstreamError{Status: http.StatusBadGateway, Upstream: http.StatusInternalServerError}, 0},
+	}
+	for _, tt := range tests {
+		if got, _ := negativeStatus(tt.err); got != tt.want {
+			t.Errorf("negativeStatus(%+v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
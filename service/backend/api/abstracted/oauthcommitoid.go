@@ -0,0 +1,69 @@
+package abstracted
+
+import (
+	"fmt"
+	"githubclone-backend/api"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/github"
+	"githubclone-backend/cachable"
+	"log"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Trees and file contents at a commit never change. The expression of a request, e.g. a branch,
+// is resolved to the commit id with the branch commit cache first, which expires quickly. The
+// trees and contents are cached under the commit id without expiry, see cachable.CommitKey.
+
+var commitOID = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// resolveOID returns the commit id of expression, "" if it cannot be resolved. The answer is the
+// same as of GetOAuthRepositoryBranchCommit and shares its cache entry. Local repositories are
+// read from the disk, their expressions are not resolved.
+func resolveOID(c *gin.Context, provider, owner, name, expression string) string {
+	if commitOID.MatchString(expression) {
+		return expression
+	}
+	if expression == "" {
+		return ""
+	}
+	access, err := getSessionAccess(c, provider)
+	if err != nil {
+		return ""
+	}
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	params := map[string]interface{}{
+		"owner":      owner,
+		"name":       name,
+		"expression": expression,
+	}
+	cacheKey := fmt.Sprintf("branchcommit:%s:%s:%s:%s", provider, owner, name, expression)
+	view := facade.GitHubRepositoryBranchCommitCache.For(principalFor(c, provider, *access, params))
+	data, found, err := view.Get(cacheKey)
+	if err != nil {
+		log.Printf("cache read error: %v", err)
+	}
+	if !found || data == nil {
+		switch api.OAuthProvider(provider) {
+		case api.Github, api.GHES:
+			var result partialResult[github.RepositoryBranchCommit]
+			result, err = common.Coalesce(upstreamContext(c, *access), common.CoalesceKey(view.Key(cacheKey), access.Token),
+				graphQLFetch(facade, *access, github.GithubRepositoryBranchCommitQuery, params, view, cacheKey, false))
+			data = result.data
+		case api.Gitea:
+			data, err = fetchCoalesced(upstreamContext(c, *access), fetchGiteaBranchCommit, restAPIEndpoint(api.Gitea, access.URL), access.Token, params, view, cacheKey, false)
+		default:
+			return ""
+		}
+		if err != nil || data == nil {
+			log.Printf("Expression %s of %s/%s cannot be resolved, it's used directly: %v", expression, owner, name, err)
+			return ""
+		}
+	}
+	oid := data.Data.Repository.Ref.Target.OID
+	if !commitOID.MatchString(oid) {
+		return ""
+	}
+	return oid
+}
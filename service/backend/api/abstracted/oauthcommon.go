@@ -1,18 +1,30 @@
 package abstracted
 
 import (
+	"context"
 	"fmt"
 	"githubclone-backend/api"
 	"githubclone-backend/api/common"
+	"githubclone-backend/cachable"
 	"githubclone-backend/cache"
 
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func GetOAuthCommonProvider[T any](c *gin.Context, provider string, gql string, validParams map[string]interface{}, cache *cache.TypedCache[T], cacheKey string, islog bool) {
+// partialResult is the answer of a coalesced GraphQL query
+type partialResult[T any] struct {
+	data     *T
+	warnings []common.GraphQLError
+}
+
+// GetOAuthCommonProvider answers with the result of a GraphQL query. A stale cache entry is
+// returned immediately and refreshed in the background with the token of the user.
+func GetOAuthCommonProvider[T any](c *gin.Context, provider string, gql string, validParams map[string]interface{}, typedCache *cache.TypedCache[T], cacheKey string, islog bool) {
 	sessionID, err := c.Cookie("session_id")
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session ID found in cookie"})
@@ -27,47 +39,59 @@ func GetOAuthCommonProvider[T any](c *gin.Context, provider string, gql string,
 		switch api.OAuthProvider(provider) {
 		case api.GHES, api.Github:
 			userdata := make(map[string]interface{})
-			endpoint := value.URL
 			token := value.Token
 			if islog {
 				log.Printf("endpoint=%s, token=%s", value.URL, value.Token)
 			}
-			githubData, found, err := cache.Get(cacheKey)
-			if err != nil {
+			facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+			view := typedCache.For(principalFor(c, provider, value, validParams))
+			// Concurrent misses and refreshes share one query, only this call writes the cache
+			coalesceKey := common.CoalesceKey(view.Key(cacheKey), token)
+			fetch := graphQLFetch(facade, value, gql, validParams, view, cacheKey, islog)
+			githubData, storedAt, status, err := view.Lookup(cacheKey)
+			if negative, ok := negativeError(err); ok {
+				setCacheHeaders(c, status, storedAt)
+				upstreamError(c, "GraphQL request failed", negative)
+				return
+			} else if err != nil {
 				log.Printf("cache read error: %v", err)
 			}
 			if islog {
-				log.Printf("Cache: githubData: %v, found: %v, err: %v", githubData, found, err)
-			}
-			if !found || githubData == nil {
-				githubData, err = common.SendGraphQLQuery[T](
-					graphqlgithubprefix+endpoint+graphqlgithubpath,
-					gql,
-					token,
-					validParams,
-					islog,
-				)
+				log.Printf("Cache: githubData: %v, status: %v, err: %v", githubData, status, err)
+			}
+			if status == cache.StatusStale {
+				refreshInBackground(value, coalesceKey, fetch)
+			}
+			if status == cache.StatusMiss || githubData == nil {
+				setCacheHeaders(c, cache.StatusMiss, time.Time{})
+				result, err := common.Coalesce(upstreamContext(c, value), coalesceKey, fetch)
+				githubData = result.data
 				if islog {
 					log.Printf("githubdata=%v, err=%v", githubData, err)
 				}
+				if stale, ok := staleFallback(c, view, cacheKey, err); ok {
+					githubData, err = stale, nil
+				}
 				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "GraphQL request failed", "details": err.Error()})
+					upstreamError(c, "GraphQL request failed", err)
 					return
 				}
-				if err := cache.Set(cacheKey, *githubData); err != nil {
-					log.Printf("cache write error: %v", err)
+				if len(result.warnings) > 0 {
+					userdata["warnings"] = result.warnings
 				}
 				if islog {
 					log.Printf("Request: githubdata: %v", githubData)
 				}
+			} else {
+				setCacheHeaders(c, status, storedAt)
 			}
 			// You're able to manipulate the data here or put it in the cache.
 			if islog {
 				log.Printf("Assign: %v", githubData)
 			}
-			userdata[string(api.Github)] = githubData
+			userdata[provider] = githubData
 			c.JSON(http.StatusOK, userdata)
-		case api.Gitlab:
+		case api.Gitlab, api.GitlabSelfManaged:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "unsupported provider", "details": provider})
 			return
 		}
@@ -104,6 +128,7 @@ func GetOAuthCommonProviderIntern[T any](c *gin.Context, provider string, gql st
 		}
 
 		data, err := common.SendGraphQLQuery[T](
+			upstreamContext(c, value),
 			graphqlgithubprefix+endpoint+graphqlgithubpath,
 			gql,
 			token,
@@ -115,7 +140,7 @@ func GetOAuthCommonProviderIntern[T any](c *gin.Context, provider string, gql st
 		}
 		return data, nil
 
-	case api.Gitlab:
+	case api.Gitlab, api.GitlabSelfManaged:
 		return nil, fmt.Errorf("provider %s currently not supported", provider)
 
 	default:
@@ -125,8 +150,8 @@ func GetOAuthCommonProviderIntern[T any](c *gin.Context, provider string, gql st
 
 func GetOAuthCommonProviderREST[T any](
 	c *gin.Context, provider string, validParams map[string]interface{},
-	fn func(endpoint, token string, params map[string]interface{}, islog bool) (*T, error),
-	cache *cache.TypedCache[T], cacheKey string,
+	fn func(ctx context.Context, endpoint, token string, params map[string]interface{}, islog bool) (*T, error),
+	typedCache *cache.TypedCache[T], cacheKey string,
 	islog bool) {
 	sessionID, err := c.Cookie("session_id")
 	if err != nil {
@@ -139,45 +164,44 @@ func GetOAuthCommonProviderREST[T any](
 		return
 	}
 
-	cachedData, found, err := cache.Get(cacheKey)
-	if islog && err == nil {
-		log.Printf("Cache hit for %s", cacheKey)
-	}
-	if found && cachedData != nil && err == nil {
-		userdata := make(map[string]interface{})
-		userdata[string(api.OAuthProvider(provider))] = cachedData
-		c.JSON(http.StatusOK, userdata)
-		return
-	}
-
 	if value, ok := session[api.OAuthProvider(provider)]; ok {
+		view := typedCache.For(principalFor(c, provider, value, validParams))
+		if cachedData, ok, err := cachedOrRefresh(c, provider, value, validParams, fn, view, cacheKey, islog); err != nil {
+			upstreamError(c, "REST API request failed", err)
+			return
+		} else if ok {
+			userdata := make(map[string]interface{})
+			userdata[provider] = cachedData
+			c.JSON(http.StatusOK, userdata)
+			return
+		}
+
 		switch api.OAuthProvider(provider) {
-		case api.GHES, api.Github:
+		case api.GHES, api.Github, api.Gitea, api.Local:
 			userdata := make(map[string]interface{})
-			endpoint := value.URL
+			endpoint := restAPIEndpoint(api.OAuthProvider(provider), value.URL)
 			token := value.Token
 			if islog {
 				log.Printf("endpoint=%s, token=%s", value.URL, value.Token)
 			}
-			githubData, err := fn(
-				restapigithubprefix+endpoint+restapigithubpath,
-				token,
-				validParams,
-				islog,
-			)
+			githubData, err := fetchCoalesced(upstreamContext(c, value), fn, endpoint, token, validParams, view, cacheKey, islog)
 			if islog {
 				log.Printf("githubdata=%v, err=%v", githubData, err)
 			}
+			if stale, ok := staleFallback(c, view, cacheKey, err); ok {
+				githubData, err = stale, nil
+			} else if err == nil {
+				rememberVisibility(c.MustGet("cacheFacade").(*cachable.CacheFacade), value, validParams, githubData)
+			}
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "REST API request failed", "details": err.Error()})
+				upstreamError(c, "REST API request failed", err)
 				return
 			}
-			cache.Set(cacheKey, *githubData)
 			// You're able to manipulate the data here or put it in the cache.
-			userdata[string(api.Github)] = githubData
+			userdata[provider] = githubData
 			c.JSON(http.StatusOK, userdata)
 
-		case api.Gitlab:
+		case api.Gitlab, api.GitlabSelfManaged:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "unsupported provider", "details": provider})
 			return
 		}
@@ -189,8 +213,8 @@ func GetOAuthCommonProviderREST[T any](
 
 func GetOAuthCommonProviderRESTIntern[T any](
 	c *gin.Context, provider string, validParams map[string]interface{},
-	fn func(endpoint, token string, params map[string]interface{}, islog bool) (*T, error),
-	cache *cache.TypedCache[T], cacheKey string,
+	fn func(ctx context.Context, endpoint, token string, params map[string]interface{}, islog bool) (*T, error),
+	typedCache *cache.TypedCache[T], cacheKey string,
 	islog bool) (*T, error, bool) {
 	sessionID, err := c.Cookie("session_id")
 	if err != nil {
@@ -201,43 +225,198 @@ func GetOAuthCommonProviderRESTIntern[T any](
 		return nil, fmt.Errorf("token fetch failed: %w", err), false
 	}
 
-	cachedData, found, err := cache.Get(cacheKey)
-	if islog && err == nil {
-		log.Printf("Cache hit for %s", cacheKey)
-	}
-	if found && cachedData != nil && err == nil {
-		return cachedData, nil, true
-	}
-
 	if value, ok := session[api.OAuthProvider(provider)]; ok {
+		view := typedCache.For(principalFor(c, provider, value, validParams))
+		if cachedData, ok, err := cachedOrRefresh(c, provider, value, validParams, fn, view, cacheKey, islog); err != nil {
+			return nil, fmt.Errorf("REST API request failed: %w", err), true
+		} else if ok {
+			return cachedData, nil, true
+		}
+
 		switch api.OAuthProvider(provider) {
-		case api.GHES, api.Github:
-			endpoint := value.URL
+		case api.GHES, api.Github, api.Gitea, api.Local:
+			endpoint := restAPIEndpoint(api.OAuthProvider(provider), value.URL)
 			token := value.Token
 			if islog {
 				log.Printf("endpoint=%s, token=%s", value.URL, value.Token)
 			}
-			githubData, err := fn(
-				restapigithubprefix+endpoint+restapigithubpath,
-				token,
-				validParams,
-				islog,
-			)
+			githubData, err := fetchCoalesced(upstreamContext(c, value), fn, endpoint, token, validParams, view, cacheKey, islog)
 			if islog {
 				log.Printf("githubdata=%v, err=%v", githubData, err)
 			}
+			if stale, ok := staleFallback(c, view, cacheKey, err); ok {
+				return stale, nil, true
+			}
 			if err != nil {
 
-				return nil, fmt.Errorf("REST API request failed: %s", err), false
+				return nil, fmt.Errorf("REST API request failed: %w", err), false
 			}
-			cache.Set(cacheKey, *githubData)
 			// You're able to manipulate the data here or put it in the cache.
 			return githubData, nil, false
 
-		case api.Gitlab:
+		case api.Gitlab, api.GitlabSelfManaged:
 			return nil, fmt.Errorf("provider %s currently not supported", provider), false
 		}
 	}
 	return nil, fmt.Errorf("provider %s currently not supported", provider), false
 
 }
+
+// graphQLFetch returns the query of GetOAuthCommonProvider for Coalesce, complete answers are
+// written to the cache
+func graphQLFetch[T any](
+	facade *cachable.CacheFacade, access api.AccessToken, gql string, validParams map[string]interface{},
+	view *cache.View[T], cacheKey string,
+	islog bool) func(ctx context.Context) (partialResult[T], error) {
+	return func(ctx context.Context) (partialResult[T], error) {
+		data, warnings, err := common.SendGraphQLQueryPartial[T](
+			withOperation(ctx, cacheKey),
+			graphqlgithubprefix+access.URL+graphqlgithubpath,
+			gql,
+			access.Token,
+			validParams,
+			islog,
+		)
+		if err != nil {
+			rememberNegative(view, cacheKey, err)
+			return partialResult[T]{}, err
+		}
+		// Partial data is passed on, but not cached
+		if len(warnings) == 0 {
+			if err := view.Set(cacheKey, *data); err != nil {
+				log.Printf("cache write error: %v", err)
+			}
+			rememberVisibility(facade, access, validParams, data)
+		}
+		return partialResult[T]{data, warnings}, nil
+	}
+}
+
+// cachedOrRefresh returns the cached entry of a REST request and sets the cache headers. A stale
+// entry is refreshed in the background, on a miss the caller loads the data. A cached negative
+// entry is returned as error.
+func cachedOrRefresh[T any](
+	c *gin.Context, provider string, access api.AccessToken, validParams map[string]interface{},
+	fn func(ctx context.Context, endpoint, token string, params map[string]interface{}, islog bool) (*T, error),
+	view *cache.View[T], cacheKey string,
+	islog bool) (*T, bool, error) {
+	cachedData, storedAt, status, err := view.Lookup(cacheKey)
+	if negative, ok := negativeError(err); ok {
+		setCacheHeaders(c, status, storedAt)
+		return nil, true, negative
+	} else if err != nil {
+		log.Printf("cache read error: %v", err)
+	}
+	if status == cache.StatusMiss || cachedData == nil {
+		setCacheHeaders(c, cache.StatusMiss, time.Time{})
+		return nil, false, nil
+	}
+	if islog {
+		log.Printf("Cache %v for %s", status, cacheKey)
+	}
+	if status == cache.StatusStale {
+		facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+		endpoint := restAPIEndpoint(api.OAuthProvider(provider), access.URL)
+		refreshInBackground(access, common.CoalesceKey(view.Key(cacheKey), access.Token), func(ctx context.Context) (*T, error) {
+			data, err := fn(withOperation(ctx, cacheKey), endpoint, access.Token, validParams, islog)
+			if err != nil {
+				rememberNegative(view, cacheKey, err)
+				return nil, err
+			}
+			if err := view.Set(cacheKey, *data); err != nil {
+				log.Printf("cache write error: %v", err)
+			}
+			rememberVisibility(facade, access, validParams, data)
+			return data, nil
+		})
+	}
+	setCacheHeaders(c, status, storedAt)
+	return cachedData, true, nil
+}
+
+// fetchCoalesced calls fn once for concurrent misses of the same key and token and caches the answer
+// in the scope of the view
+func fetchCoalesced[T any](
+	ctx context.Context,
+	fn func(ctx context.Context, endpoint, token string, params map[string]interface{}, islog bool) (*T, error),
+	endpoint, token string, validParams map[string]interface{},
+	cache *cache.View[T], cacheKey string,
+	islog bool) (*T, error) {
+	return common.Coalesce(ctx, common.CoalesceKey(cache.Key(cacheKey), token), func(ctx context.Context) (*T, error) {
+		data, err := fn(withOperation(ctx, cacheKey), endpoint, token, validParams, islog)
+		if err != nil {
+			rememberNegative(cache, cacheKey, err)
+			return nil, err
+		}
+		if err := cache.Set(cacheKey, *data); err != nil {
+			log.Printf("cache write error: %v", err)
+		}
+		return data, nil
+	})
+}
+
+// getSessionAccess returns the access token and the URL of the session for a provider
+func getSessionAccess(c *gin.Context, provider string) (*api.AccessToken, error) {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		return nil, fmt.Errorf("missing session_id cookie: %w", err)
+	}
+	session, err := api.GetToken(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("token fetch failed: %w", err)
+	}
+	value, ok := session[api.OAuthProvider(provider)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+	return &value, nil
+}
+
+// visibilityReporter is implemented by answers which tell whether a repository is private,
+// ok is false if the answer contains no repository
+type visibilityReporter interface {
+	RepositoryPrivate() (private bool, ok bool)
+}
+
+func visibilityKey(connectionID uint, owner, name string) string {
+	return fmt.Sprintf("%d:%s/%s", connectionID, strings.ToLower(owner), strings.ToLower(name))
+}
+
+// principalFor returns for whom the cache entries of a request are stored. The data of a
+// repository is shared with the other users of the connection only while the repository is
+// known to be public, otherwise every token has its own entries and the provider checks the
+// access of each token. A local connection has no permissions, its users see all repositories.
+func principalFor(c *gin.Context, provider string, access api.AccessToken, params map[string]interface{}) cache.Principal {
+	principal := cache.Principal{Connection: access.ConnectionID, Token: access.Token}
+	if api.OAuthProvider(provider) == api.Local {
+		principal.Shared = true
+		return principal
+	}
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	if owner == "" || name == "" {
+		return principal
+	}
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	visibility, found, err := facade.RepositoryVisibilityCache.Get(visibilityKey(access.ConnectionID, owner, name))
+	principal.Shared = err == nil && found && !visibility.Private
+	return principal
+}
+
+// rememberVisibility stores the visibility of the repository of a request if the answer tells it.
+// It takes the facade instead of the gin context, it's also called after the request is answered.
+func rememberVisibility(facade *cachable.CacheFacade, access api.AccessToken, params map[string]interface{}, data any) {
+	reporter, ok := data.(visibilityReporter)
+	if !ok {
+		return
+	}
+	private, ok := reporter.RepositoryPrivate()
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	if !ok || owner == "" || name == "" {
+		return
+	}
+	if err := facade.RepositoryVisibilityCache.Set(visibilityKey(access.ConnectionID, owner, name), cachable.RepositoryVisibility{Private: private}); err != nil {
+		log.Printf("cache write error: %v", err)
+	}
+}
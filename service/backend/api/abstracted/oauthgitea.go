@@ -0,0 +1,390 @@
+package abstracted
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/gitea"
+	"githubclone-backend/api/github"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Gitea has no GraphQL api, every answer is assembled from REST calls and mapped into the
+// GitHub shaped types, so that the frontend does not see a difference between the providers.
+// The contents and the file endpoint of Gitea are compatible to GitHub and use the GitHub helpers.
+
+const giteaMaxContributorPages = 5 // Gitea has no contributor api, they're counted from the latest commits
+const giteaCommitPageSize = 50
+const giteaCommitRequests = 8 // Parallel commit requests of mergeGiteaCommitInfoIntoEntries
+
+// giteaTotalCount reads the number of all entries of a paginated answer
+func giteaTotalCount(resp *http.Response, fallback int) int {
+	if total, err := strconv.Atoi(resp.Header.Get("X-Total-Count")); err == nil {
+		return total
+	}
+	return fallback
+}
+
+// giteaRef removes the prefix of a fully qualified branch name, Gitea expects short names or commit SHAs
+func giteaRef(expression string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(expression, ":"), "refs/heads/")
+}
+
+func convertGiteaToGitHubUser(giteaUser gitea.GiteaUser) github.GitHubUser {
+	var user github.GitHubUser
+	user.Data.Viewer.Login = giteaUser.Login
+	user.Data.Viewer.Name = giteaUser.FullName
+	user.Data.Viewer.Email = giteaUser.Email
+	user.Data.Viewer.Bio = giteaUser.Bio
+	user.Data.Viewer.AvatarURL = giteaUser.AvatarURL
+	user.Data.Viewer.CreatedAt = giteaUser.Created
+	user.Data.Viewer.Company = "" // Gitea does not have `company`, therefore empty
+	user.Data.Viewer.Location = giteaUser.Location
+	user.Data.Viewer.WebsiteURL = giteaUser.Website
+	return user
+}
+
+func convertGiteaToGitHubRepositoryNode(repo gitea.GiteaRepository) github.RepositoryNode {
+	node := github.RepositoryNode{
+		Name:           repo.Name,
+		Description:    repo.Description,
+		URL:            repo.HTMLURL,
+		IsArchived:     repo.Archived,
+		IsPrivate:      repo.Private,
+		IsFork:         repo.Fork,
+		CreatedAt:      repo.CreatedAt,
+		UpdatedAt:      repo.UpdatedAt,
+		PushedAt:       repo.UpdatedAt, // Gitea does not report the last push separately
+		StargazerCount: repo.StarsCount,
+		ForkCount:      repo.ForksCount,
+	}
+	if repo.Parent != nil {
+		node.Parent = &struct {
+			NameWithOwner string `json:"nameWithOwner"`
+			URL           string `json:"url"`
+		}{
+			NameWithOwner: repo.Parent.FullName,
+			URL:           repo.Parent.HTMLURL,
+		}
+	}
+	return node
+}
+
+func fetchGiteaUser(ctx context.Context, endpoint string, token string, islog bool) (*github.GitHubUser, error) {
+	result, err := common.SendRestAPIQuery[gitea.GiteaUser](ctx, endpoint, "user", token, islog)
+	if err != nil {
+		return nil, err
+	}
+	user := convertGiteaToGitHubUser(*result.Result)
+	return &user, nil
+}
+
+// fetchGiteaRepositories lists the repositories of the user, the cursor `after` is the number of the next page
+// giteaSortFields maps the order fields of the GitHub API to the sort parameter of the search
+var giteaSortFields = map[string]string{
+	"NAME":            "alpha",
+	"CREATED_AT":      "created",
+	"UPDATED_AT":      "updated",
+	"STARGAZER_COUNT": "stars",
+}
+
+func fetchGiteaRepositories(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.GitHubRepositoriesOfViewer, error) {
+	limit, _ := params["first"].(int)
+	if limit < 1 {
+		limit = common.DefaultFirst
+	}
+	page := 1
+	if after, _ := params["after"].(string); after != "" {
+		if parsed, err := strconv.Atoi(after); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+
+	sortField, ok := giteaSortFields[fmt.Sprint(params["field"])]
+	if !ok {
+		sortField = "updated"
+	}
+	order := "desc"
+	if params["direction"] == "ASC" {
+		order = "asc"
+	}
+
+	user, err := common.SendRestAPIQuery[gitea.GiteaUser](ctx, endpoint, "user", token, islog)
+	if err != nil {
+		return nil, err
+	}
+	// /user/repos cannot sort, the search returns the same repositories of the user in order
+	reposPath := fmt.Sprintf("repos/search?uid=%d&page=%d&limit=%d&sort=%s&order=%s", user.Result.ID, page, limit, sortField, order)
+	repos, err := common.SendRestAPIQuery[gitea.GiteaRepositorySearch](ctx, endpoint, reposPath, token, islog)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.GitHubRepositoriesOfViewer
+	result.Data.Viewer.AvatarURL = user.Result.AvatarURL
+	nodes := make([]github.RepositoryNode, 0, len(repos.Result.Data))
+	for _, repo := range repos.Result.Data {
+		nodes = append(nodes, convertGiteaToGitHubRepositoryNode(repo))
+	}
+	total := giteaTotalCount(repos.Resp, (page-1)*limit+len(nodes))
+	result.Data.Viewer.Repositories.Nodes = nodes
+	result.Data.Viewer.Repositories.PageInfo = common.PageInfo{
+		HasNextPage:     page*limit < total,
+		HasPreviousPage: page > 1,
+		StartCursor:     strconv.Itoa(page),
+		EndCursor:       strconv.Itoa(page + 1),
+	}
+	return &result, nil
+}
+
+// fetchGiteaRepository collects the repository metadata, which GitHub returns with a single GraphQL query
+func fetchGiteaRepository(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.RepositoryNodeWithAttributes, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	repoPath := fmt.Sprintf("repos/%s/%s", url.PathEscape(owner), url.PathEscape(name))
+
+	repoResp, err := common.SendRestAPIQuery[gitea.GiteaRepository](ctx, endpoint, repoPath, token, islog)
+	if err != nil {
+		return nil, err
+	}
+	repo := *repoResp.Result
+
+	var result github.RepositoryNodeWithAttributes
+	extended := &result.Data.Repository
+	extended.RepositoryNode = convertGiteaToGitHubRepositoryNode(repo)
+	extended.Owner.AvatarURL = repo.Owner.AvatarURL
+	extended.DefaultBranchRef.Name = repo.DefaultBranch
+	extended.Watchers.TotalCount = repo.WatchersCount
+	if len(repo.Licenses) > 0 {
+		extended.LicenseInfo = github.RepositoryLicenseInfo{Key: strings.ToLower(repo.Licenses[0]), Name: repo.Licenses[0]}
+	}
+
+	languages, err := common.SendRestAPIQuery[gitea.GiteaLanguages](ctx, endpoint, repoPath+"/languages", token, islog)
+	if err != nil {
+		return nil, err
+	}
+	for language, size := range *languages.Result {
+		extended.Languages.TotalSize += size
+		extended.Languages.Edges = append(extended.Languages.Edges, github.RepositoryLanguageEdge{
+			Size: size,
+			Node: github.RepositoryLanguage{Name: language},
+		})
+	}
+	sort.Slice(extended.Languages.Edges, func(i, j int) bool {
+		return extended.Languages.Edges[i].Size > extended.Languages.Edges[j].Size
+	})
+	if len(extended.Languages.Edges) > 10 {
+		extended.Languages.Edges = extended.Languages.Edges[:10]
+	}
+
+	// Same limits as in the GitHub query: 9 branches, 10 tags and the latest release
+	branches, err := common.SendRestAPIQuery[[]gitea.GiteaBranch](ctx, endpoint, repoPath+"/branches?limit=9", token, islog)
+	if err != nil {
+		return nil, err
+	}
+	extended.Branches.TotalCount = giteaTotalCount(branches.Resp, len(*branches.Result))
+	for _, branch := range *branches.Result {
+		extended.Branches.Nodes = append(extended.Branches.Nodes, struct {
+			Name string `json:"name"`
+		}{Name: branch.Name})
+	}
+
+	tags, err := common.SendRestAPIQuery[[]gitea.GiteaTag](ctx, endpoint, repoPath+"/tags?limit=10", token, islog)
+	if err != nil {
+		return nil, err
+	}
+	extended.Tags.TotalCount = giteaTotalCount(tags.Resp, len(*tags.Result))
+	for _, tag := range *tags.Result {
+		extended.Tags.Nodes = append(extended.Tags.Nodes, struct {
+			Name string `json:"name"`
+		}{Name: tag.Name})
+	}
+
+	releases, err := common.SendRestAPIQuery[[]gitea.GiteaRelease](ctx, endpoint, repoPath+"/releases?limit=1", token, islog)
+	if err != nil {
+		return nil, err
+	}
+	extended.Releases.TotalCount = giteaTotalCount(releases.Resp, len(*releases.Result))
+	for _, release := range *releases.Result {
+		extended.Releases.Nodes = append(extended.Releases.Nodes, struct {
+			Name      string `json:"name"`
+			TagName   string `json:"tagName"`
+			CreatedAt string `json:"createdAt"`
+			IsDraft   bool   `json:"isDraft"`
+			IsLatest  bool   `json:"isLatest"`
+		}{
+			Name:      release.Name,
+			TagName:   release.TagName,
+			CreatedAt: release.CreatedAt,
+			IsDraft:   release.IsDraft,
+			IsLatest:  !release.IsDraft && !release.IsPrerelease,
+		})
+	}
+	// Gitea has no deployments, the empty structure is returned
+	return &result, nil
+}
+
+// fetchGiteaBranchCommit returns the head commit of a branch, tag or commit SHA
+func fetchGiteaBranchCommit(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.RepositoryBranchCommit, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	expression, _ := params["expression"].(string)
+
+	commitsPath := fmt.Sprintf("repos/%s/%s/commits?limit=1&%s", url.PathEscape(owner), url.PathEscape(name), gitea.GiteaCommitListOptions)
+	if ref := giteaRef(expression); ref != "" {
+		commitsPath += "&sha=" + url.QueryEscape(ref)
+	}
+	commits, err := common.SendRestAPIQuery[[]gitea.GiteaCommit](ctx, endpoint, commitsPath, token, islog)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryBranchCommit
+	if len(*commits.Result) == 0 {
+		return &result, nil
+	}
+	commit := (*commits.Result)[0]
+	target := &result.Data.Repository.Ref.Target
+	target.OID = commit.SHA
+	target.CommittedDate = commit.Commit.Committer.Date
+	target.MessageHeadline = strings.SplitN(commit.Commit.Message, "\n", 2)[0]
+	target.Author.Name = commit.Commit.Author.Name
+	target.Author.Email = commit.Commit.Author.Email
+	if commit.Author != nil {
+		target.Author.User = &struct {
+			Login     string `json:"login"`
+			AvatarURL string `json:"avatarUrl"`
+			URL       string `json:"url"`
+		}{
+			Login:     commit.Author.Login,
+			AvatarURL: commit.Author.AvatarURL,
+			URL:       commit.Author.HTMLURL,
+		}
+	}
+	target.History.TotalCount = giteaTotalCount(commits.Resp, 1)
+	return &result, nil
+}
+
+// fetchGiteaContributors counts the authors of the latest commits of the default branch
+func fetchGiteaContributors(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.RepositoryContributor, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	limit := 14
+
+	contributions := make(map[string]*github.RepositoryContributorNode)
+	for page := 1; page <= giteaMaxContributorPages; page++ {
+		commitsPath := fmt.Sprintf("repos/%s/%s/commits?page=%d&limit=%d&%s", url.PathEscape(owner), url.PathEscape(name), page, giteaCommitPageSize, gitea.GiteaCommitListOptions)
+		commits, err := common.SendRestAPIQuery[[]gitea.GiteaCommit](ctx, endpoint, commitsPath, token, islog)
+		if err != nil {
+			// An empty repository is answered with 409
+			var upstreamErr *common.UpstreamError
+			if errors.As(err, &upstreamErr) && upstreamErr.Upstream == http.StatusConflict {
+				break
+			}
+			return nil, err
+		}
+		for _, commit := range *commits.Result {
+			if commit.Author == nil {
+				continue // Commits without an account on the instance are not shown as contributors
+			}
+			node, ok := contributions[commit.Author.Login]
+			if !ok {
+				node = &github.RepositoryContributorNode{
+					Login:     commit.Author.Login,
+					AvatarUrl: commit.Author.AvatarURL,
+					HtmlUrl:   commit.Author.HTMLURL,
+				}
+				contributions[commit.Author.Login] = node
+			}
+			node.Contributions++
+		}
+		if len(*commits.Result) < giteaCommitPageSize {
+			break
+		}
+	}
+
+	nodes := make([]github.RepositoryContributorNode, 0, len(contributions))
+	for _, node := range contributions {
+		nodes = append(nodes, *node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Contributions != nodes[j].Contributions {
+			return nodes[i].Contributions > nodes[j].Contributions
+		}
+		return nodes[i].Login < nodes[j].Login
+	})
+	total := len(nodes)
+	if len(nodes) > limit {
+		nodes = nodes[:limit]
+	}
+	return &github.RepositoryContributor{
+		TotalCount: total,
+		Nodes:      nodes,
+	}, nil
+}
+
+// mergeGiteaCommitInfoIntoEntries adds the last commit to each entry. Gitea needs one request per
+// entry, at most giteaCommitRequests run at the same time. The first error cancels the others.
+func mergeGiteaCommitInfoIntoEntries(ctx context.Context, endpoint, token, owner, repo, expression string, entries []github.RepositoryEntryTreeCommit, islog bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, giteaCommitRequests)
+	for i := range entries {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(entry *github.RepositoryEntryTreeCommit) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := mergeGiteaCommitInfo(ctx, endpoint, token, owner, repo, expression, entry, islog); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}(&entries[i])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// mergeGiteaCommitInfo adds the last commit of the path of entry
+func mergeGiteaCommitInfo(ctx context.Context, endpoint, token, owner, repo, expression string, entry *github.RepositoryEntryTreeCommit, islog bool) error {
+	commitsPath := fmt.Sprintf("repos/%s/%s/commits?limit=1&path=%s&%s", url.PathEscape(owner), url.PathEscape(repo), url.QueryEscape(entry.Name), gitea.GiteaCommitListOptions)
+	if ref := giteaRef(expression); ref != "" {
+		commitsPath += "&sha=" + url.QueryEscape(ref)
+	}
+	commits, err := common.SendRestAPIQuery[[]gitea.GiteaCommit](ctx, endpoint, commitsPath, token, islog)
+	if err != nil {
+		return err
+	}
+	if len(*commits.Result) == 0 {
+		if islog {
+			log.Printf("No commit found for %s", entry.Name)
+		}
+		return nil
+	}
+	commit := (*commits.Result)[0]
+	entry.Oid = commit.SHA
+	entry.Message = commit.Commit.Message
+	entry.CommittedDate = commit.Commit.Committer.Date
+	return nil
+}
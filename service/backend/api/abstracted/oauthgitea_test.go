@@ -0,0 +1,199 @@
+package abstracted
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"githubclone-backend/api"
+	"githubclone-backend/api/github"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests run against a Gitea container (see database/docker-compose.test.yml).
+// GITEA_URL is the base URL of the instance, GITEA_TOKEN a personal access token of a user.
+func giteaTestAccess(t *testing.T) (string, string) {
+	giteaURL := os.Getenv("GITEA_URL")
+	token := os.Getenv("GITEA_TOKEN")
+	if giteaURL == "" || token == "" {
+		t.Skip("GITEA_URL and GITEA_TOKEN are not set, no Gitea instance available")
+	}
+	return restAPIEndpoint(api.Gitea, giteaURL), token
+}
+
+func giteaRequest(t *testing.T, method, url, token string, payload interface{}) *http.Response {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			t.Fatalf("Encoding of the payload failed: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		t.Fatalf("Creation of the request failed: %v", err)
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request %s %s failed: %v", method, url, err)
+	}
+	return resp
+}
+
+// createGiteaTestRepository creates an initialized repository and removes it after the test
+func createGiteaTestRepository(t *testing.T, endpoint, token string) (string, string) {
+	name := fmt.Sprintf("githubclone-test-%d", time.Now().UnixNano())
+	resp := giteaRequest(t, http.MethodPost, endpoint+"/user/repos", token, map[string]interface{}{
+		"name":           name,
+		"description":    "created by the backend tests",
+		"auto_init":      true,
+		"readme":         "Default",
+		"default_branch": "main",
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status is 201, but got %d", resp.StatusCode)
+	}
+	var repo struct {
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		t.Fatalf("Decoding of the repository failed: %v", err)
+	}
+	t.Cleanup(func() {
+		resp := giteaRequest(t, http.MethodDelete, fmt.Sprintf("%s/repos/%s/%s", endpoint, repo.Owner.Login, name), token, nil)
+		resp.Body.Close()
+	})
+	return repo.Owner.Login, name
+}
+
+func TestGiteaProvider(t *testing.T) {
+	endpoint, token := giteaTestAccess(t)
+	owner, name := createGiteaTestRepository(t, endpoint, token)
+	ctx := context.Background()
+	params := map[string]interface{}{
+		"owner":             owner,
+		"name":              name,
+		"expression":        "main",
+		"expressioncontent": "main",
+		"path":              "README.md",
+		"ref":               "main",
+		"first":             50,
+	}
+
+	user, err := fetchGiteaUser(ctx, endpoint, token, false)
+	if err != nil {
+		t.Fatalf("fetchGiteaUser failed: %v", err)
+	}
+	if user.Data.Viewer.Login != owner {
+		t.Errorf("Expected login %s, but got %s", owner, user.Data.Viewer.Login)
+	}
+
+	repos, err := fetchGiteaRepositories(ctx, endpoint, token, params, false)
+	if err != nil {
+		t.Fatalf("fetchGiteaRepositories failed: %v", err)
+	}
+	found := false
+	for _, node := range repos.Data.Viewer.Repositories.Nodes {
+		found = found || node.Name == name
+	}
+	if !found {
+		t.Errorf("Repository %s is missing in the repository list", name)
+	}
+
+	repo, err := fetchGiteaRepository(ctx, endpoint, token, params, false)
+	if err != nil {
+		t.Fatalf("fetchGiteaRepository failed: %v", err)
+	}
+	if repo.Data.Repository.DefaultBranchRef.Name != "main" {
+		t.Errorf("Expected default branch main, but got %s", repo.Data.Repository.DefaultBranchRef.Name)
+	}
+	if repo.Data.Repository.Branches.TotalCount != 1 || len(repo.Data.Repository.Branches.Nodes) != 1 {
+		t.Errorf("Expected exactly one branch, but got %v", repo.Data.Repository.Branches)
+	}
+
+	tree, err := fetchRepositoryDirectory(ctx, endpoint, token, params, false)
+	if err != nil {
+		t.Fatalf("fetchRepositoryDirectory failed: %v", err)
+	}
+	entries := tree.Data.Repository.Object.Entries
+	if len(entries) != 1 || entries[0].Name != "README.md" || entries[0].Type != "blob" {
+		t.Fatalf("Expected the README.md as only entry, but got %v", entries)
+	}
+	if err := mergeGiteaCommitInfoIntoEntries(ctx, endpoint, token, owner, name, "main", entries, false); err != nil {
+		t.Fatalf("mergeGiteaCommitInfoIntoEntries failed: %v", err)
+	}
+	if entries[0].Oid == "" || entries[0].CommittedDate == "" {
+		t.Errorf("Commit information is missing: %v", entries[0])
+	}
+
+	file, err := fetchFileViaHelper(ctx, endpoint, token, params, false)
+	if err != nil {
+		t.Fatalf("fetchFileViaHelper failed: %v", err)
+	}
+	if file.MIME != "text/markdown" {
+		t.Errorf("Expected MIME text/markdown, but got %s", file.MIME)
+	}
+
+	commit, err := fetchGiteaBranchCommit(ctx, endpoint, token, params, false)
+	if err != nil {
+		t.Fatalf("fetchGiteaBranchCommit failed: %v", err)
+	}
+	if commit.Data.Repository.Ref.Target.OID != entries[0].Oid {
+		t.Errorf("Expected head commit %s, but got %s", entries[0].Oid, commit.Data.Repository.Ref.Target.OID)
+	}
+	if commit.Data.Repository.Ref.Target.History.TotalCount != 1 {
+		t.Errorf("Expected a history of one commit, but got %d", commit.Data.Repository.Ref.Target.History.TotalCount)
+	}
+
+	contributors, err := fetchGiteaContributors(ctx, endpoint, token, params, false)
+	if err != nil {
+		t.Fatalf("fetchGiteaContributors failed: %v", err)
+	}
+	if contributors.TotalCount != 1 || contributors.Nodes[0].Login != owner || contributors.Nodes[0].Contributions != 1 {
+		t.Errorf("Expected %s with one contribution, but got %v", owner, contributors)
+	}
+}
+
+// The commits of the entries are requested in parallel, but not more than giteaCommitRequests at once
+func TestGiteaCommitRequestsAreBounded(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `[{"sha":"%x","commit":{"message":"Change %s","committer":{"date":"2026-10-19T09:00:00Z"}}}]`, len(r.URL.Query().Get("path")), r.URL.Query().Get("path"))
+	}))
+	defer server.Close()
+
+	entries := make([]github.RepositoryEntryTreeCommit, 3*giteaCommitRequests)
+	for i := range entries {
+		entries[i].Name = fmt.Sprintf("file%d", i)
+	}
+	if err := mergeGiteaCommitInfoIntoEntries(context.Background(), restAPIEndpoint(api.Gitea, server.URL), "token", "alice", "demo", "main", entries, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Message != "Change "+entry.Name || entry.CommittedDate == "" {
+			t.Errorf("entry %s: %+v", entry.Name, entry)
+		}
+	}
+	if maxRunning > giteaCommitRequests || maxRunning < 2 {
+		t.Errorf("%d requests ran at the same time, want 2 to %d", maxRunning, giteaCommitRequests)
+	}
+}
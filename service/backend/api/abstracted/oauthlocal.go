@@ -0,0 +1,98 @@
+package abstracted
+
+import (
+	"context"
+	"githubclone-backend/api/github"
+	"githubclone-backend/api/local"
+)
+
+// The local provider reads the repositories from disk. The functions have the signature of the
+// REST helpers, the endpoint is the directory of the repositories and the token is not used.
+
+func fetchLocalRepository(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryNodeWithAttributes, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	return local.Repository(root, owner, name)
+}
+
+func fetchLocalBranchCommit(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryBranchCommit, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	expression, _ := params["expression"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.BranchCommit(repo, expression)
+}
+
+func fetchLocalContributors(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryContributor, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.Contributors(repo, 14)
+}
+
+// fetchLocalTree returns the entries together with their last commits, no second request is needed
+func fetchLocalTree(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryTreeCommit, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	expression, _ := params["expressioncontent"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.TreeCommit(repo, expression)
+}
+
+func fetchLocalFile(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.GitHubFile, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	path, _ := params["path"].(string)
+	ref, _ := params["ref"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.File(repo, ref, path)
+}
+
+func fetchLocalRefs(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryRefs, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	refPrefix, _ := params["refPrefix"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.Refs(repo, refPrefix)
+}
+
+func fetchLocalCommits(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryCommitHistory, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	expression, _ := params["expression"].(string)
+	path, _ := params["path"].(string)
+	first, _ := params["first"].(int)
+	after, _ := params["after"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.Commits(repo, expression, path, first, after)
+}
+
+func fetchLocalBlame(_ context.Context, root string, _ string, params map[string]interface{}, _ bool) (*github.RepositoryBlame, error) {
+	owner, _ := params["owner"].(string)
+	name, _ := params["name"].(string)
+	expression, _ := params["expression"].(string)
+	path, _ := params["path"].(string)
+	repo, err := local.OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return local.Blame(repo, expression, path)
+}
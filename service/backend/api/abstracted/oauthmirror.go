@@ -0,0 +1,108 @@
+package abstracted
+
+import (
+	"errors"
+	"githubclone-backend/api"
+	"githubclone-backend/mirror"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5"
+	"gorm.io/gorm"
+)
+
+type MirrorInput struct {
+	Provider string `json:"provider" binding:"required"`
+	Owner    string `json:"owner" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+}
+
+// openMirror returns the mirror of a repository if it's fresh for the revision of expression,
+// nil if the request has to go to the provider
+func openMirror(c *gin.Context, provider, owner, name, expression string) *git.Repository {
+	if !mirror.Enabled() || api.OAuthProvider(provider) == api.Local {
+		return nil
+	}
+	access, err := getSessionAccess(c, provider)
+	if err != nil {
+		return nil
+	}
+	revision, _, _ := strings.Cut(expression, ":")
+	return mirror.Default.Open(c.Request.Context(), access.ConnectionID, access.Token, owner, name, revision)
+}
+
+// mirrorAccess checks the preconditions of the mirror endpoints and writes the error response
+func mirrorAccess(c *gin.Context, provider string) *api.AccessToken {
+	if !mirror.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": mirror.ErrDisabled.Error()})
+		return nil
+	}
+	access, err := getSessionAccess(c, provider)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return nil
+	}
+	return access
+}
+
+// GetOAuthMirrors lists the mirrors of the connection, only repositories the user can see are included
+func GetOAuthMirrors(c *gin.Context) {
+	provider := c.Query("provider")
+	access := mirrorAccess(c, provider)
+	if access == nil {
+		return
+	}
+	mirrors, err := mirror.Default.List(c.Request.Context(), access.ConnectionID, access.Token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, mirrors)
+}
+
+// CreateOAuthMirror selects a repository the user can see for mirroring, the clone is created in
+// the background
+func CreateOAuthMirror(c *gin.Context) {
+	var input MirrorInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	access := mirrorAccess(c, input.Provider)
+	if access == nil {
+		return
+	}
+	record, err := mirror.Default.Add(c.Request.Context(), access.ConnectionID, access.Token, input.Owner, input.Name)
+	if errors.Is(err, mirror.ErrNotVisible) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, record)
+}
+
+func DeleteOAuthMirror(c *gin.Context) {
+	provider := c.Query("provider")
+	access := mirrorAccess(c, provider)
+	if access == nil {
+		return
+	}
+	err := mirror.Default.Remove(c.Request.Context(), access.ConnectionID, access.Token, c.Query("owner"), c.Query("name"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mirror not found"})
+		return
+	}
+	if errors.Is(err, mirror.ErrNotVisible) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "mirror deleted successfully"})
+}
@@ -0,0 +1,87 @@
+package abstracted
+
+import (
+	"bytes"
+	"encoding/json"
+	"githubclone-backend/api"
+	"githubclone-backend/mirror"
+	"githubclone-backend/mirror/mirrortest"
+	"githubclone-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestMirrorEndpoints(t *testing.T) {
+	remote := mirrortest.NewRemote(t, "alice-token")
+	remote.Commit(t, "alice", "demo", map[string]string{"README.md": "# demo\n"})
+	url := remote.URL
+	store := mirrortest.NewStore(models.Connection{Model: gorm.Model{ID: 1}, ConnectionName: "remote", Type: "gitea", URL: &url})
+	manager, err := mirror.NewManager(t.TempDir(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := mirror.Default
+	mirror.Default = manager
+	t.Cleanup(func() { mirror.Default = previous })
+	for login, token := range map[string]string{"alice": remote.Token, "mallory": "mallory-token"} {
+		api.SetSession("mirror-session-"+login, 1, map[api.OAuthProvider]api.AccessToken{
+			api.Gitea: {Token: token, URL: remote.URL, ConnectionID: 1},
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SetupRoutes(router)
+	send := func(login, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: "mirror-session-" + login})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	const input = `{"provider":"gitea","owner":"alice","name":"demo"}`
+	const list = "/api/oauth/mirrors?provider=gitea"
+	const repository = list + "&owner=alice&name=demo"
+
+	if w := send("mallory", http.MethodPost, "/api/oauth/mirrors", input); w.Code != http.StatusForbidden {
+		t.Fatalf("create with a foreign token: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("alice", http.MethodPost, "/api/oauth/mirrors", input); w.Code != http.StatusAccepted {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	// The clone runs in the background, the temporary directory is removed after the test
+	deadline := time.Now().Add(10 * time.Second)
+	var mirrors []models.Mirror
+	for time.Now().Before(deadline) {
+		w := send("alice", http.MethodGet, list, "")
+		if err := json.Unmarshal(w.Body.Bytes(), &mirrors); err != nil {
+			t.Fatalf("list: status %d: %s", w.Code, w.Body.String())
+		}
+		if len(mirrors) == 1 && mirrors[0].LastFetchedAt != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(mirrors) != 1 || mirrors[0].HeadOID == "" || mirrors[0].LastError != "" {
+		t.Fatalf("mirrors = %+v", mirrors)
+	}
+
+	if w := send("mallory", http.MethodGet, list, ""); w.Code != http.StatusOK || !bytes.Equal(bytes.TrimSpace(w.Body.Bytes()), []byte("[]")) {
+		t.Errorf("list with a foreign token: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("mallory", http.MethodDelete, repository, ""); w.Code != http.StatusForbidden {
+		t.Errorf("delete with a foreign token: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("alice", http.MethodDelete, repository, ""); w.Code != http.StatusOK {
+		t.Errorf("delete: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("alice", http.MethodDelete, repository, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d: %s", w.Code, w.Body.String())
+	}
+}
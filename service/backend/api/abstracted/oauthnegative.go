@@ -0,0 +1,50 @@
+package abstracted
+
+import (
+	"errors"
+	"githubclone-backend/api/common"
+	"githubclone-backend/cache"
+	"log"
+	"net/http"
+)
+
+// Answers of the provider that an object does not exist (404), a repository is empty (409) or the
+// token may not read it (403) are cached for cache.NegativeTTL in the scope of the positive entry.
+// A retry is answered with the same status without asking the provider again.
+
+// negativeStatus returns the status of an error which is cached as negative entry
+func negativeStatus(err error) (int, bool) {
+	var upstreamErr *common.UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return 0, false
+	}
+	// An invalid token and an exhausted secondary rate limit are no property of the object
+	if upstreamErr.Upstream == http.StatusUnauthorized || upstreamErr.RateLimited {
+		return 0, false
+	}
+	switch upstreamErr.Status {
+	case http.StatusNotFound, http.StatusConflict, http.StatusForbidden:
+		return upstreamErr.Status, true
+	}
+	return 0, false
+}
+
+// rememberNegative caches a failed request of a cache entry as negative entry
+func rememberNegative[T any](view *cache.View[T], cacheKey string, err error) {
+	status, ok := negativeStatus(err)
+	if !ok {
+		return
+	}
+	if err := view.SetNegative(cacheKey, status, err.Error()); err != nil {
+		log.Printf("cache write error: %v", err)
+	}
+}
+
+// negativeError returns the error of a cached negative entry as it came from the provider
+func negativeError(err error) (error, bool) {
+	var negative *cache.NegativeError
+	if !errors.As(err, &negative) {
+		return nil, false
+	}
+	return &common.UpstreamError{Status: negative.Status, Upstream: negative.Status, Message: negative.Message}, true
+}
@@ -0,0 +1,240 @@
+package abstracted
+
+import (
+	"context"
+	"fmt"
+	"githubclone-backend/api"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/github"
+	"githubclone-backend/api/local"
+	"githubclone-backend/cachable"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The complete lists are aggregated on the server, the page budget of the query parameter pages
+// limits the number of upstream requests. A truncated list has pageInfo.hasNextPage set, the
+// endCursor allows the paginated endpoints to continue.
+
+const maxPageBudget = 100
+
+func pageBudget(c *gin.Context) int {
+	budget, err := strconv.Atoi(c.DefaultQuery("pages", strconv.Itoa(common.DefaultPageBudget)))
+	if err != nil || budget < 1 {
+		return common.DefaultPageBudget
+	}
+	if budget > maxPageBudget {
+		return maxPageBudget
+	}
+	return budget
+}
+
+// fetchGithubRefsAll loads all branches or tags, the endpoint is the GraphQL endpoint
+func fetchGithubRefsAll(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.RepositoryRefs, error) {
+	budget, _ := params["pages"].(int)
+	vars := map[string]interface{}{
+		"owner":     params["owner"],
+		"name":      params["name"],
+		"refPrefix": params["refPrefix"],
+	}
+	var totalCount int
+	fetch := common.GraphQLPages(endpoint, github.GithubRepositoryRefsQuery, token, vars,
+		func(data *github.RepositoryRefs) ([]github.RepositoryRefNode, common.PageInfo) {
+			refs := data.Data.Repository.Refs
+			totalCount = refs.TotalCount
+			return refs.Nodes, refs.PageInfo
+		}, islog)
+	nodes, pageInfo, err := common.CollectPages(ctx, fetch, budget)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryRefs
+	refs := &result.Data.Repository.Refs
+	refs.TotalCount = totalCount
+	refs.PageInfo = pageInfo
+	refs.Nodes = nodes
+	return &result, nil
+}
+
+// getOAuthRepositoryRefsAll returns all branches or tags of a repository, depending on refPrefix
+func getOAuthRepositoryRefsAll(c *gin.Context, refPrefix string) {
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	provider := c.Query("provider")
+	owner := c.Query("owner")
+	repo := c.Query("name")
+	budget := pageBudget(c)
+	params := map[string]interface{}{
+		"owner":     owner,
+		"name":      repo,
+		"refPrefix": refPrefix,
+		"pages":     budget,
+	}
+
+	access, err := getSessionAccess(c, provider)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var endpoint string
+	var fetch func(ctx context.Context, endpoint, token string, params map[string]interface{}, islog bool) (*github.RepositoryRefs, error)
+	switch api.OAuthProvider(provider) {
+	case api.Local:
+		// All references are read at once
+		endpoint, fetch = access.URL, fetchLocalRefs
+	case api.Github, api.GHES:
+		endpoint, fetch = graphqlgithubprefix+access.URL+graphqlgithubpath, fetchGithubRefsAll
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider not supported"})
+		return
+	}
+
+	cacheKey := fmt.Sprintf("refs-all:%s:%s:%s:%s:%d", provider, owner, repo, refPrefix, budget)
+	view := facade.GitHubRepositoryRefsCache.For(principalFor(c, provider, *access, params))
+	data, found, err := view.Get(cacheKey)
+	if err != nil {
+		log.Printf("cache read error: %v", err)
+	}
+	if !found || data == nil {
+		data, err = fetchCoalesced(upstreamContext(c, *access), fetch, endpoint, access.Token, params, view, cacheKey, false)
+		if err != nil {
+			upstreamError(c, "Listing of the references failed", err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{provider: data})
+}
+
+func GetOAuthRepositoryBranchesAll(c *gin.Context) {
+	getOAuthRepositoryRefsAll(c, "refs/heads/")
+}
+
+func GetOAuthRepositoryTagsAll(c *gin.Context) {
+	getOAuthRepositoryRefsAll(c, "refs/tags/")
+}
+
+// collectRepositories loads all pages of a repository list and merges them
+func collectRepositories(ctx context.Context, budget int, fetchPage func(ctx context.Context, after string) (*github.GitHubRepositoriesOfViewer, error)) (*github.GitHubRepositoriesOfViewer, error) {
+	var result github.GitHubRepositoriesOfViewer
+	fetch := func(ctx context.Context, after string) ([]github.RepositoryNode, common.PageInfo, error) {
+		data, err := fetchPage(ctx, after)
+		if err != nil {
+			return nil, common.PageInfo{}, err
+		}
+		result.Data.Viewer.AvatarURL = data.Data.Viewer.AvatarURL
+		return data.Data.Viewer.Repositories.Nodes, data.Data.Viewer.Repositories.PageInfo, nil
+	}
+	nodes, pageInfo, err := common.CollectPages(ctx, fetch, budget)
+	if err != nil {
+		return nil, err
+	}
+	result.Data.Viewer.Repositories.Nodes = nodes
+	result.Data.Viewer.Repositories.PageInfo = pageInfo
+	return &result, nil
+}
+
+// GetOAuthRepositoriesAll returns all repositories of the viewer for every provider of the session
+func GetOAuthRepositoriesAll(c *gin.Context) {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session ID found in cookie"})
+		return
+	}
+	session, err := api.GetToken(sessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+		return
+	}
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+
+	validParams := common.ValidateGraphQLParams(
+		false,
+		map[string]string{
+			"field":     c.DefaultQuery("field", "UPDATED_AT"),
+			"direction": c.DefaultQuery("direction", "DESC"),
+		},
+		map[string]map[string]bool{
+			"field":     {"NAME": true, "CREATED_AT": true, "UPDATED_AT": true, "STARGAZER_COUNT": true},
+			"direction": {"ASC": true, "DESC": true},
+		})
+	field, ok := validParams["field"].(string)
+	if !ok {
+		field = "UPDATED_AT"
+	}
+	direction, ok := validParams["direction"].(string)
+	if !ok {
+		direction = "DESC"
+	}
+	budget := pageBudget(c)
+
+	userdata := make(map[string]interface{})
+	for key, value := range session {
+		var fetchPage func(ctx context.Context, after string) (*github.GitHubRepositoriesOfViewer, error)
+		switch key {
+		case api.Github, api.GHES:
+			endpoint := graphqlgithubprefix + value.URL + graphqlgithubpath
+			fetchPage = func(ctx context.Context, after string) (*github.GitHubRepositoriesOfViewer, error) {
+				vars := map[string]interface{}{"first": common.MaxPageSize, "field": field, "direction": direction}
+				if after != "" {
+					vars["after"] = after
+				}
+				return common.SendGraphQLQuery[github.GitHubRepositoriesOfViewer](ctx, endpoint, github.GithubRepositoriesOfViewerQuery, value.Token, vars, false)
+			}
+		case api.Gitea:
+			endpoint := restAPIEndpoint(key, value.URL)
+			fetchPage = func(ctx context.Context, after string) (*github.GitHubRepositoriesOfViewer, error) {
+				// Gitea returns at most 50 repositories per page
+				return fetchGiteaRepositories(ctx, endpoint, value.Token, map[string]interface{}{"first": 50, "after": after, "field": field, "direction": direction}, false)
+			}
+		case api.Local:
+			fetchPage = func(_ context.Context, after string) (*github.GitHubRepositoriesOfViewer, error) {
+				return local.ListRepositories(value.URL, common.MaxPageSize, after, field, direction)
+			}
+		case api.Gitlab, api.GitlabSelfManaged:
+			continue
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unsupported provider", "details": key})
+			return
+		}
+
+		// Listing the directory is cheap, therefore it's not cached
+		if key == api.Local {
+			data, err := collectRepositories(c.Request.Context(), budget, fetchPage)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Listing of local repositories failed", "details": err.Error()})
+				return
+			}
+			userdata[string(key)] = data
+			continue
+		}
+
+		cacheKey := fmt.Sprintf("repos-all:%s:%s:%s:%s:%d", sessionID, key, field, direction, budget)
+		view := facade.GitHubRepositoriesOfViewerCache.For(principalFor(c, string(key), value, nil))
+		data, found, err := view.Get(cacheKey)
+		if err != nil {
+			log.Printf("cache read error: %v", err)
+		}
+		if !found || data == nil {
+			data, err = common.Coalesce(upstreamContext(c, value), common.CoalesceKey(view.Key(cacheKey), value.Token),
+				func(ctx context.Context) (*github.GitHubRepositoriesOfViewer, error) {
+					data, err := collectRepositories(ctx, budget, fetchPage)
+					if err != nil {
+						return nil, err
+					}
+					if err := view.Set(cacheKey, *data); err != nil {
+						log.Printf("cache write error: %v", err)
+					}
+					return data, nil
+				})
+			if err != nil {
+				upstreamError(c, "Listing of the repositories failed", err)
+				return
+			}
+		}
+		userdata[string(key)] = data
+	}
+	c.JSON(http.StatusOK, userdata)
+}
@@ -0,0 +1,158 @@
+package abstracted
+
+import (
+	"context"
+	"errors"
+	"githubclone-backend/api"
+	"githubclone-backend/api/common"
+	"githubclone-backend/cache"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handlers set this key for non-interactive work. The server marks its background work itself:
+// GetOAuthRepositoryContributors sets the key, refreshInBackground uses common.WithBackground.
+const backgroundRequest = "backgroundRequest"
+
+// upstreamContext binds the provider requests to the request of the browser and labels them
+// with the connection. Background requests are throttled before the rate limit is exhausted,
+// they are marked with backgroundRequest or by clients with "X-Request-Priority: background".
+// The frontend does not send the header, its requests are all interactive.
+func upstreamContext(c *gin.Context, access api.AccessToken) context.Context {
+	ctx := common.WithConnection(c.Request.Context(), strconv.FormatUint(uint64(access.ConnectionID), 10))
+	if c.GetHeader("X-Request-Priority") == "background" || c.GetBool(backgroundRequest) {
+		ctx = common.WithBackground(ctx)
+	}
+	return ctx
+}
+
+// withOperation labels the provider requests of ctx with the kind of the cache key, e.g. "tree"
+func withOperation(ctx context.Context, cacheKey string) context.Context {
+	operation, _, _ := strings.Cut(cacheKey, ":")
+	return common.WithOperation(ctx, operation)
+}
+
+// upstreamError writes the response for a failed provider request. An exhausted rate limit is
+// answered with 429 and the time of the reset, so that the client does not retry before. Errors
+// of the provider keep their meaning, e.g. 404 for a missing repository, an open circuit breaker
+// is 503, everything else is 502.
+func upstreamError(c *gin.Context, message string, err error) {
+	var rateLimitErr *common.RateLimitError
+	var upstreamErr *common.UpstreamError
+	var circuitErr *common.CircuitOpenError
+	switch {
+	case errors.As(err, &rateLimitErr):
+		retryAfter := int(time.Until(rateLimitErr.Reset).Seconds()) + 1
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded", "details": err.Error(), "reset": rateLimitErr.Reset})
+	case errors.As(err, &circuitErr):
+		// The provider failed repeatedly, the client can retry when the breaker half-opens
+		retryAfter := int(time.Until(circuitErr.Until).Seconds()) + 1
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": message, "details": err.Error()})
+	case errors.Is(err, common.ErrThrottled):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Request throttled", "details": err.Error()})
+	case errors.As(err, &upstreamErr):
+		c.JSON(upstreamErr.Status, gin.H{"error": message, "details": err.Error(), "errors": upstreamErr.Errors})
+	case errors.As(err, new(*url.Error)):
+		// The provider is not reachable
+		c.JSON(http.StatusBadGateway, gin.H{"error": message, "details": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "details": err.Error()})
+	}
+}
+
+// staleFallback answers with an expired cache entry while the circuit breaker of the provider is
+// open. The answer is marked with the Warning header, see RFC 7234.
+func staleFallback[T any](c *gin.Context, view *cache.View[T], cacheKey string, err error) (*T, bool) {
+	data, storedAt, ok := staleEntry(c, view, cacheKey, err)
+	if ok {
+		setCacheHeaders(c, cache.StatusStale, storedAt)
+	}
+	return data, ok
+}
+
+// staleEntry is staleFallback without the cache headers, for answers which combine several entries
+func staleEntry[T any](c *gin.Context, view *cache.View[T], cacheKey string, err error) (*T, time.Time, bool) {
+	var circuitErr *common.CircuitOpenError
+	if !errors.As(err, &circuitErr) {
+		return nil, time.Time{}, false
+	}
+	data, storedAt, found, cacheErr := view.GetStale(cacheKey)
+	if cacheErr != nil || !found {
+		return nil, time.Time{}, false
+	}
+	log.Printf("Serving stale %s from %s, %v", view.Key(cacheKey), storedAt.Format(time.RFC3339), err)
+	c.Header("Warning", `110 - "Response is Stale"`)
+	return data, storedAt, true
+}
+
+// GetOAuthRateLimit returns the known rate limits of the tokens of the session per provider
+func GetOAuthRateLimit(c *gin.Context) {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session ID found in cookie"})
+		return
+	}
+	session, err := api.GetToken(sessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+		return
+	}
+
+	userdata := make(map[string]interface{})
+	for key, value := range session {
+		if key == api.Local {
+			continue // No rate limit on a filesystem
+		}
+		userdata[string(key)] = gin.H{
+			"connection": value.ConnectionID,
+			"resources":  common.RateLimitStates(c.Request.Context(), value.Token),
+		}
+	}
+	c.JSON(http.StatusOK, userdata)
+}
+
+// GetOAuthUpstreamStatus returns the state of the circuit breakers of the connections of the session
+func GetOAuthUpstreamStatus(c *gin.Context) {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session ID found in cookie"})
+		return
+	}
+	session, err := api.GetToken(sessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+		return
+	}
+
+	userdata := make(map[string]interface{})
+	for key, value := range session {
+		var endpoint string
+		switch key {
+		case api.Local:
+			continue // No upstream host
+		case api.Gitlab, api.GitlabSelfManaged:
+			endpoint = gitlabGraphQLEndpoint(key, value.URL)
+		default:
+			endpoint = restAPIEndpoint(key, value.URL)
+		}
+		userdata[string(key)] = gin.H{
+			"connection": value.ConnectionID,
+			"breaker":    common.BreakerStatusOf(endpoint),
+		}
+	}
+	c.JSON(http.StatusOK, userdata)
+}
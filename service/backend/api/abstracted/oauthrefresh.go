@@ -0,0 +1,49 @@
+package abstracted
+
+import (
+	"context"
+	"githubclone-backend/api"
+	"githubclone-backend/api/common"
+	"githubclone-backend/cache"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A cache entry is fresh until its TTL and stale for cache.RevalidateWindow afterwards. A stale
+// entry is answered immediately and refreshed in the background, only a miss waits for the
+// provider. Older entries are only used while the provider is unreachable, see staleFallback.
+
+// refreshTimeout limits a refresh in the background, no request waits for it
+const refreshTimeout = time.Minute
+
+// setCacheHeaders tells the client with X-Cache whether the answer comes from the cache and
+// with Age how old the cached data is in seconds
+func setCacheHeaders(c *gin.Context, status cache.Status, storedAt time.Time) {
+	c.Header("X-Cache", status.String())
+	if status == cache.StatusMiss {
+		c.Writer.Header().Del("Age")
+		return
+	}
+	age := time.Since(storedAt)
+	if age < 0 {
+		age = 0
+	}
+	c.Header("Age", strconv.FormatInt(int64(age/time.Second), 10))
+}
+
+// refreshInBackground renews a stale entry with the token of the requesting user, fetch writes
+// the cache. It uses the coalescing key of a miss, so concurrent misses and refreshes of an
+// entry share one upstream request. The request counts as background for the rate limits.
+func refreshInBackground[R any](access api.AccessToken, coalesceKey string, fetch func(ctx context.Context) (R, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	ctx = common.WithBackground(common.WithConnection(ctx, strconv.FormatUint(uint64(access.ConnectionID), 10)))
+	go func() {
+		defer cancel()
+		if _, err := common.Coalesce(ctx, coalesceKey, fetch); err != nil {
+			log.Printf("Background refresh of %s failed: %v", coalesceKey, err)
+		}
+	}()
+}
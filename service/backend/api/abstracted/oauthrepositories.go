@@ -1,12 +1,15 @@
 package abstracted
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"githubclone-backend/api"
 	"githubclone-backend/api/common"
 	"githubclone-backend/api/github"
+	"githubclone-backend/api/local"
 	"githubclone-backend/cachable"
+	"githubclone-backend/cache"
 	"githubclone-backend/utils"
 	"log"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -52,50 +56,96 @@ func GetOAuthRepositories(c *gin.Context) {
 		})
 
 	userdata := make(map[string]interface{})
+	// The answer combines the providers, X-Cache and Age describe the least current of them
+	cached := false
+	status, storedAt := cache.StatusHit, time.Now()
 	for key, value := range session {
 		switch key {
-		case api.Github, api.GHES:
-			endpoint := value.URL
-			token := value.Token
-			// log.Printf("endpoint=%s, token=%s", value.URL, value.Token)
-			cacheKey := fmt.Sprintf("repos:%s:%s:%s:%s", sessionID, validParams["field"], validParams["direction"], validParams["after"])
-			githubData, found, err := facade.GitHubRepositoriesOfViewerCache.Get(cacheKey)
-			if err != nil {
-				log.Printf("cache read error: %v", err)
-			}
-
-			if !found || githubData == nil {
-				log.Printf("Make graphql request for GetOAuthRepositories")
-				githubData, err = common.SendGraphQLQuery[github.GitHubRepositoriesOfViewer](
-					graphqlgithubprefix+endpoint+graphqlgithubpath,
-					github.GithubRepositoriesOfViewerQuery,
-					token,
-					validParams,
-					false,
-				)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{
-						"error":   "GraphQL request failed",
-						"details": err.Error(),
-					})
-					return
+		case api.Github, api.GHES, api.Gitea:
+			view := facade.GitHubRepositoriesOfViewerCache.For(principalFor(c, string(key), value, nil))
+			var cacheKey string
+			var load func(ctx context.Context) (*github.GitHubRepositoriesOfViewer, error)
+			if key == api.Gitea {
+				cacheKey = fmt.Sprintf("repos:%s:%s:%v:%s:%s:%s", sessionID, key, validParams["first"], validParams["field"], validParams["direction"], validParams["after"])
+				load = func(ctx context.Context) (*github.GitHubRepositoriesOfViewer, error) {
+					return fetchGiteaRepositories(ctx, restAPIEndpoint(key, value.URL), value.Token, validParams, false)
 				}
-
-				if err := facade.GitHubRepositoriesOfViewerCache.Set(cacheKey, *githubData); err != nil {
-					log.Printf("cache write error: %v", err)
+			} else {
+				cacheKey = fmt.Sprintf("repos:%s:%s:%s:%s", sessionID, validParams["field"], validParams["direction"], validParams["after"])
+				load = func(ctx context.Context) (*github.GitHubRepositoriesOfViewer, error) {
+					return common.SendGraphQLQuery[github.GitHubRepositoriesOfViewer](ctx, graphqlgithubprefix+value.URL+graphqlgithubpath, github.GithubRepositoriesOfViewerQuery, value.Token, validParams, false)
 				}
 			}
-			// You're able to manipulate the data here or put it in the cache.
-			userdata[string(key)] = githubData
-		case api.Gitlab:
+			data, providerStatus, providerStoredAt, err := lookupRepositories(c, value, view, cacheKey, load)
+			if err != nil {
+				upstreamError(c, "Listing of repositories failed", err)
+				return
+			}
+			cached = true
+			if status != cache.StatusMiss && providerStatus != cache.StatusHit {
+				status = providerStatus
+			}
+			if providerStatus != cache.StatusMiss && providerStoredAt.Before(storedAt) {
+				storedAt = providerStoredAt
+			}
+			userdata[string(key)] = data
+		case api.Local:
+			// Listing the directory is cheap, therefore it's not cached
+			localData, err := local.ListRepositories(value.URL, validParams["first"].(int), rawParams["after"], rawParams["field"], rawParams["direction"])
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Listing of local repositories failed", "details": err.Error()})
+				return
+			}
+			userdata[string(key)] = localData
+		case api.Gitlab, api.GitlabSelfManaged:
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "unsupported provider", "details": key})
 			return
 		}
 	}
+	if cached {
+		setCacheHeaders(c, status, storedAt)
+	}
 	c.JSON(http.StatusOK, userdata)
 }
 
+// lookupRepositories answers the repository list of a provider like GetOAuthCommonProvider: a
+// stale entry is returned and refreshed in the background, a miss waits for load and an expired
+// entry is used while the circuit breaker is open. It returns the cache status of the answer.
+func lookupRepositories(
+	c *gin.Context, access api.AccessToken,
+	view *cache.View[github.GitHubRepositoriesOfViewer], cacheKey string,
+	load func(ctx context.Context) (*github.GitHubRepositoriesOfViewer, error)) (*github.GitHubRepositoriesOfViewer, cache.Status, time.Time, error) {
+	coalesceKey := common.CoalesceKey(view.Key(cacheKey), access.Token)
+	fetch := func(ctx context.Context) (*github.GitHubRepositoriesOfViewer, error) {
+		data, err := load(withOperation(ctx, cacheKey))
+		if err != nil {
+			return nil, err
+		}
+		if err := view.Set(cacheKey, *data); err != nil {
+			log.Printf("cache write error: %v", err)
+		}
+		return data, nil
+	}
+
+	data, storedAt, status, err := view.Lookup(cacheKey)
+	if err != nil {
+		log.Printf("cache read error: %v", err)
+	}
+	if status == cache.StatusStale {
+		refreshInBackground(access, coalesceKey, fetch)
+	}
+	if status != cache.StatusMiss && data != nil {
+		return data, status, storedAt, nil
+	}
+
+	data, err = common.Coalesce(upstreamContext(c, access), coalesceKey, fetch)
+	if stale, staleAt, ok := staleEntry(c, view, cacheKey, err); ok {
+		return stale, cache.StatusStale, staleAt, nil
+	}
+	return data, cache.StatusMiss, time.Time{}, err
+}
+
 func GetOAuthRepository(c *gin.Context) {
 	islog := false
 	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
@@ -109,6 +159,14 @@ func GetOAuthRepository(c *gin.Context) {
 	}
 	cacheKey := fmt.Sprintf("repository:%s:%s:%s", provider, owner, repo)
 
+	switch api.OAuthProvider(provider) {
+	case api.Gitea:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchGiteaRepository, facade.GitHubRepositoryNodeWithAttributesCache, cacheKey, islog)
+		return
+	case api.Local:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchLocalRepository, facade.GitHubRepositoryNodeWithAttributesCache, cacheKey, islog)
+		return
+	}
 	GetOAuthCommonProvider(
 		c,
 		provider,
@@ -132,7 +190,24 @@ func GetOAuthRepositoryBranchCommit(c *gin.Context) {
 		"expression": expression,
 	}
 
+	if mirrored := openMirror(c, provider, owner, repo, expression); mirrored != nil {
+		data, err := local.BranchCommit(mirrored, expression)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{provider: data})
+			return
+		}
+		log.Printf("Mirror cannot answer the branch commit of %s/%s: %v", owner, repo, err)
+	}
+
 	cacheKey := fmt.Sprintf("branchcommit:%s:%s:%s:%s", provider, owner, repo, expression)
+	switch api.OAuthProvider(provider) {
+	case api.Gitea:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchGiteaBranchCommit, facade.GitHubRepositoryBranchCommitCache, cacheKey, false)
+		return
+	case api.Local:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchLocalBranchCommit, facade.GitHubRepositoryBranchCommitCache, cacheKey, false)
+		return
+	}
 	GetOAuthCommonProvider(
 		c,
 		provider,
@@ -145,6 +220,7 @@ func GetOAuthRepositoryBranchCommit(c *gin.Context) {
 }
 
 func fetchContributorsWithCount(
+	ctx context.Context,
 	endpoint string,
 	token string,
 	params map[string]interface{},
@@ -157,7 +233,7 @@ func fetchContributorsWithCount(
 
 	// Determine the total count via per_page=1
 	countPath := fmt.Sprintf("/repos/%s/%s/contributors?per_page=1", owner, repo)
-	countResp, err := common.SendRestAPIQuery[[]github.RepositoryContributorNode](endpoint, countPath, token, islog)
+	countResp, err := common.SendRestAPIQuery[[]github.RepositoryContributorNode](ctx, endpoint, countPath, token, islog)
 	if err != nil {
 		// Optional: special handling for 204 (empty data)
 		if strings.Contains(err.Error(), "204") {
@@ -180,7 +256,7 @@ func fetchContributorsWithCount(
 
 	// Load first 14 contributors
 	dataPath := fmt.Sprintf("/repos/%s/%s/contributors?per_page=%d&page=1", owner, repo, limit)
-	dataResp, err := common.SendRestAPIQuery[[]github.RepositoryContributorNodeFromAPI](endpoint, dataPath, token, islog)
+	dataResp, err := common.SendRestAPIQuery[[]github.RepositoryContributorNodeFromAPI](ctx, endpoint, dataPath, token, islog)
 	if err != nil {
 		return nil, err
 	}
@@ -214,18 +290,27 @@ func GetOAuthRepositoryContributors(c *gin.Context) {
 		"name":  name,
 	}
 
+	// The counts are not needed to show the repository, they must not use up the rate limit
+	c.Set(backgroundRequest, true)
+	fetchContributors := fetchContributorsWithCount
+	switch api.OAuthProvider(provider) {
+	case api.Gitea:
+		fetchContributors = fetchGiteaContributors
+	case api.Local:
+		fetchContributors = fetchLocalContributors
+	}
 	GetOAuthCommonProviderREST(
 		c,
 		provider,
 		validParams,
-		fetchContributorsWithCount,
+		fetchContributors,
 		facade.GitHubRepositoryContributorCache,
 		cacheKey,
 		false,
 	)
 }
 
-func fetchFileViaHelper(endpoint string, token string, params map[string]interface{}, islog bool) (*github.GitHubFile, error) {
+func fetchFileViaHelper(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.GitHubFile, error) {
 	owner, _ := params["owner"].(string)
 	name, _ := params["name"].(string)
 	path, _ := params["path"].(string)
@@ -240,7 +325,7 @@ func fetchFileViaHelper(endpoint string, token string, params map[string]interfa
 		log.Printf("contentPath=%s", contentPath)
 	}
 
-	result, err := common.SendRestAPIQuery[github.GitHubFile](endpoint, contentPath, token, islog)
+	result, err := common.SendRestAPIQuery[github.GitHubFile](ctx, endpoint, contentPath, token, islog)
 	if err != nil {
 		return nil, err
 	}
@@ -259,17 +344,27 @@ func GetOAuthRepositoryContent(c *gin.Context) {
 	name := c.Query("name")
 	path := c.Query("content")
 	ref := c.Query("expression")
+	// The content at a commit is cached without expiry
+	refKey := ref
+	if oid := resolveOID(c, provider, owner, name, ref); oid != "" {
+		ref, refKey = oid, cachable.CommitKey(oid)
+	}
 
-	cacheKey := fmt.Sprintf("content:%s:%s:%s:%s:%s", provider, owner, name, path, ref)
+	cacheKey := fmt.Sprintf("content:%s:%s:%s:%s:%s", provider, owner, name, path, refKey)
 	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
 
 	// Sonst → hol Daten und speichere
+	// The contents api of Gitea is compatible to GitHub, therefore the same helper is used
 	validParams := map[string]interface{}{
 		"owner": owner,
 		"name":  name,
 		"path":  path,
 		"ref":   ref,
 	}
-	GetOAuthCommonProviderREST(c, provider, validParams, fetchFileViaHelper, facade.GitHubFileCache, cacheKey, false)
+	fetchFile := fetchFileViaHelper
+	if api.OAuthProvider(provider) == api.Local {
+		fetchFile = fetchLocalFile
+	}
+	GetOAuthCommonProviderREST(c, provider, validParams, fetchFile, facade.GitHubFileCache, cacheKey, false)
 
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"githubclone-backend/api"
 	"githubclone-backend/api/github"
+	"githubclone-backend/api/local"
 	"log"
 	"net/http"
 	"strings"
@@ -136,9 +137,18 @@ func GetOauthRepositoryContents(c *gin.Context) {
 
 	islog := false
 
+	if mirrored := openMirror(c, provider, c.Query("owner"), c.Query("name"), c.Query("expression")); mirrored != nil {
+		data, err := local.TreeCommit(mirrored, c.Query("expression"))
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{provider: data})
+			return
+		}
+		log.Printf("Mirror cannot answer the tree of %s/%s: %v", c.Query("owner"), c.Query("name"), err)
+	}
+
 	data, err := GetOAuthCommonProviderIntern[github.RepositoryTreeCommit](c, provider, github.GithubRepositoryContentsQuery, validParams, islog)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		upstreamError(c, "Repository contents cannot be loaded", err)
 		return
 	}
 
@@ -157,7 +167,7 @@ func GetOauthRepositoryContents(c *gin.Context) {
 	for _, query := range queries {
 		data1, err1 := GetOAuthCommonProviderIntern[map[string]interface{}](c, provider, query, validParams, islog)
 		if err1 != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err1.Error()})
+			upstreamError(c, "Commit information cannot be loaded", err1)
 			return
 		}
 		chunk := parseCommitHistoryResult(*data1, aliasToPath)
@@ -1,10 +1,12 @@
 package abstracted
 
 import (
+	"context"
 	"fmt"
 	"githubclone-backend/api"
 	"githubclone-backend/api/common"
 	"githubclone-backend/api/github"
+	"githubclone-backend/api/local"
 	"githubclone-backend/cachable"
 	"log"
 	"net/http"
@@ -119,7 +121,7 @@ func convertToRepositoryTree(owner, name string, contents []GithubContent) githu
 	return tree
 }
 
-func fetchRepositoryDirectory(endpoint string, token string, params map[string]interface{}, islog bool) (*github.RepositoryTreeCommit, error) {
+func fetchRepositoryDirectory(ctx context.Context, endpoint string, token string, params map[string]interface{}, islog bool) (*github.RepositoryTreeCommit, error) {
 	owner, _ := params["owner"].(string)
 	name, _ := params["name"].(string)
 	path, _ := params["path"].(string)
@@ -133,7 +135,7 @@ func fetchRepositoryDirectory(endpoint string, token string, params map[string]i
 		log.Printf("repoDirPath=%s", repoDirPath)
 	}
 
-	result, err := common.SendRestAPIQuery[[]GithubContent](endpoint, repoDirPath, token, islog)
+	result, err := common.SendRestAPIQuery[[]GithubContent](ctx, endpoint, repoDirPath, token, islog)
 	if err != nil {
 		return nil, err
 	}
@@ -161,15 +163,13 @@ func buildCommitQueryFromEntriesAsync(entries []github.RepositoryEntryTreeCommit
 	query := fmt.Sprintf(`
 query {
   repository(owner: %q, name: %q) {
-    ref(qualifiedName: %q) {
-      target {
-        ... on Commit {
+    object(expression: %q) {
+      ... on Commit {
 %s
-        }
       }
     }
   }
-}`, owner, repo, validParams["expressioncontent"], indentLines(fields, 10))
+}`, owner, repo, validParams["expressioncontent"], indentLines(fields, 8))
 
 	if islog {
 		log.Printf("query=%v,", query)
@@ -195,11 +195,7 @@ func findCommitInformation(raw map[string]interface{}) map[string]interface{} {
 	if !ok {
 		return nil
 	}
-	ref, ok := repo["ref"].(map[string]interface{})
-	if !ok {
-		return nil
-	}
-	target, ok := ref["target"].(map[string]interface{})
+	target, ok := repo["object"].(map[string]interface{})
 	if !ok {
 		return nil
 	}
@@ -240,12 +236,35 @@ func GetOauthRepositoryContentsAsync(c *gin.Context) {
 		"expressioncontent": expression,
 	}
 
+	// The mirror knows the last commits of all entries, the response is complete
+	if mirrored := openMirror(c, provider, owner, repo, expression); mirrored != nil {
+		data, err := local.TreeCommit(mirrored, expression)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{provider: data})
+			return
+		}
+		log.Printf("Mirror cannot answer the tree of %s/%s: %v", owner, repo, err)
+	}
+
+	// The tree at a commit is cached without expiry, the commit information of the entries is
+	// loaded for the same commit
+	ref, refKey := expression, expression
+	if oid := resolveOID(c, provider, owner, repo, expression); oid != "" {
+		ref, refKey = oid, cachable.CommitKey(oid)
+	}
+	validParams["ref"] = ref
+	validParams["expressioncontent"] = ref
+
 	islog := false
-	cacheKey := fmt.Sprintf("branchcommit:%s:%s:%s:%s", provider, owner, repo, expression)
+	cacheKey := fmt.Sprintf("tree:%s:%s:%s:%s", provider, owner, repo, refKey)
 
-	data, err, cached := GetOAuthCommonProviderRESTIntern(c, provider, validParams, fetchRepositoryDirectory, facade.GitHubRepositoryTreeCommit, cacheKey, islog)
+	fetchDirectory := fetchRepositoryDirectory
+	if api.OAuthProvider(provider) == api.Local {
+		fetchDirectory = fetchLocalTree
+	}
+	data, err, cached := GetOAuthCommonProviderRESTIntern(c, provider, validParams, fetchDirectory, facade.GitHubRepositoryTreeCommit, cacheKey, islog)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		upstreamError(c, "Repository contents cannot be loaded", err)
 		return
 	}
 	if islog {
@@ -259,7 +278,7 @@ func GetOauthRepositoryContentsAsync(c *gin.Context) {
 	}
 	if !cached || nameindex < 0 {
 		data.Partial = false
-		userdata[string(api.Github)] = data
+		userdata[provider] = data
 		c.JSON(http.StatusOK, userdata)
 	} else {
 		distance := len(data.Data.Repository.Object.Entries) - nameindex
@@ -269,19 +288,36 @@ func GetOauthRepositoryContentsAsync(c *gin.Context) {
 		if distance > MAX_LIMIT {
 			distance = MAX_LIMIT
 		}
-		query := buildCommitQueryFromEntriesAsync(data.Data.Repository.Object.Entries[nameindex:nameindex+distance], owner, repo, validParams, islog)
-		data1, err1 := GetOAuthCommonProviderIntern[map[string]interface{}](c, provider, query, validParams, islog)
-		if err1 != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err1.Error()})
-			return
+		switch api.OAuthProvider(provider) {
+		case api.Local:
+			// The entries of the local provider contain the commit information already
+		case api.Gitea:
+			access, err1 := getSessionAccess(c, provider)
+			if err1 == nil {
+				err1 = mergeGiteaCommitInfoIntoEntries(upstreamContext(c, *access), restAPIEndpoint(api.Gitea, access.URL), access.Token, owner, repo, ref, data.Data.Repository.Object.Entries[nameindex:nameindex+distance], islog)
+			}
+			if err1 != nil {
+				upstreamError(c, "Commit information cannot be loaded", err1)
+				return
+			}
+		default:
+			query := buildCommitQueryFromEntriesAsync(data.Data.Repository.Object.Entries[nameindex:nameindex+distance], owner, repo, validParams, islog)
+			data1, err1 := GetOAuthCommonProviderIntern[map[string]interface{}](c, provider, query, validParams, islog)
+			if err1 != nil {
+				upstreamError(c, "Commit information cannot be loaded", err1)
+				return
+			}
+			target := findCommitInformation(*data1)
+			mergeCommitInfoIntoEntries(data.Data.Repository.Object.Entries[nameindex:nameindex+distance], target)
 		}
-		target := findCommitInformation(*data1)
-		mergeCommitInfoIntoEntries(data.Data.Repository.Object.Entries[nameindex:nameindex+distance], target)
 		var partial github.RepositoryTreeCommit
 		partial.Partial = true
 		partial.Data.Repository.Object.Entries = data.Data.Repository.Object.Entries[nameindex : nameindex+distance]
-		userdata[string(api.Github)] = partial
-		facade.GitHubRepositoryTreeCommit.Set(cacheKey, *data)
+		userdata[provider] = partial
+		// The commit information is kept for the next part of the tree
+		if access, err := getSessionAccess(c, provider); err == nil {
+			facade.GitHubRepositoryTreeCommit.For(principalFor(c, provider, *access, validParams)).Set(cacheKey, *data)
+		}
 		c.JSON(http.StatusOK, userdata)
 	}
 }
@@ -0,0 +1,170 @@
+package abstracted
+
+import (
+	"fmt"
+	"githubclone-backend/api"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/github"
+	"githubclone-backend/api/local"
+	"githubclone-backend/cachable"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOAuthRepositoryRefs lists the branches or tags of a repository, depending on refPrefix
+func getOAuthRepositoryRefs(c *gin.Context, refPrefix string) {
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	provider := c.Query("provider")
+	owner := c.Query("owner")
+	repo := c.Query("name")
+	first, err := strconv.Atoi(c.DefaultQuery("first", strconv.Itoa(common.DefaultFirst)))
+	if err != nil || first < 1 {
+		first = common.DefaultFirst
+	}
+	after := c.Query("after")
+	validParams := map[string]interface{}{
+		"owner":     owner,
+		"name":      repo,
+		"refPrefix": refPrefix,
+		"first":     first,
+	}
+	if after != "" {
+		validParams["after"] = after
+	}
+
+	cacheKey := fmt.Sprintf("refs:%s:%s:%s:%s:%d:%s", provider, owner, repo, refPrefix, first, after)
+	switch api.OAuthProvider(provider) {
+	case api.Local:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchLocalRefs, facade.GitHubRepositoryRefsCache, cacheKey, false)
+		return
+	case api.Github, api.GHES:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider not supported"})
+		return
+	}
+	GetOAuthCommonProvider(
+		c,
+		provider,
+		github.GithubRepositoryRefsQuery,
+		validParams,
+		facade.GitHubRepositoryRefsCache,
+		cacheKey,
+		false,
+	)
+}
+
+func GetOAuthRepositoryBranches(c *gin.Context) {
+	getOAuthRepositoryRefs(c, "refs/heads/")
+}
+
+func GetOAuthRepositoryTags(c *gin.Context) {
+	getOAuthRepositoryRefs(c, "refs/tags/")
+}
+
+// GetOAuthRepositoryCommits returns the history of a branch, tag or commit, optionally only for a path
+func GetOAuthRepositoryCommits(c *gin.Context) {
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	provider := c.Query("provider")
+	owner := c.Query("owner")
+	repo := c.Query("name")
+	expression := c.DefaultQuery("expression", "HEAD")
+	path := c.Query("path")
+	first, err := strconv.Atoi(c.DefaultQuery("first", strconv.Itoa(common.DefaultFirst)))
+	if err != nil || first < 1 {
+		first = common.DefaultFirst
+	}
+	after := c.Query("after")
+	validParams := map[string]interface{}{
+		"owner":      owner,
+		"name":       repo,
+		"expression": expression,
+		"first":      first,
+	}
+	if path != "" {
+		validParams["path"] = path
+	}
+	if after != "" {
+		validParams["after"] = after
+	}
+
+	if mirrored := openMirror(c, provider, owner, repo, expression); mirrored != nil {
+		data, err := local.Commits(mirrored, expression, path, first, after)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{provider: data})
+			return
+		}
+		log.Printf("Mirror cannot answer the history of %s/%s: %v", owner, repo, err)
+	}
+
+	cacheKey := fmt.Sprintf("commits:%s:%s:%s:%s:%s:%d:%s", provider, owner, repo, expression, path, first, after)
+	switch api.OAuthProvider(provider) {
+	case api.Local:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchLocalCommits, facade.GitHubRepositoryCommitHistoryCache, cacheKey, false)
+		return
+	case api.Github, api.GHES:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider not supported"})
+		return
+	}
+	GetOAuthCommonProvider(
+		c,
+		provider,
+		github.GithubRepositoryCommitsQuery,
+		validParams,
+		facade.GitHubRepositoryCommitHistoryCache,
+		cacheKey,
+		false,
+	)
+}
+
+// GetOAuthRepositoryBlame returns for each line of a file the commit which changed it last
+func GetOAuthRepositoryBlame(c *gin.Context) {
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	provider := c.Query("provider")
+	owner := c.Query("owner")
+	repo := c.Query("name")
+	expression := c.DefaultQuery("expression", "HEAD")
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parameter path is missing"})
+		return
+	}
+	validParams := map[string]interface{}{
+		"owner":      owner,
+		"name":       repo,
+		"expression": expression,
+		"path":       path,
+	}
+
+	if mirrored := openMirror(c, provider, owner, repo, expression); mirrored != nil {
+		data, err := local.Blame(mirrored, expression, path)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{provider: data})
+			return
+		}
+		log.Printf("Mirror cannot answer the blame of %s/%s: %v", owner, repo, err)
+	}
+
+	cacheKey := fmt.Sprintf("blame:%s:%s:%s:%s:%s", provider, owner, repo, expression, path)
+	switch api.OAuthProvider(provider) {
+	case api.Local:
+		GetOAuthCommonProviderREST(c, provider, validParams, fetchLocalBlame, facade.GitHubRepositoryBlameCache, cacheKey, false)
+		return
+	case api.Github, api.GHES:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider not supported"})
+		return
+	}
+	GetOAuthCommonProvider(
+		c,
+		provider,
+		github.GithubRepositoryBlameQuery,
+		validParams,
+		facade.GitHubRepositoryBlameCache,
+		cacheKey,
+		false,
+	)
+}
@@ -7,6 +7,7 @@ import (
 	"githubclone-backend/api/gitlab"
 
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +25,29 @@ const restapigitlabprefix = "https://"
 const restapigitlabpath = "/api/v4"
 const restapighesprefix = "https://"
 const restapighespath = "/api/v3"
+const restapigiteapath = "/api/v1"
+
+// gitlabGraphQLEndpoint returns the GraphQL endpoint of gitlab.com or of a self-managed instance.
+// The URL of gitlab.com is a host name, a self-managed instance is addressed by its full base URL.
+func gitlabGraphQLEndpoint(provider api.OAuthProvider, url string) string {
+	if provider == api.GitlabSelfManaged {
+		return strings.TrimRight(url, "/") + graphqlgitlabpath
+	}
+	return graphqlgitlabprefix + url + graphqlgitlabpath
+}
+
+// restAPIEndpoint returns the REST api base of the providers which are served via REST calls
+// For the local provider it's the directory of the repositories.
+func restAPIEndpoint(provider api.OAuthProvider, url string) string {
+	switch provider {
+	case api.Gitea:
+		return strings.TrimRight(url, "/") + restapigiteapath
+	case api.Local:
+		return url
+	default:
+		return restapigithubprefix + url + restapigithubpath
+	}
+}
 
 func convertGitLabToGitHub(gitlabUser gitlab.GitLabUser) github.GitHubUser {
 	return github.GitHubUser{
@@ -87,27 +111,37 @@ func GetOAuthUser(c *gin.Context) {
 			endpoint := value.URL
 			token := value.Token
 			// log.Printf("endpoint=%s, token=%s", value.URL, value.Token)
-			githubData, err := common.SendGraphQLQuery[github.GitHubUser](graphqlgithubprefix+endpoint+graphqlgithubpath, github.GithubUserQuery, token, nil, false)
+			githubData, err := common.SendGraphQLQuery[github.GitHubUser](common.WithOperation(upstreamContext(c, value), "user"), graphqlgithubprefix+endpoint+graphqlgithubpath, github.GithubUserQuery, token, nil, false)
 			// log.Printf("githubdata=%v, err=%v", githubData, err)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "GraphQL request failed", "details": err.Error()})
+				upstreamError(c, "GraphQL request failed", err)
 				return
 			}
 			// You're able to manipulate the data here or put it in the cache.
 			userdata[string(api.Github)] = githubData
-		case api.Gitlab:
+		case api.Gitlab, api.GitlabSelfManaged:
 			// log.Printf("gitlab found")
-			endpoint := value.URL
+			endpoint := gitlabGraphQLEndpoint(key, value.URL)
 			token := value.Token
 			// log.Printf("endpoint=%s, token=%s", value.URL, value.Token)
-			gitlabData, err := common.SendGraphQLQuery[gitlab.GitLabUser](graphqlgitlabprefix+endpoint+graphqlgitlabpath, gitlab.GitLabUserQuery, token, nil, false)
+			gitlabData, err := common.SendGraphQLQuery[gitlab.GitLabUser](common.WithOperation(upstreamContext(c, value), "user"), endpoint, gitlab.GitLabUserQuery, token, nil, false)
 			// log.Printf("gitlabdata=%v, err=%v", gitlabData, err)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "GraphQL request failed", "details": err.Error()})
+				upstreamError(c, "GraphQL request failed", err)
 				return
 			}
 			githubData := convertGitLabToGitHub(*gitlabData)
-			userdata[string(api.Gitlab)] = githubData
+			userdata[string(key)] = githubData
+		case api.Gitea:
+			githubData, err := fetchGiteaUser(upstreamContext(c, value), restAPIEndpoint(key, value.URL), value.Token, false)
+			if err != nil {
+				upstreamError(c, "REST API request failed", err)
+				return
+			}
+			userdata[string(key)] = githubData
+		case api.Local:
+			// There is no account on a filesystem
+			continue
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "unsupported provider", "details": key})
 			return
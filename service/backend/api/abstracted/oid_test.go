@@ -0,0 +1,67 @@
+package abstracted
+
+import (
+	"githubclone-backend/fakeforge"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Contents and trees are cached under the commit id, only the mapping of the branch expires
+func TestCommitAddressedCache(t *testing.T) {
+	s := forgeRouter(t, "oid.fake.forge", 5, "octocat")
+	get := func(path string) string {
+		w := s.get(path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	const repo = "/api/oauth/%s?provider=github_enterprise&owner=octocat&name=hello-world&expression="
+	content := func(expression string) string {
+		return get(strings.Replace(repo, "%s", "repositorycontent", 1) + expression + "&content=README.md")
+	}
+	oid := regexp.MustCompile(`"oid":"([0-9a-f]{40})"`).FindStringSubmatch(get(strings.Replace(repo, "%s", "repositorybranchcommit", 1) + "main"))
+	if oid == nil {
+		t.Fatal("no commit id")
+	}
+	before := content("main")
+	get(strings.Replace(repo, "%s", "repositorycontents", 1) + "main")
+
+	s.backend.mu.Lock()
+	for _, prefix := range []string{"githubfile:", "githubrepositorytreecommit:"} {
+		found := false
+		for key := range s.backend.values {
+			found = found || strings.HasPrefix(key, prefix) && strings.HasSuffix(key, ":oid:"+oid[1])
+		}
+		if !found {
+			t.Errorf("no entry %s...:%s", prefix, oid[1])
+		}
+	}
+	s.backend.mu.Unlock()
+
+	readme := "# Changed\n"
+	if _, err := s.forge.Push("octocat", "hello-world", "main", fakeforge.CommitSeed{Message: "Change the readme", Author: "octocat", Files: map[string]*string{"README.md": &readme}}); err != nil {
+		t.Fatal(err)
+	}
+	// Let the mapping of the branch expire
+	s.backend.mu.Lock()
+	for key := range s.backend.values {
+		if strings.HasPrefix(key, "githubrepositorybranchcommit:") {
+			delete(s.backend.values, key)
+		}
+	}
+	s.backend.mu.Unlock()
+
+	if after := content("main"); after == before || !strings.Contains(after, `"content":`) {
+		t.Errorf("content of the new commit expected, got %s", after)
+	}
+	requests := s.forge.Requests()
+	if old := content(oid[1]); old != before {
+		t.Errorf("content at %s = %s, want %s", oid[1], old, before)
+	}
+	if s.forge.Requests() != requests {
+		t.Error("the content of a known commit was loaded again")
+	}
+}
@@ -0,0 +1,50 @@
+package abstracted
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// A user without access must not read the cached data of a private repository, which another
+// user of the same connection loaded before. The data of a public repository is shared.
+func TestCacheScopePerPrincipal(t *testing.T) {
+	s := forgeRouter(t, "scope.fake.forge", 4, "octocat", "hubot")
+
+	private := []string{
+		"/api/oauth/repository?provider=github_enterprise&owner=octocat&name=secret",
+		"/api/oauth/repositorybranchcommit?provider=github_enterprise&owner=octocat&name=secret&expression=main",
+		"/api/oauth/repositorycontent?provider=github_enterprise&owner=octocat&name=secret&content=notes.txt&expression=main",
+		"/api/oauth/repositorycontents?provider=github_enterprise&owner=octocat&name=secret&expression=main",
+	}
+	for _, path := range private {
+		if w := s.getAs("octocat", path); w.Code != http.StatusOK {
+			t.Fatalf("owner GET %s: status %d, body %s", path, w.Code, w.Body.String())
+		}
+	}
+	for _, path := range private {
+		requests := s.forge.Requests()
+		w := s.getAs("hubot", path)
+		if w.Code == http.StatusOK {
+			t.Errorf("GET %s by another user is %d: %s", path, w.Code, w.Body.String())
+		}
+		if s.forge.Requests() == requests {
+			t.Errorf("GET %s by another user did not ask the forge", path)
+		}
+	}
+
+	// The repository request learns that the repository is public, afterwards its data is shared
+	const public = "provider=github_enterprise&owner=octocat&name=hello-world"
+	s.getAs("octocat", "/api/oauth/repository?"+public)
+	if w := s.getAs("octocat", "/api/oauth/repositorybranchcommit?"+public+"&expression=main"); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	requests := s.forge.Requests()
+	w := s.getAs("hubot", "/api/oauth/repositorybranchcommit?"+public+"&expression=main")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"totalCount":3`) {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if s.forge.Requests() != requests {
+		t.Error("the cached data of a public repository is not shared")
+	}
+}
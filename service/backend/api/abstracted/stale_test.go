@@ -0,0 +1,141 @@
+package abstracted
+
+import (
+	"encoding/json"
+	"errors"
+	"githubclone-backend/api/common"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+// An expired entry is served while the circuit breaker of the provider is open
+func TestStaleWhileCircuitOpen(t *testing.T) {
+	// The host is only used here, the open breaker does not affect other tests
+	s := forgeRouter(t, "stale.fake.forge", 3, "octocat")
+	const path = "/api/oauth/repository?provider=github_enterprise&owner=octocat&name=hello-world"
+
+	if w := s.get(path); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	// Let the entry expire
+	s.backend.mu.Lock()
+	for key, data := range s.backend.values {
+		var entry map[string]json.RawMessage
+		json.Unmarshal(data, &entry)
+		entry["storedAt"], _ = json.Marshal(time.Now().Add(-30 * time.Minute))
+		s.backend.values[key], _ = json.Marshal(entry)
+	}
+	s.backend.mu.Unlock()
+
+	common.SetUpstreamTransport(failingTransport{})
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 10; i++ {
+		if w = s.get(path); w.Code == http.StatusOK {
+			break
+		}
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("request %d: status %d, want 502 until the breaker opens", i, w.Code)
+		}
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Warning") == "" || !strings.Contains(w.Body.String(), `"name":"hello-world"`) {
+		t.Fatalf("no stale answer: status %d, headers %v, body %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = s.get("/api/oauth/upstreamstatus")
+	if !strings.Contains(w.Body.String(), `"state":"open"`) || !strings.Contains(w.Body.String(), `"host":"api.stale.fake.forge"`) {
+		t.Errorf("unexpected status %s", w.Body.String())
+	}
+}
+
+// An entry after its TTL is answered from the cache and refreshed in the background
+func TestStaleWhileRevalidate(t *testing.T) {
+	s := forgeRouter(t, "revalidate.fake.forge", 7, "octocat")
+	const path = "/api/oauth/repository?provider=github_enterprise&owner=octocat&name=hello-world"
+
+	if w := s.get(path); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("status %d, X-Cache %q: %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	// The first answer shows that the repository is public, the entry is shared from then on
+	s.get(path)
+	if w := s.get(path); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	// The TTL of the repository is 10 minutes, the entry is stale but not expired
+	storedAt, _ := json.Marshal(time.Now().Add(-15 * time.Minute))
+	s.backend.mu.Lock()
+	for key, data := range s.backend.values {
+		var entry map[string]json.RawMessage
+		json.Unmarshal(data, &entry)
+		entry["storedAt"] = storedAt
+		s.backend.values[key], _ = json.Marshal(entry)
+	}
+	s.backend.mu.Unlock()
+
+	requests := s.forge.Requests()
+	w := s.get(path)
+	if age, _ := strconv.Atoi(w.Header().Get("Age")); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE" || age < 900 {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.forge.Requests() == requests && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.forge.Requests() == requests {
+		t.Fatal("the stale entry was not refreshed")
+	}
+	for time.Now().Before(deadline) {
+		if w = s.get(path); w.Header().Get("X-Cache") == "HIT" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Age") != "0" {
+		t.Errorf("refreshed entry: headers %v", w.Header())
+	}
+}
+
+// The repository list has the cache headers and is refreshed in the background like the other pages
+func TestRepositoryListRevalidates(t *testing.T) {
+	s := forgeRouter(t, "repositories.fake.forge", 8, "octocat")
+	const path = "/api/oauth/repositories"
+
+	if w := s.get(path); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), `"hello-world"`) {
+		t.Fatalf("status %d, X-Cache %q: %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w := s.get(path); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	storedAt, _ := json.Marshal(time.Now().Add(-15 * time.Minute))
+	s.backend.mu.Lock()
+	for key, data := range s.backend.values {
+		var entry map[string]json.RawMessage
+		json.Unmarshal(data, &entry)
+		entry["storedAt"] = storedAt
+		s.backend.values[key], _ = json.Marshal(entry)
+	}
+	s.backend.mu.Unlock()
+
+	requests := s.forge.Requests()
+	if w := s.get(path); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE" || w.Header().Get("Age") == "" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.forge.Requests() == requests && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.forge.Requests() == requests {
+		t.Fatal("the stale list was not refreshed")
+	}
+}
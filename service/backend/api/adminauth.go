@@ -0,0 +1,62 @@
+package api
+
+import (
+	"crypto/subtle"
+	"githubclone-backend/db"
+	"githubclone-backend/models"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminToken allows scripts to call the administration endpoints without a session, it's set with
+// CACHE_ADMIN_TOKEN and sent as bearer token. Empty disables the token.
+var adminToken = os.Getenv("CACHE_ADMIN_TOKEN")
+
+// sessionUserID returns the id of the user of a session
+func sessionUserID(sessionID string) (uint, bool) {
+	oauthConfigMutex.Lock()
+	session, exists := sessionConfig[sessionID]
+	oauthConfigMutex.Unlock()
+	if !exists {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(session.user["id"], 10, 64)
+	return uint(id), err == nil
+}
+
+// RequireAdmin lets only administrators pass, i.e. sessions of users of the type admin or
+// requests with the configured admin token
+func RequireAdmin(c *gin.Context) {
+	if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found && adminToken != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+		return
+	}
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "No session ID found in cookie"})
+		return
+	}
+	userID, ok := sessionUserID(sessionID)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+		return
+	}
+	var user models.User
+	if err := db.DB.First(&user, userID).Error; err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if user.UserType != "admin" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Administrator rights required"})
+		return
+	}
+	c.Next()
+}
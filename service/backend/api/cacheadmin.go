@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"githubclone-backend/cachable"
+	"githubclone-backend/cache"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCacheKeyLimit = 100
+
+type cacheStatsType struct {
+	cache.Info
+	RAM   cache.LayerStats `json:"ram"`
+	Store cache.LayerStats `json:"store"`
+}
+
+// cacheAdmin returns the administration of the cache backend, it answers the request if it's not available
+func cacheAdmin(c *gin.Context) (*cachable.CacheFacade, cache.AdminBackend, bool) {
+	facade := c.MustGet("cacheFacade").(*cachable.CacheFacade)
+	admin, ok := facade.Admin()
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "The cache backend does not support the administration"})
+	}
+	return facade, admin, ok
+}
+
+// GetCacheStats lists the typed caches with the number and size of their entries per level
+func GetCacheStats(c *gin.Context) {
+	facade, admin, ok := cacheAdmin(c)
+	if !ok {
+		return
+	}
+	var stats []cacheStatsType
+	for _, info := range facade.Caches() {
+		ram, store, err := admin.Stats(cache.EscapeGlob(info.Prefix) + ":*")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Reading the cache failed", "details": err.Error()})
+			return
+		}
+		stats = append(stats, cacheStatsType{Info: info, RAM: ram, Store: store})
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetCacheKeys lists the keys matching the glob pattern of the query parameter pattern
+func GetCacheKeys(c *gin.Context) {
+	_, admin, ok := cacheAdmin(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultCacheKeyLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	keys, err := admin.Keys(c.DefaultQuery("pattern", "*"), limit)
+	if errors.Is(err, cache.ErrInvalidPattern) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reading the cache failed", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// GetCacheKey returns an entry together with its value
+func GetCacheKey(c *gin.Context) {
+	_, admin, ok := cacheAdmin(c)
+	if !ok {
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+		return
+	}
+	info, value, err := admin.Lookup(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reading the cache failed", "details": err.Error()})
+		return
+	}
+	if info == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entry": info, "value": json.RawMessage(value)})
+}
+
+// DeleteCacheKey removes a single entry from all levels
+func DeleteCacheKey(c *gin.Context) {
+	_, admin, ok := cacheAdmin(c)
+	if !ok {
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required"})
+		return
+	}
+	deleted, err := admin.Delete(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Deleting the key failed", "details": err.Error()})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	log.Printf("Cache key %s deleted", key)
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// invalidationPatterns returns the patterns for exactly one of the query parameters prefix,
+// pattern and repository. The keys of a repository contain owner and name separated by a colon,
+// the visibility uses owner/name.
+func invalidationPatterns(c *gin.Context) ([]string, error) {
+	var patterns []string
+	given := 0
+	if prefix := c.Query("prefix"); prefix != "" {
+		given++
+		patterns = []string{cache.EscapeGlob(prefix) + "*"}
+	}
+	if pattern := c.Query("pattern"); pattern != "" {
+		given++
+		patterns = []string{pattern}
+	}
+	if repository := c.Query("repository"); repository != "" {
+		given++
+		owner, name, found := strings.Cut(repository, "/")
+		if !found || owner == "" || name == "" {
+			return nil, errors.New("repository must be owner/name")
+		}
+		owner, name = cache.EscapeGlob(owner), cache.EscapeGlob(name)
+		patterns = []string{
+			"*:" + owner + ":" + name,
+			"*:" + owner + ":" + name + ":*",
+			"*:" + strings.ToLower(owner+"/"+name),
+		}
+	}
+	if given != 1 {
+		return nil, errors.New("exactly one of prefix, pattern or repository is required")
+	}
+	return patterns, nil
+}
+
+// InvalidateCache removes all entries matching a prefix, a glob pattern or a repository
+func InvalidateCache(c *gin.Context) {
+	_, admin, ok := cacheAdmin(c)
+	if !ok {
+		return
+	}
+	patterns, err := invalidationPatterns(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	deleted := 0
+	for _, pattern := range patterns {
+		keys, err := admin.Keys(pattern, 0)
+		if errors.Is(err, cache.ErrInvalidPattern) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Reading the cache failed", "details": err.Error()})
+			return
+		}
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.Key
+		}
+		count, err := admin.Delete(names...)
+		deleted += count
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalidating the cache failed", "details": err.Error(), "deleted": deleted})
+			return
+		}
+	}
+	log.Printf("Cache invalidated for %v, %d entries deleted", patterns, deleted)
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// CacheAdminRoutes registers the administration of the cache, only administrators may use it
+func CacheAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/api/admin/cache", RequireAdmin)
+	admin.GET("", GetCacheStats)
+	admin.GET("/keys", GetCacheKeys)
+	admin.GET("/key", GetCacheKey)
+	admin.DELETE("/key", DeleteCacheKey)
+	admin.DELETE("", InvalidateCache)
+}
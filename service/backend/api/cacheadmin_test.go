@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"githubclone-backend/cachable"
+	"githubclone-backend/cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The cache administration is only available to administrators
+func TestCacheAdminRequiresAdmin(t *testing.T) {
+	mlc, err := cache.NewMemoryCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mlc.Close()
+	facade := cachable.NewCacheFacade(context.Background(), mlc)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("cacheFacade", facade)
+		c.Next()
+	})
+	CacheAdminRoutes(router)
+
+	previous := adminToken
+	adminToken = "secret"
+	t.Cleanup(func() { adminToken = previous })
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		prepare func(*http.Request)
+		want    int
+	}{
+		{"anonymous", http.MethodGet, "/api/admin/cache/key?key=x", func(*http.Request) {}, http.StatusUnauthorized},
+		{"anonymous delete", http.MethodDelete, "/api/admin/cache?prefix=x", func(*http.Request) {}, http.StatusUnauthorized},
+		{"unknown session", http.MethodGet, "/api/admin/cache", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session_id", Value: "unknown"})
+		}, http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/admin/cache", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer wrong")
+		}, http.StatusUnauthorized},
+		{"admin token", http.MethodGet, "/api/admin/cache", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer secret")
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		tt.prepare(req)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Every upstream host has a circuit breaker. After BreakerFailures consecutive failures it opens
+// and requests fail at once instead of waiting for timeouts. When BreakerOpen has passed, a single
+// request probes the host (half-open), its success closes the breaker, a failure opens it again.
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError is returned instead of sending a request to a host whose breaker is open
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of %s is open until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+// BreakerStatus describes the breaker of a host for the status endpoint
+type BreakerStatus struct {
+	Host        string     `json:"host"`
+	Connection  string     `json:"connection"`
+	State       string     `json:"state"`
+	Failures    int        `json:"failures"`
+	OpenUntil   *time.Time `json:"openUntil,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+}
+
+type breaker struct {
+	state       BreakerState
+	failures    int
+	until       time.Time // End of the open state
+	probing     bool      // A request of the half-open state is in flight
+	connection  string
+	lastError   string
+	lastFailure time.Time
+}
+
+// outcome is the result of a request for the breaker
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored // e.g. cancelled by the browser, this says nothing about the host
+)
+
+var (
+	breakers      = make(map[string]*breaker)
+	breakersMutex sync.Mutex
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "githubclone_upstream_breaker_state",
+		Help: "State of the circuit breaker per upstream host, 0 closed, 1 half-open, 2 open",
+	}, []string{"connection", "host"})
+	breakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_upstream_breaker_trips_total",
+		Help: "Number of times the circuit breaker of an upstream host opened",
+	}, []string{"connection", "host"})
+	breakerRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_upstream_breaker_rejected_total",
+		Help: "Upstream requests which were not sent because the circuit breaker was open",
+	}, []string{"connection", "host"})
+)
+
+// setState changes the state, the caller holds breakersMutex
+func (b *breaker) setState(host string, state BreakerState) {
+	if b.state != state {
+		log.Printf("Circuit breaker of %s is %s", host, state)
+	}
+	b.state = state
+	breakerState.WithLabelValues(b.connection, host).Set(float64(state))
+}
+
+// acquireBreaker checks if a request to host may be sent. The returned function has to be called
+// with the outcome of the request.
+func acquireBreaker(ctx context.Context, host string) (func(outcome, error), error) {
+	if upstream.BreakerFailures <= 0 {
+		return func(outcome, error) {}, nil
+	}
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	b, ok := breakers[host]
+	if !ok {
+		b = &breaker{}
+		breakers[host] = b
+	}
+	if connection := connectionOf(ctx); connection != "unknown" {
+		b.connection = connection
+	}
+
+	probe := false
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.until) {
+			breakerRejected.WithLabelValues(b.connection, host).Inc()
+			return nil, &CircuitOpenError{Host: host, Until: b.until}
+		}
+		b.setState(host, BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			breakerRejected.WithLabelValues(b.connection, host).Inc()
+			return nil, &CircuitOpenError{Host: host, Until: b.until}
+		}
+		b.probing = true
+		probe = true
+	}
+
+	return func(result outcome, err error) {
+		breakersMutex.Lock()
+		defer breakersMutex.Unlock()
+		if probe {
+			b.probing = false
+		}
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+			b.setState(host, BreakerClosed)
+		case outcomeFailure:
+			b.failures++
+			b.lastFailure = time.Now()
+			if err != nil {
+				b.lastError = err.Error()
+			}
+			if probe || (b.state == BreakerClosed && b.failures >= upstream.BreakerFailures) {
+				b.until = time.Now().Add(upstream.BreakerOpen)
+				breakerTrips.WithLabelValues(b.connection, host).Inc()
+				b.setState(host, BreakerOpen)
+			}
+		}
+	}, nil
+}
+
+// breakerOutcome classifies the result of a request. Network errors and answers which say that
+// the host is overloaded or down count as failures.
+func breakerOutcome(ctx context.Context, resp *http.Response, err error) (outcome, error) {
+	if err != nil {
+		if ctx.Err() != nil {
+			return outcomeIgnored, err
+		}
+		return outcomeFailure, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return outcomeFailure, fmt.Errorf("upstream answered %d", resp.StatusCode)
+	}
+	return outcomeSuccess, nil
+}
+
+// status returns the state of a breaker, an open breaker whose time is up is reported half-open
+func (b *breaker) status(host string) BreakerStatus {
+	status := BreakerStatus{
+		Host:       host,
+		Connection: b.connection,
+		State:      b.state.String(),
+		Failures:   b.failures,
+		LastError:  b.lastError,
+	}
+	if b.state == BreakerOpen {
+		if time.Now().Before(b.until) {
+			until := b.until
+			status.OpenUntil = &until
+		} else {
+			status.State = BreakerHalfOpen.String()
+		}
+	}
+	if !b.lastFailure.IsZero() {
+		lastFailure := b.lastFailure
+		status.LastFailure = &lastFailure
+	}
+	return status
+}
+
+// BreakerStatusOf returns the state of the breaker of the host of rawURL
+func BreakerStatusOf(rawURL string) BreakerStatus {
+	host := HostOf(rawURL)
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	if b, ok := breakers[host]; ok {
+		return b.status(host)
+	}
+	return BreakerStatus{Host: host, State: BreakerClosed.String()}
+}
+
+// BreakerStatuses returns the states of all known hosts sorted by host
+func BreakerStatuses() []BreakerStatus {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for host, b := range breakers {
+		statuses = append(statuses, b.status(host))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	saved := upstream
+	upstream.MaxRetries = 0
+	upstream.BreakerFailures = 3
+	upstream.BreakerOpen = 50 * time.Millisecond
+	t.Cleanup(func() { upstream = saved })
+
+	var down atomic.Bool
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	send := func() error {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := DoUpstream(WithConnection(context.Background(), "7"), req, true)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	state := func() string { return BreakerStatusOf(server.URL).State }
+
+	down.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := send(); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if state() != "open" {
+		t.Fatalf("breaker is %s after 3 failures", state())
+	}
+	var circuitErr *CircuitOpenError
+	if err := send(); !errors.As(err, &circuitErr) {
+		t.Fatalf("open breaker sent the request, error %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("%d calls reached the host, want 3", calls.Load())
+	}
+	if status := BreakerStatusOf(server.URL); status.Connection != "7" || status.OpenUntil == nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// The failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if state() != "half-open" {
+		t.Fatalf("breaker is %s after the open time", state())
+	}
+	send()
+	if state() != "open" {
+		t.Fatalf("breaker is %s after a failed probe", state())
+	}
+
+	// The successful probe closes it
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if status := BreakerStatusOf(server.URL); status.State != "closed" || status.Failures != 0 {
+		t.Errorf("unexpected status after recovery %+v", status)
+	}
+}
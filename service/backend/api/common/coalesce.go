@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Concurrent cache misses for the same key share one upstream call. The call does not run with
+// the context of the first caller, it is canceled when the last waiting caller goes away.
+
+var coalescedRequests = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "githubclone_upstream_coalesced_total",
+	Help: "Upstream requests which were answered by an identical request already in flight",
+})
+
+type inflightCall struct {
+	done    chan struct{}
+	value   any
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+var (
+	inflight      = make(map[string]*inflightCall)
+	inflightMutex sync.Mutex
+)
+
+// CoalesceKey identifies an upstream call by the cache key and the token, answers for different
+// tokens are never shared
+func CoalesceKey(cacheKey, token string) string {
+	return cacheKey + ":" + TokenKey(token)
+}
+
+// Coalesce runs fn once for all concurrent callers with the same key. The values of ctx, e.g. the
+// connection and the priority, are passed on to the call of the first caller.
+func Coalesce[T any](ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	inflightMutex.Lock()
+	call, ok := inflight[key]
+	if ok {
+		call.waiters++
+		inflightMutex.Unlock()
+		coalescedRequests.Inc()
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &inflightCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		inflight[key] = call
+		inflightMutex.Unlock()
+
+		go func() {
+			defer cancel()
+			value, err := fn(callCtx)
+			inflightMutex.Lock()
+			call.value, call.err = value, err
+			if inflight[key] == call {
+				delete(inflight, key)
+			}
+			inflightMutex.Unlock()
+			close(call.done)
+		}()
+	}
+
+	select {
+	case <-call.done:
+		value, _ := call.value.(T)
+		return value, call.err
+	case <-ctx.Done():
+		inflightMutex.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody waits for the answer anymore, later callers start a new call
+			call.cancel()
+			if inflight[key] == call {
+				delete(inflight, key)
+			}
+		}
+		inflightMutex.Unlock()
+		var zero T
+		return zero, ctx.Err()
+	}
+}
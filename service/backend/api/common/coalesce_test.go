@@ -0,0 +1,80 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSharesOneCall(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "answer", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = Coalesce(context.Background(), "shared", fn)
+		}(i)
+	}
+	// Give all callers the chance to join the call
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	for i, result := range results {
+		if result != "answer" {
+			t.Errorf("result %d = %q, want answer", i, result)
+		}
+	}
+}
+
+func TestCoalesceCancellation(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return "", ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, err := Coalesce(first, "cancel", fn); errs <- err }()
+	<-started
+	go func() { _, err := Coalesce(second, "cancel", fn); errs <- err }()
+	time.Sleep(20 * time.Millisecond)
+
+	// The call goes on as long as a caller waits
+	cancelFirst()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("first caller: err = %v, want canceled", err)
+	}
+	select {
+	case <-canceled:
+		t.Fatal("call was canceled while the second caller waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelSecond()
+	<-errs
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("call was not canceled after the last caller left")
+	}
+}
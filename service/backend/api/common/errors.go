@@ -0,0 +1,73 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GraphQLError is an entry of the errors array of a GraphQL response
+type GraphQLError struct {
+	Message   string        `json:"message"`
+	Type      string        `json:"type,omitempty"` // e.g. NOT_FOUND, FORBIDDEN, RATE_LIMITED
+	Path      []interface{} `json:"path,omitempty"`
+	Locations []struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"locations,omitempty"`
+}
+
+func (e GraphQLError) String() string {
+	if len(e.Path) == 0 {
+		return fmt.Sprintf("%s: %s", e.Type, e.Message)
+	}
+	path := make([]string, 0, len(e.Path))
+	for _, element := range e.Path {
+		path = append(path, fmt.Sprint(element))
+	}
+	return fmt.Sprintf("%s at %s: %s", e.Type, strings.Join(path, "."), e.Message)
+}
+
+// UpstreamError is a failed request to a provider. Status is the HTTP status for the client.
+type UpstreamError struct {
+	Status      int
+	Upstream    int // HTTP status of the provider
+	Message     string
+	Errors      []GraphQLError `json:",omitempty"`
+	RateLimited bool           // The secondary rate limit rejected the request, it says nothing about the object
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Message
+}
+
+// upstreamStatus maps the HTTP status of a provider to the status for the client
+func upstreamStatus(status int) int {
+	switch status {
+	case http.StatusNotFound:
+		return http.StatusNotFound
+	case http.StatusConflict:
+		return http.StatusConflict // e.g. an empty repository
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return http.StatusForbidden
+	case http.StatusTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// graphQLStatus maps the type of the first GraphQL error to the status for the client
+func graphQLStatus(errors []GraphQLError) int {
+	for _, e := range errors {
+		switch e.Type {
+		case "NOT_FOUND":
+			return http.StatusNotFound
+		case "FORBIDDEN", "INSUFFICIENT_SCOPES":
+			return http.StatusForbidden
+		case "RATE_LIMITED":
+			return http.StatusTooManyRequests
+		}
+	}
+	return http.StatusBadGateway
+}
@@ -2,7 +2,9 @@ package common
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -65,36 +67,71 @@ func ValidateGraphQLParams(useCursor bool, rawParams map[string]string, addition
 	return params
 }
 
-func SendGraphQLQuery[T any](endpoint, query, token string, variables map[string]interface{}, islog bool) (*T, error) {
+// graphQLEnvelope is the part of every GraphQL response which is independent of the query
+type graphQLEnvelope struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []GraphQLError  `json:"errors"`
+}
+
+// hasData reports whether at least one top level field of data is not null. GitHub answers
+// e.g. a missing repository with {"repository": null} and a NOT_FOUND error.
+func (e graphQLEnvelope) hasData() bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.Data, &fields); err != nil {
+		return false
+	}
+	for _, value := range fields {
+		if string(value) != "null" {
+			return true
+		}
+	}
+	return false
+}
+
+// SendGraphQLQuery sends a query to a GraphQL endpoint, partial data is returned with a log entry.
+func SendGraphQLQuery[T any](ctx context.Context, endpoint, query, token string, variables map[string]interface{}, islog bool) (*T, error) {
+	result, warnings, err := SendGraphQLQueryPartial[T](ctx, endpoint, query, token, variables, islog)
+	for _, warning := range warnings {
+		log.Printf("GraphQL partial data: %s", warning)
+	}
+	return result, err
+}
+
+// SendGraphQLQueryPartial sends a query to a GraphQL endpoint. The service only reads, so the
+// request is idempotent and is retried on transient errors. A response without data is returned
+// as *UpstreamError, the errors of a response with partial data are returned as warnings.
+func SendGraphQLQueryPartial[T any](ctx context.Context, endpoint, query, token string, variables map[string]interface{}, islog bool) (*T, []GraphQLError, error) {
 	// log.Printf("SendGraphQLQuery: endpoint=%s, query=%s, token=%s, variables=%v", endpoint, query, token, variables)
 	requestBody, err := json.Marshal(GraphQLRequest{Query: query, Variables: variables})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if islog {
 		log.Printf("requestbody=%s", ASCIIToStringFromBytes(requestBody))
 	}
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(requestBody))
+	if err := checkRateLimit(ctx, token, RateLimitGraphQL); err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(requestBody))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
 	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := DoUpstream(ctx, req, true)
+	observeUpstream(ctx, apiGraphQL, start)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	limit := resp.Header.Get("X-RateLimit-Limit")
-	remaining := resp.Header.Get("X-RateLimit-Remaining")
-	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
-
-	log.Printf("[GitHub GraphQL] Remaining: %s / %s | Reset at: %s", remaining, limit, time.Unix(reset, 0).Local())
+	if err := recordRateLimit(ctx, token, RateLimitGraphQL, resp); err != nil {
+		return nil, nil, err
+	}
 
 	// Read answer
 	body, err := io.ReadAll(resp.Body)
@@ -102,17 +139,41 @@ func SendGraphQLQuery[T any](endpoint, query, token string, variables map[string
 		log.Printf("body=%s", ASCIIToStringFromBytes(body))
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	var envelope graphQLEnvelope
+	envelopeErr := json.Unmarshal(body, &envelope)
+	if resp.StatusCode != http.StatusOK {
+		upstreamErr := &UpstreamError{
+			Status:      upstreamStatus(resp.StatusCode),
+			Upstream:    resp.StatusCode,
+			Message:     fmt.Sprintf("GraphQL API error %d: %s", resp.StatusCode, ASCIIToStringFromBytes(body)),
+			Errors:      envelope.Errors,
+			RateLimited: secondaryRateLimited(resp, body),
+		}
+		return nil, nil, upstreamErr
+	}
+	if envelopeErr != nil {
+		return nil, nil, &UpstreamError{Status: http.StatusBadGateway, Upstream: resp.StatusCode, Message: fmt.Sprintf("invalid GraphQL response: %v", envelopeErr)}
+	}
+	if len(envelope.Errors) > 0 && !envelope.hasData() {
+		return nil, nil, &UpstreamError{
+			Status:   graphQLStatus(envelope.Errors),
+			Upstream: resp.StatusCode,
+			Message:  "GraphQL error: " + envelope.Errors[0].String(),
+			Errors:   envelope.Errors,
+		}
 	}
 
 	// JSON decoding in generic structure
 	var result T
 	err = json.Unmarshal(body, &result)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if islog {
 		log.Printf("result=%v", result)
 	}
-	return &result, nil
+	return &result, envelope.Errors, nil
 }
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testViewer struct {
+	Data struct {
+		Viewer *struct {
+			Login string `json:"login"`
+		} `json:"viewer"`
+		Repository *struct {
+			Name string `json:"name"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+func TestSendGraphQLQueryPartial(t *testing.T) {
+	fastRetries(t)
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantStatus   int // Status of the UpstreamError, 0 for success
+		wantWarnings int
+	}{
+		{
+			name:   "data",
+			status: http.StatusOK,
+			body:   `{"data":{"viewer":{"login":"octocat"}}}`,
+		},
+		{
+			name:       "not found",
+			status:     http.StatusOK,
+			body:       `{"data":{"repository":null},"errors":[{"type":"NOT_FOUND","path":["repository"],"message":"Could not resolve to a Repository"}]}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "rate limited",
+			status:     http.StatusOK,
+			body:       `{"data":null,"errors":[{"type":"RATE_LIMITED","message":"API rate limit exceeded"}]}`,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:         "partial data",
+			status:       http.StatusOK,
+			body:         `{"data":{"viewer":{"login":"octocat"},"repository":null},"errors":[{"type":"FORBIDDEN","path":["repository"],"message":"Resource not accessible"}]}`,
+			wantWarnings: 1,
+		},
+		{
+			name:       "bad credentials",
+			status:     http.StatusUnauthorized,
+			body:       `{"message":"Bad credentials"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "server error",
+			status:     http.StatusInternalServerError,
+			body:       `{"message":"Server Error"}`,
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			result, warnings, err := SendGraphQLQueryPartial[testViewer](context.Background(), server.URL, "{ viewer { login } }", "token-"+tt.name, nil, false)
+			if tt.wantStatus != 0 {
+				var upstreamErr *UpstreamError
+				if !errors.As(err, &upstreamErr) {
+					t.Fatalf("expected UpstreamError, got %v", err)
+				}
+				if upstreamErr.Status != tt.wantStatus {
+					t.Errorf("status = %d, want %d", upstreamErr.Status, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Data.Viewer == nil || result.Data.Viewer.Login != "octocat" {
+				t.Errorf("unexpected data: %+v", result.Data)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamConfig controls the requests to the providers. The values can be set with the
+// environment variables UPSTREAM_TIMEOUT (e.g. "30s"), UPSTREAM_MAX_RETRIES, UPSTREAM_MAX_IDLE_CONNS_PER_HOST,
+// UPSTREAM_BREAKER_FAILURES and UPSTREAM_BREAKER_OPEN.
+type UpstreamConfig struct {
+	Timeout             time.Duration // Timeout of a single request including reading the body
+	MaxRetries          int           // Retries of idempotent requests, 0 disables them
+	BaseBackoff         time.Duration // Backoff before the first retry, it doubles with each retry
+	MaxBackoff          time.Duration
+	MaxRetryAfter       time.Duration // A longer Retry-After is not waited for, the error is returned
+	MaxIdleConnsPerHost int
+	BreakerFailures     int           // Consecutive failures which open the circuit breaker of a host, 0 disables it
+	BreakerOpen         time.Duration // Time until a request probes the host again
+}
+
+var upstream = loadUpstreamConfig()
+
+func loadUpstreamConfig() UpstreamConfig {
+	config := UpstreamConfig{
+		Timeout:             30 * time.Second,
+		MaxRetries:          3,
+		BaseBackoff:         500 * time.Millisecond,
+		MaxBackoff:          8 * time.Second,
+		MaxRetryAfter:       time.Minute,
+		MaxIdleConnsPerHost: 20,
+		BreakerFailures:     5,
+		BreakerOpen:         30 * time.Second,
+	}
+	if value := os.Getenv("UPSTREAM_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			config.Timeout = timeout
+		} else {
+			log.Printf("Invalid UPSTREAM_TIMEOUT %q, using %v", value, config.Timeout)
+		}
+	}
+	if value := os.Getenv("UPSTREAM_MAX_RETRIES"); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			config.MaxRetries = retries
+		} else {
+			log.Printf("Invalid UPSTREAM_MAX_RETRIES %q, using %d", value, config.MaxRetries)
+		}
+	}
+	if value := os.Getenv("UPSTREAM_MAX_IDLE_CONNS_PER_HOST"); value != "" {
+		if conns, err := strconv.Atoi(value); err == nil && conns > 0 {
+			config.MaxIdleConnsPerHost = conns
+		}
+	}
+	if value := os.Getenv("UPSTREAM_BREAKER_FAILURES"); value != "" {
+		if failures, err := strconv.Atoi(value); err == nil && failures >= 0 {
+			config.BreakerFailures = failures
+		} else {
+			log.Printf("Invalid UPSTREAM_BREAKER_FAILURES %q, using %d", value, config.BreakerFailures)
+		}
+	}
+	if value := os.Getenv("UPSTREAM_BREAKER_OPEN"); value != "" {
+		if open, err := time.ParseDuration(value); err == nil && open > 0 {
+			config.BreakerOpen = open
+		} else {
+			log.Printf("Invalid UPSTREAM_BREAKER_OPEN %q, using %v", value, config.BreakerOpen)
+		}
+	}
+	return config
+}
+
+// newTransport returns a transport which keeps the connections to the providers open
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = upstream.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = upstream.Timeout
+	return transport
+}
+
+var (
+	sharedClient     = &http.Client{Transport: newTransport(), Timeout: upstream.Timeout}
+	hostClients      = make(map[string]*http.Client)
+	hostOptions      = make(map[string]TransportOptions) // Settings of the clients in hostClients
+	hostClientsMutex sync.RWMutex
+	transportClient  *http.Client // Replaces all clients if set
+)
+
+// HostOf returns the host (with port) of a url, a url without scheme is interpreted as https
+func HostOf(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// TransportOptions are the network settings of a connection. The zero value uses the
+// system roots and the proxy of the environment (HTTPS_PROXY, NO_PROXY).
+type TransportOptions struct {
+	ProxyURL           string // http, https or socks5 proxy for all requests of the connection
+	CAPEM              string // Certificates which are trusted in addition to the system roots
+	ClientCertPEM      string // Client certificate and key for instances requiring mTLS
+	ClientKeyPEM       string
+	InsecureSkipVerify bool // Disables the verification of the server certificate, only for labs
+}
+
+// IsZero reports whether the options need no dedicated client
+func (o TransportOptions) IsZero() bool {
+	return o == TransportOptions{}
+}
+
+// NewTransportWithOptions returns a transport to the providers with the settings of a connection
+func NewTransportWithOptions(options TransportOptions) (*http.Transport, error) {
+	transport := newTransport()
+
+	if options.ProxyURL != "" {
+		proxyURL, err := url.Parse(options.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url: %s", options.ProxyURL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+	if options.CAPEM != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(options.CAPEM)) {
+			return nil, fmt.Errorf("no valid certificate found in the CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if options.ClientCertPEM != "" || options.ClientKeyPEM != "" {
+		certificate, err := tls.X509KeyPair([]byte(options.ClientCertPEM), []byte(options.ClientKeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// ConfigureHosts uses the settings of a connection for all requests to the hosts of the
+// given urls, e.g. the web and the api host of an enterprise server. Zero options remove
+// a previous configuration.
+func ConfigureHosts(options TransportOptions, urls ...string) error {
+	hosts := make([]string, 0, len(urls))
+	for _, rawURL := range urls {
+		host := HostOf(rawURL)
+		if host == "" {
+			return fmt.Errorf("invalid url for the connection settings: %s", rawURL)
+		}
+		hosts = append(hosts, host)
+	}
+
+	hostClientsMutex.RLock()
+	unchanged := true
+	for _, host := range hosts {
+		if hostOptions[host] != options {
+			unchanged = false
+		}
+	}
+	hostClientsMutex.RUnlock()
+	if unchanged {
+		return nil // Keeps the open connections
+	}
+
+	var client *http.Client
+	if !options.IsZero() {
+		transport, err := NewTransportWithOptions(options)
+		if err != nil {
+			return fmt.Errorf("connection settings for %s: %w", strings.Join(hosts, ", "), err)
+		}
+		if options.InsecureSkipVerify {
+			log.Printf("Certificate verification is disabled for %s", strings.Join(hosts, ", "))
+		}
+		client = &http.Client{Transport: transport, Timeout: upstream.Timeout}
+	}
+
+	hostClientsMutex.Lock()
+	defer hostClientsMutex.Unlock()
+	for _, host := range hosts {
+		if client == nil {
+			delete(hostClients, host)
+			delete(hostOptions, host)
+		} else {
+			hostClients[host] = client
+			hostOptions[host] = options
+		}
+	}
+	return nil
+}
+
+// SetUpstreamTransport sends all upstream requests through rt, e.g. a Recorder in the tests.
+// With nil the clients per host are used again.
+func SetUpstreamTransport(rt http.RoundTripper) {
+	hostClientsMutex.Lock()
+	defer hostClientsMutex.Unlock()
+	if rt == nil {
+		transportClient = nil
+		return
+	}
+	transportClient = &http.Client{Transport: rt, Timeout: upstream.Timeout}
+}
+
+// HTTPClientFor returns the http client which has to be used for requests to rawURL
+func HTTPClientFor(rawURL string) *http.Client {
+	hostClientsMutex.RLock()
+	defer hostClientsMutex.RUnlock()
+	if transportClient != nil {
+		return transportClient
+	}
+	if client, ok := hostClients[HostOf(rawURL)]; ok {
+		return client
+	}
+	return sharedClient
+}
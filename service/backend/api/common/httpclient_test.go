@@ -0,0 +1,117 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// clientCertificate returns a self-signed client certificate and its key as PEM
+func clientCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "backend"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestConfigureHosts(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	certPEM, keyPEM := clientCertificate(t)
+	defer ConfigureHosts(TransportOptions{}, server.URL)
+
+	tests := []struct {
+		name       string
+		options    TransportOptions
+		wantStatus int // 0 for a failed handshake
+	}{
+		{"system roots", TransportOptions{}, 0},
+		{"custom CA", TransportOptions{CAPEM: caPEM}, http.StatusUnauthorized},
+		{"client certificate", TransportOptions{CAPEM: caPEM, ClientCertPEM: certPEM, ClientKeyPEM: keyPEM}, http.StatusOK},
+		{"insecure", TransportOptions{InsecureSkipVerify: true}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ConfigureHosts(tt.options, server.URL); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := HTTPClientFor(server.URL + "/api").Get(server.URL)
+			status := 0
+			if err == nil {
+				status = resp.StatusCode
+				resp.Body.Close()
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status %d, want %d (error %v)", status, tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestConfigureHostsProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	if err := ConfigureHosts(TransportOptions{ProxyURL: proxy.URL}, "http://ghes.internal", "api.ghes.internal"); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureHosts(TransportOptions{}, "http://ghes.internal", "api.ghes.internal")
+
+	resp, err := HTTPClientFor("http://api.ghes.internal/graphql").Get("http://api.ghes.internal/graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://api.ghes.internal/graphql" {
+		t.Errorf("proxy received %q", proxied)
+	}
+	if HTTPClientFor("https://github.com") != sharedClient {
+		t.Error("other hosts must not use the proxy")
+	}
+
+	if err := ConfigureHosts(TransportOptions{ProxyURL: "ftp://proxy"}, "ghes.internal"); err == nil {
+		t.Error("unsupported proxy scheme accepted")
+	}
+	if err := ConfigureHosts(TransportOptions{CAPEM: "no certificate"}, "ghes.internal"); err == nil {
+		t.Error("invalid CA bundle accepted")
+	}
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"context"
+	"maps"
+)
+
+// GraphQL connections return at most 100 nodes per page. The iterator follows pageInfo.endCursor
+// until the last page or until the page budget is used up, a complete list of a large repository
+// must not use up the rate limit.
+
+const (
+	MaxPageSize       = 100 // Maximum of first for GitHub connections
+	DefaultPageBudget = 20  // Maximum number of pages for a complete list
+)
+
+// PageFetcher loads the page after the cursor, the cursor of the first page is empty
+type PageFetcher[N any] func(ctx context.Context, after string) ([]N, PageInfo, error)
+
+// PageIterator walks the pages of a connection
+type PageIterator[N any] struct {
+	fetch     PageFetcher[N]
+	budget    int
+	pages     int
+	after     string
+	done      bool
+	truncated bool
+	err       error
+}
+
+func NewPageIterator[N any](fetch PageFetcher[N], budget int) *PageIterator[N] {
+	if budget < 1 {
+		budget = DefaultPageBudget
+	}
+	return &PageIterator[N]{fetch: fetch, budget: budget}
+}
+
+// Next returns the nodes of the next page, false after the last page, the budget or an error
+func (it *PageIterator[N]) Next(ctx context.Context) ([]N, bool) {
+	if it.done {
+		return nil, false
+	}
+	if it.pages >= it.budget {
+		it.done, it.truncated = true, true
+		return nil, false
+	}
+	if err := ctx.Err(); err != nil {
+		it.done, it.err = true, err
+		return nil, false
+	}
+	nodes, pageInfo, err := it.fetch(ctx, it.after)
+	if err != nil {
+		it.done, it.err = true, err
+		return nil, false
+	}
+	it.pages++
+	// A page without a new cursor would be requested forever
+	if !pageInfo.HasNextPage || pageInfo.EndCursor == "" || pageInfo.EndCursor == it.after {
+		it.done = true
+	}
+	it.after = pageInfo.EndCursor
+	return nodes, true
+}
+
+// Err returns the error which stopped the iteration
+func (it *PageIterator[N]) Err() error {
+	return it.err
+}
+
+// Truncated reports whether further pages exist which were not loaded because of the budget
+func (it *PageIterator[N]) Truncated() bool {
+	return it.truncated
+}
+
+// Pages returns the number of loaded pages
+func (it *PageIterator[N]) Pages() int {
+	return it.pages
+}
+
+// CollectPages loads the nodes of all pages within the budget. The returned PageInfo describes the
+// combined list, HasNextPage and EndCursor allow to continue after a truncated list.
+func CollectPages[N any](ctx context.Context, fetch PageFetcher[N], budget int) ([]N, PageInfo, error) {
+	it := NewPageIterator(fetch, budget)
+	var all []N
+	for {
+		nodes, ok := it.Next(ctx)
+		if !ok {
+			break
+		}
+		all = append(all, nodes...)
+	}
+	pageInfo := PageInfo{HasNextPage: it.Truncated()}
+	if it.Truncated() {
+		pageInfo.EndCursor = it.after
+	}
+	return all, pageInfo, it.Err()
+}
+
+// GraphQLPages returns a PageFetcher for a query with the variables $first and $after, page
+// returns the connection of the answer
+func GraphQLPages[T any, N any](endpoint, query, token string, vars map[string]interface{}, page func(*T) ([]N, PageInfo), islog bool) PageFetcher[N] {
+	return func(ctx context.Context, after string) ([]N, PageInfo, error) {
+		pageVars := maps.Clone(vars)
+		pageVars["first"] = MaxPageSize
+		if after != "" {
+			pageVars["after"] = after
+		} else {
+			delete(pageVars, "after")
+		}
+		data, err := SendGraphQLQuery[T](ctx, endpoint, query, token, pageVars, islog)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		nodes, pageInfo := page(data)
+		return nodes, pageInfo, nil
+	}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// numberPages returns pages of three numbers, the cursor is the number of the next page
+func numberPages(total int, calls *int) PageFetcher[int] {
+	return func(_ context.Context, after string) ([]int, PageInfo, error) {
+		*calls++
+		page, _ := strconv.Atoi(after)
+		var nodes []int
+		for i := page * 3; i < total && i < page*3+3; i++ {
+			nodes = append(nodes, i)
+		}
+		return nodes, PageInfo{HasNextPage: (page+1)*3 < total, EndCursor: strconv.Itoa(page + 1)}, nil
+	}
+}
+
+func TestCollectPages(t *testing.T) {
+	tests := []struct {
+		name          string
+		total         int
+		budget        int
+		wantNodes     int
+		wantCalls     int
+		wantTruncated bool
+	}{
+		{name: "single page", total: 2, budget: 5, wantNodes: 2, wantCalls: 1},
+		{name: "all pages", total: 10, budget: 5, wantNodes: 10, wantCalls: 4},
+		{name: "budget", total: 10, budget: 2, wantNodes: 6, wantCalls: 2, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			nodes, pageInfo, err := CollectPages(context.Background(), numberPages(tt.total, &calls), tt.budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) != tt.wantNodes || calls != tt.wantCalls || pageInfo.HasNextPage != tt.wantTruncated {
+				t.Errorf("nodes = %d, calls = %d, truncated = %v, want %d, %d, %v",
+					len(nodes), calls, pageInfo.HasNextPage, tt.wantNodes, tt.wantCalls, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestCollectPagesStopsOnError(t *testing.T) {
+	failure := errors.New("upstream failed")
+	calls := 0
+	fetch := func(ctx context.Context, after string) ([]int, PageInfo, error) {
+		calls++
+		if after != "" {
+			return nil, PageInfo{}, failure
+		}
+		return []int{1}, PageInfo{HasNextPage: true, EndCursor: "next"}, nil
+	}
+	if _, _, err := CollectPages(context.Background(), fetch, 5); !errors.Is(err, failure) || calls != 2 {
+		t.Errorf("err = %v, calls = %d, want %v after 2 calls", err, calls, failure)
+	}
+}
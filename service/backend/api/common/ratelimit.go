@@ -0,0 +1,298 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// The rate limit of a token is tracked from the X-RateLimit-* headers of the answers. GitHub has
+// separate budgets per resource, "core" for REST and "graphql" for the points of GraphQL queries.
+// The state is shared with other instances of the backend through Redis.
+
+const (
+	RateLimitREST    = "core"
+	RateLimitGraphQL = "graphql"
+)
+
+type RateLimitState struct {
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Used      int       `json:"used"`
+	Reset     time.Time `json:"reset"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// exhausted reports whether no request is possible until the reset
+func (s RateLimitState) exhausted() bool {
+	return s.Remaining <= 0 && time.Now().Before(s.Reset)
+}
+
+// RateLimitError is returned instead of sending a request when the budget is used up
+type RateLimitError struct {
+	Resource string
+	Reset    time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of %s exhausted until %s", e.Resource, e.Reset.Format(time.RFC3339))
+}
+
+// ErrThrottled is returned for background requests when the budget is below the reserve
+var ErrThrottled = errors.New("background request throttled, the rate limit is reserved for interactive requests")
+
+type contextKey string
+
+const (
+	connectionKey contextKey = "connection"
+	backgroundKey contextKey = "background"
+	operationKey  contextKey = "operation"
+)
+
+// WithConnection labels the upstream requests of ctx with a connection for the metrics
+func WithConnection(ctx context.Context, connection string) context.Context {
+	return context.WithValue(ctx, connectionKey, connection)
+}
+
+// WithOperation labels the upstream requests of ctx with an operation for the metrics, e.g. the
+// prefix of the cache key of the answer
+func WithOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey, operation)
+}
+
+// WithBackground marks the upstream requests of ctx as non-interactive, they are throttled first
+func WithBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey, true)
+}
+
+func connectionOf(ctx context.Context) string {
+	if connection, ok := ctx.Value(connectionKey).(string); ok {
+		return connection
+	}
+	return "unknown"
+}
+
+func operationOf(ctx context.Context) string {
+	if operation, ok := ctx.Value(operationKey).(string); ok {
+		return operation
+	}
+	return "unknown"
+}
+
+func isBackground(ctx context.Context) bool {
+	background, _ := ctx.Value(backgroundKey).(bool)
+	return background
+}
+
+var (
+	rateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "githubclone_upstream_ratelimit_remaining",
+		Help: "Remaining rate limit budget of the last used token per connection and resource",
+	}, []string{"connection", "resource"})
+	rateLimitLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "githubclone_upstream_ratelimit_limit",
+		Help: "Rate limit budget per hour of the last used token per connection and resource",
+	}, []string{"connection", "resource"})
+	rateLimitReset = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "githubclone_upstream_ratelimit_reset_timestamp_seconds",
+		Help: "Time of the next reset of the rate limit as unix timestamp",
+	}, []string{"connection", "resource"})
+	rateLimitRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_upstream_ratelimit_rejected_total",
+		Help: "Upstream requests which were not sent because of the rate limit",
+	}, []string{"connection", "resource", "reason"})
+)
+
+// backgroundReserve is the share of the budget in percent which is kept for interactive requests,
+// it can be set with RATELIMIT_BACKGROUND_RESERVE
+var backgroundReserve = loadBackgroundReserve()
+
+func loadBackgroundReserve() int {
+	reserve := 20
+	if value := os.Getenv("RATELIMIT_BACKGROUND_RESERVE"); value != "" {
+		if percent, err := strconv.Atoi(value); err == nil && percent >= 0 && percent <= 100 {
+			reserve = percent
+		} else {
+			log.Printf("Invalid RATELIMIT_BACKGROUND_RESERVE %q, using %d", value, reserve)
+		}
+	}
+	return reserve
+}
+
+var (
+	rateLimits      = make(map[string]localRateLimit) // Local copy of the states
+	rateLimitsMutex sync.RWMutex
+	rateLimitRedis  *redis.Client
+)
+
+// rateLimitLocalTTL is how long the local copy of a state is used before Redis is read again, the
+// other instances record their requests there
+const rateLimitLocalTTL = 5 * time.Second
+
+// localRateLimit is the local copy of a state with the time it was stored or read from Redis
+type localRateLimit struct {
+	state    RateLimitState
+	loadedAt time.Time
+}
+
+// rateLimitsSwept is the time of the last removal of the expired local states
+var rateLimitsSwept time.Time
+
+// InitRateLimits shares the rate limit states through Redis, without it they are kept in memory
+func InitRateLimits(client *redis.Client) {
+	rateLimitRedis = client
+}
+
+// TokenKey identifies a token in keys and logs without revealing it
+func TokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+func rateLimitKey(token, resource string) string {
+	return "ratelimit:" + TokenKey(token) + ":" + resource
+}
+
+func loadRateLimit(ctx context.Context, token, resource string) (RateLimitState, bool) {
+	key := rateLimitKey(token, resource)
+	rateLimitsMutex.RLock()
+	local, ok := rateLimits[key]
+	rateLimitsMutex.RUnlock()
+	if rateLimitRedis == nil || ok && time.Since(local.loadedAt) < rateLimitLocalTTL {
+		return local.state, ok
+	}
+	data, err := rateLimitRedis.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		forgetRateLimit(key)
+		return RateLimitState{}, false
+	} else if err != nil {
+		return local.state, ok // Redis is not available, the local copy is better than nothing
+	}
+	var state RateLimitState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return local.state, ok
+	}
+	storeRateLimit(key, state)
+	return state, true
+}
+
+// storeRateLimit keeps the local copy of a state, expired states are removed once per minute
+func storeRateLimit(key string, state RateLimitState) {
+	now := time.Now()
+	rateLimitsMutex.Lock()
+	defer rateLimitsMutex.Unlock()
+	rateLimits[key] = localRateLimit{state: state, loadedAt: now}
+	if now.Sub(rateLimitsSwept) < time.Minute {
+		return
+	}
+	rateLimitsSwept = now
+	for k, local := range rateLimits {
+		if now.After(local.state.Reset.Add(time.Minute)) {
+			delete(rateLimits, k)
+		}
+	}
+}
+
+func forgetRateLimit(key string) {
+	rateLimitsMutex.Lock()
+	delete(rateLimits, key)
+	rateLimitsMutex.Unlock()
+}
+
+func saveRateLimit(ctx context.Context, token string, state RateLimitState) {
+	key := rateLimitKey(token, state.Resource)
+	storeRateLimit(key, state)
+
+	connection := connectionOf(ctx)
+	rateLimitRemaining.WithLabelValues(connection, state.Resource).Set(float64(state.Remaining))
+	rateLimitLimit.WithLabelValues(connection, state.Resource).Set(float64(state.Limit))
+	rateLimitReset.WithLabelValues(connection, state.Resource).Set(float64(state.Reset.Unix()))
+
+	if rateLimitRedis == nil {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	// The state is worthless after the reset
+	ttl := time.Until(state.Reset) + time.Minute
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	if err := rateLimitRedis.Set(context.WithoutCancel(ctx), key, data, ttl).Err(); err != nil {
+		log.Printf("Rate limit state cannot be stored: %v", err)
+	}
+}
+
+// RateLimitStates returns the known states of a token per resource
+func RateLimitStates(ctx context.Context, token string) map[string]RateLimitState {
+	states := make(map[string]RateLimitState)
+	for _, resource := range []string{RateLimitREST, RateLimitGraphQL} {
+		if state, ok := loadRateLimit(ctx, token, resource); ok {
+			states[resource] = state
+		}
+	}
+	return states
+}
+
+// checkRateLimit is called before a request is sent. It fails if the budget is exhausted and
+// throttles background requests when the budget is below the reserve.
+func checkRateLimit(ctx context.Context, token, resource string) error {
+	state, ok := loadRateLimit(ctx, token, resource)
+	if !ok || !time.Now().Before(state.Reset) {
+		return nil
+	}
+	if state.exhausted() {
+		rateLimitRejected.WithLabelValues(connectionOf(ctx), resource, "exhausted").Inc()
+		return &RateLimitError{Resource: resource, Reset: state.Reset}
+	}
+	if isBackground(ctx) && state.Limit > 0 && state.Remaining*100 < state.Limit*backgroundReserve {
+		rateLimitRejected.WithLabelValues(connectionOf(ctx), resource, "throttled").Inc()
+		return ErrThrottled
+	}
+	return nil
+}
+
+// recordRateLimit stores the rate limit headers of a response. It returns a RateLimitError if
+// the response was rejected because of the exhausted budget.
+func recordRateLimit(ctx context.Context, token, resource string, resp *http.Response) error {
+	limit, err1 := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil // The provider does not send rate limits, e.g. Gitea
+	}
+	if header := resp.Header.Get("X-RateLimit-Resource"); header != "" {
+		resource = header
+	}
+	used, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Used"))
+	state := RateLimitState{
+		Resource:  resource,
+		Limit:     limit,
+		Remaining: remaining,
+		Used:      used,
+		Reset:     time.Unix(reset, 0),
+		UpdatedAt: time.Now(),
+	}
+	saveRateLimit(ctx, token, state)
+
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && remaining == 0 {
+		return &RateLimitError{Resource: resource, Reset: state.Reset}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func rateLimitResponse(status, limit, remaining int, reset time.Time) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	resp.Header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	resp.Header.Set("X-RateLimit-Resource", RateLimitGraphQL)
+	return resp
+}
+
+func TestRateLimitTracking(t *testing.T) {
+	ctx := context.Background()
+	token := "test-token-" + t.Name()
+	reset := time.Now().Add(time.Hour)
+
+	if err := checkRateLimit(ctx, token, RateLimitGraphQL); err != nil {
+		t.Fatalf("unknown token must not be limited: %v", err)
+	}
+
+	// Below the reserve only interactive requests are sent
+	if err := recordRateLimit(ctx, token, RateLimitREST, rateLimitResponse(http.StatusOK, 5000, 100, reset)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := checkRateLimit(ctx, token, RateLimitGraphQL); err != nil {
+		t.Errorf("interactive request must be sent: %v", err)
+	}
+	if err := checkRateLimit(WithBackground(ctx), token, RateLimitGraphQL); !errors.Is(err, ErrThrottled) {
+		t.Errorf("background request must be throttled, got %v", err)
+	}
+	if err := checkRateLimit(WithBackground(ctx), token, RateLimitREST); err != nil {
+		t.Errorf("the REST budget is separate: %v", err)
+	}
+
+	// An exhausted budget fails fast until the reset
+	err := recordRateLimit(ctx, token, RateLimitREST, rateLimitResponse(http.StatusForbidden, 5000, 0, reset))
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.Reset.Unix() != reset.Unix() {
+		t.Fatalf("expected RateLimitError with the reset, got %v", err)
+	}
+	if err := checkRateLimit(ctx, token, RateLimitGraphQL); !errors.As(err, &rateLimitErr) {
+		t.Errorf("expected RateLimitError before sending, got %v", err)
+	}
+
+	// After the reset the old state does not matter
+	recordRateLimit(ctx, token, RateLimitREST, rateLimitResponse(http.StatusForbidden, 5000, 0, time.Now().Add(-time.Second)))
+	if err := checkRateLimit(ctx, token, RateLimitGraphQL); err != nil {
+		t.Errorf("request after the reset must be sent: %v", err)
+	}
+}
+
+// Local states are dropped after their reset, the map does not grow with every token
+func TestRateLimitEviction(t *testing.T) {
+	ctx := context.Background()
+	expired := "expired-token-" + t.Name()
+	saveRateLimit(ctx, expired, RateLimitState{Resource: RateLimitREST, Limit: 5000, Reset: time.Now().Add(-2 * time.Minute)})
+
+	rateLimitsMutex.Lock()
+	rateLimitsSwept = time.Time{}
+	rateLimitsMutex.Unlock()
+	saveRateLimit(ctx, "current-token-"+t.Name(), RateLimitState{Resource: RateLimitREST, Limit: 5000, Reset: time.Now().Add(time.Hour)})
+
+	rateLimitsMutex.RLock()
+	_, found := rateLimits[rateLimitKey(expired, RateLimitREST)]
+	rateLimitsMutex.RUnlock()
+	if found {
+		t.Error("the expired state is still kept")
+	}
+}
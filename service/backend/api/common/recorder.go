@@ -0,0 +1,215 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The Recorder makes tests of the upstream code paths deterministic. In record mode the requests
+// are sent to the providers and the pairs of request and response are saved to a fixture file,
+// in replay mode the responses are served from the file without network. Tokens never reach the
+// file: the request headers are not recorded and the secrets are replaced in all stored texts.
+
+type RecordMode int
+
+const (
+	ModeReplay RecordMode = iota
+	ModeRecord
+)
+
+const redacted = "REDACTED"
+
+// RecordedMessage is a request or a response of a fixture, a JSON body is kept readable in JSON
+type RecordedMessage struct {
+	Method string            `json:"method,omitempty"`
+	URL    string            `json:"url,omitempty"`
+	Status int               `json:"status,omitempty"`
+	Header map[string]string `json:"header,omitempty"`
+	JSON   json.RawMessage   `json:"json,omitempty"`
+	Text   string            `json:"text,omitempty"`
+}
+
+func (m *RecordedMessage) setBody(body []byte) {
+	var compact bytes.Buffer
+	if len(body) > 0 && json.Compact(&compact, body) == nil {
+		m.JSON = compact.Bytes()
+	} else {
+		m.Text = string(body)
+	}
+}
+
+func (m *RecordedMessage) body() []byte {
+	if m.JSON != nil {
+		return m.JSON
+	}
+	return []byte(m.Text)
+}
+
+type Interaction struct {
+	Request  RecordedMessage `json:"request"`
+	Response RecordedMessage `json:"response"`
+}
+
+// Recorder is a http.RoundTripper, it's injected with SetUpstreamTransport
+type Recorder struct {
+	Next         http.RoundTripper // Sends the requests in record mode
+	path         string
+	mode         RecordMode
+	secrets      []string
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder loads the fixture file in replay mode. The secrets are removed from the recorded
+// requests and responses, the requests are sent with the secrets and matched without them.
+func NewRecorder(path string, mode RecordMode, secrets ...string) (*Recorder, error) {
+	r := &Recorder{Next: newTransport(), path: path, mode: mode}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil // A test without upstream requests needs no fixture
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fixture cannot be read: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, fmt.Errorf("fixture %s is invalid: %w", path, err)
+	}
+	return r, nil
+}
+
+func (r *Recorder) scrub(text string) string {
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// recordRequest returns the request as it's stored, the body of req stays readable
+func (r *Recorder) recordRequest(req *http.Request) (RecordedMessage, error) {
+	message := RecordedMessage{Method: req.Method, URL: r.scrub(req.URL.String())}
+	if req.Body == nil || req.Body == http.NoBody {
+		return message, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return message, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	message.setBody([]byte(r.scrub(string(body))))
+	return message, nil
+}
+
+// sameRequest compares JSON bodies by value, the fixtures are indented
+func sameRequest(a, b RecordedMessage) bool {
+	if a.Method != b.Method || a.URL != b.URL {
+		return false
+	}
+	if a.JSON == nil || b.JSON == nil {
+		return bytes.Equal(a.body(), b.body())
+	}
+	var bodyA, bodyB interface{}
+	if json.Unmarshal(a.JSON, &bodyA) != nil || json.Unmarshal(b.JSON, &bodyB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(bodyA, bodyB)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := r.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == ModeReplay {
+		return r.replay(req, request)
+	}
+
+	resp, err := r.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	response := RecordedMessage{Status: resp.StatusCode, Header: make(map[string]string)}
+	for name := range resp.Header {
+		if name != "Set-Cookie" {
+			response.Header[name] = r.scrub(resp.Header.Get(name))
+		}
+	}
+	response.setBody([]byte(r.scrub(string(body))))
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{Request: request, Response: response})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, request RecordedMessage) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, interaction := range r.interactions {
+		if !sameRequest(interaction.Request, request) {
+			continue
+		}
+		recorded := interaction.Response
+		resp := &http.Response{
+			StatusCode:    recorded.Status,
+			Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          io.NopCloser(bytes.NewReader(recorded.body())),
+			ContentLength: int64(len(recorded.body())),
+			Request:       req,
+		}
+		for name, value := range recorded.Header {
+			resp.Header.Set(name, value)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no recorded interaction in %s for %s %s", r.path, request.Method, request.URL)
+}
+
+// Save writes the recorded interactions, it does nothing in replay mode or without interactions
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	if r.mode != ModeRecord || len(r.interactions) == 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(r.interactions)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data.Bytes(), 0o644)
+}
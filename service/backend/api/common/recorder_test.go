@@ -0,0 +1,63 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorderScrubsAndReplays(t *testing.T) {
+	const token = "secret-token"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"name":"main","token":"` + token + `"}`))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	type answer struct {
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	recorder, err := NewRecorder(path, ModeRecord, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetUpstreamTransport(recorder)
+	defer SetUpstreamTransport(nil)
+	if _, err := SendRestAPIQuery[answer](context.Background(), server.URL, "branch", token, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Errorf("fixture contains the token: %s", data)
+	}
+
+	// The server is gone, the answer comes from the fixture
+	server.Close()
+	replay, err := NewRecorder(path, ModeReplay, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetUpstreamTransport(replay)
+	result, err := SendRestAPIQuery[answer](context.Background(), server.URL, "branch", token, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Result.Name != "main" || result.Result.Token != redacted {
+		t.Errorf("replayed %+v, want main with a redacted token", *result.Result)
+	}
+	if _, err := SendRestAPIQuery[answer](context.Background(), server.URL, "other", token, false); err == nil {
+		t.Error("request without recording was answered")
+	}
+}
@@ -1,12 +1,12 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -17,20 +17,30 @@ type RestAPIResult[T any] struct {
 }
 
 // SendRestAPIQuery executes a REST GET call
-func SendRestAPIQuery[T any](endpoint, path, token string, islog bool) (*RestAPIResult[T], error) {
+func SendRestAPIQuery[T any](ctx context.Context, endpoint, path, token string, islog bool) (*RestAPIResult[T], error) {
 	url := strings.TrimRight(endpoint, "/") + "/" + strings.TrimLeft(path, "/")
 
 	if islog {
 		fmt.Printf("GET %s\n", url)
 	}
 
-	req, _ := http.NewRequest("GET", url, nil)
+	if err := checkRateLimit(ctx, token, RateLimitREST); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 	if token != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	entry := prepareRevalidation(req, token)
+
+	start := time.Now()
+	resp, err := DoUpstream(ctx, req, true)
+	observeUpstream(ctx, apiREST, start)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -40,13 +50,24 @@ func SendRestAPIQuery[T any](endpoint, path, token string, islog bool) (*RestAPI
 	if err != nil {
 		return nil, fmt.Errorf("reading body failed: %w", err)
 	}
-	limit := resp.Header.Get("X-RateLimit-Limit")
-	remaining := resp.Header.Get("X-RateLimit-Remaining")
-	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
-
-	log.Printf("GitHub Rate Limit: %s remaining of %s – resets at %s", remaining, limit, time.Unix(reset, 0).Local())
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		if islog {
+			log.Printf("Not modified: %s", url)
+		}
+		body = revalidated(resp, entry)
+	} else if resp.StatusCode == http.StatusOK {
+		storeRevalidation(req, token, resp, body)
+	}
+	if err := recordRateLimit(ctx, token, RateLimitREST, resp); err != nil {
+		return nil, err
+	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("GitHub API error %d: %s", resp.StatusCode, string(body))
+		return nil, &UpstreamError{
+			Status:      upstreamStatus(resp.StatusCode),
+			Upstream:    resp.StatusCode,
+			Message:     fmt.Sprintf("GitHub API error %d: %s", resp.StatusCode, string(body)),
+			RateLimited: secondaryRateLimited(resp, body),
+		}
 	}
 
 	var payload T
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"log"
+	"net/http"
+)
+
+// GitHub does not count answers with 304 Not Modified against the rate limit. The ETag and
+// Last-Modified of a REST answer are stored together with the body per URL and token, the next
+// request for the URL is sent conditionally and the stored body is reused on 304.
+
+// maxRevalidationBody limits the size of the bodies which are kept for revalidation
+const maxRevalidationBody = 5 << 20
+
+// revalidationHeaders are the headers of the answer the callers evaluate, e.g. for pagination
+var revalidationHeaders = []string{"Link", "X-Total-Count"}
+
+type RevalidationEntry struct {
+	ETag         string            `json:"etag"`
+	LastModified string            `json:"lastModified"`
+	Header       map[string]string `json:"header"`
+	Body         []byte            `json:"body"`
+}
+
+// RevalidationStore keeps the entries, it's implemented by cache.TypedCache
+type RevalidationStore interface {
+	Get(key string) (*RevalidationEntry, bool, error)
+	Set(key string, val RevalidationEntry) error
+}
+
+var revalidationStore RevalidationStore
+
+// InitRevalidation enables conditional requests, without a store every request is a full GET
+func InitRevalidation(store RevalidationStore) {
+	revalidationStore = store
+}
+
+func revalidationKey(url, token string) string {
+	return TokenKey(token) + ":" + url
+}
+
+// prepareRevalidation adds the conditional headers to req and returns the stored entry
+func prepareRevalidation(req *http.Request, token string) *RevalidationEntry {
+	if revalidationStore == nil {
+		return nil
+	}
+	entry, found, err := revalidationStore.Get(revalidationKey(req.URL.String(), token))
+	if err != nil {
+		log.Printf("cache read error: %v", err)
+	}
+	if !found || entry == nil {
+		return nil
+	}
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+	return entry
+}
+
+// revalidated turns a 304 answer into the stored answer, the stored headers are restored
+func revalidated(resp *http.Response, entry *RevalidationEntry) []byte {
+	resp.StatusCode = http.StatusOK
+	for name, value := range entry.Header {
+		resp.Header.Set(name, value)
+	}
+	return entry.Body
+}
+
+// storeRevalidation keeps a successful answer for the next conditional request
+func storeRevalidation(req *http.Request, token string, resp *http.Response, body []byte) {
+	if revalidationStore == nil || len(body) > maxRevalidationBody {
+		return
+	}
+	entry := RevalidationEntry{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Header:       make(map[string]string),
+		Body:         body,
+	}
+	if entry.ETag == "" && entry.LastModified == "" {
+		return
+	}
+	for _, name := range revalidationHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			entry.Header[name] = value
+		}
+	}
+	if err := revalidationStore.Set(revalidationKey(req.URL.String(), token), entry); err != nil {
+		log.Printf("cache write error: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+type memoryRevalidationStore map[string]RevalidationEntry
+
+func (s memoryRevalidationStore) Get(key string) (*RevalidationEntry, bool, error) {
+	entry, ok := s[key]
+	return &entry, ok, nil
+}
+
+func (s memoryRevalidationStore) Set(key string, val RevalidationEntry) error {
+	s[key] = val
+	return nil
+}
+
+func TestSendRestAPIQueryRevalidates(t *testing.T) {
+	InitRevalidation(memoryRevalidationStore{})
+	t.Cleanup(func() { InitRevalidation(nil) })
+
+	var full, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Link", `<https://example.com/?page=2>; rel="next"`)
+		w.Write([]byte(`{"name":"main"}`))
+	}))
+	defer server.Close()
+
+	type branch struct {
+		Name string `json:"name"`
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		result, err := SendRestAPIQuery[branch](ctx, server.URL, "/branches/main", "token", false)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if result.Result.Name != "main" {
+			t.Errorf("request %d: name = %q, want main", i, result.Result.Name)
+		}
+		if result.Resp.Header.Get("Link") == "" {
+			t.Errorf("request %d: Link header is missing", i)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("full = %d, not modified = %d, want 1 and 1", full, notModified)
+	}
+
+	// Another token must not revalidate the stored answer
+	if _, err := SendRestAPIQuery[branch](ctx, server.URL, "/branches/main", "other", false); err != nil {
+		t.Fatal(err)
+	}
+	if full != 2 {
+		t.Errorf("full = %d after another token, want 2", full)
+	}
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxInspectedBody limits how much of an error body is read to detect a secondary rate limit
+const maxInspectedBody = 64 * 1024
+
+// The APIs of the upstream requests for the metrics
+const (
+	apiGraphQL = "graphql"
+	apiREST    = "rest"
+)
+
+var upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "githubclone_upstream_request_duration_seconds",
+	Help:    "Duration of the upstream requests including retries, per API, operation and connection",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+}, []string{"api", "operation", "connection"})
+
+// observeUpstream records the duration of an upstream request which started at start
+func observeUpstream(ctx context.Context, api string, start time.Time) {
+	upstreamDuration.WithLabelValues(api, operationOf(ctx), connectionOf(ctx)).Observe(time.Since(start).Seconds())
+}
+
+// parseRetryAfter reads the Retry-After header, which is either seconds or an HTTP date
+func parseRetryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// backoff returns the jittered exponential delay before retry number attempt (starting at 0)
+func backoff(attempt int) time.Duration {
+	delay := upstream.BaseBackoff << attempt
+	if delay > upstream.MaxBackoff || delay <= 0 {
+		delay = upstream.MaxBackoff
+	}
+	// Half of the delay is fixed, the other half is random
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int64N(half+1))
+}
+
+// isSecondaryRateLimit detects the secondary rate limit of GitHub. It's answered with 403 or 429
+// and either a Retry-After header or a message in the body. The body is only read if the headers
+// don't decide and is restored for the caller.
+func isSecondaryRateLimit(resp *http.Response) bool {
+	limited := resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests
+	if !limited || resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return secondaryRateLimited(resp, nil)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxInspectedBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return secondaryRateLimited(resp, body)
+}
+
+// secondaryRateLimited is isSecondaryRateLimit for a response whose body was already read
+func secondaryRateLimited(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	if resp.Header.Get("Retry-After") != "" {
+		return true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return false // The primary rate limit, waiting for its reset takes too long
+	}
+	return strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
+
+// retryDelay decides if a response is retried and how long to wait before
+func retryDelay(resp *http.Response, attempt int) (time.Duration, bool) {
+	switch {
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable:
+	case isSecondaryRateLimit(resp):
+	default:
+		return 0, false
+	}
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		return delay, delay <= upstream.MaxRetryAfter
+	}
+	return backoff(attempt), true
+}
+
+// DoUpstream sends a request to a provider with the shared client of its host. The request is
+// bound to ctx, so it's cancelled together with the request of the browser. Idempotent requests
+// are retried on 502, 503 and the secondary rate limit, honoring Retry-After. While the circuit
+// breaker of the host is open, a CircuitOpenError is returned without sending the request.
+func DoUpstream(ctx context.Context, req *http.Request, idempotent bool) (*http.Response, error) {
+	release, err := acquireBreaker(ctx, HostOf(req.URL.String()))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doWithRetries(ctx, req, idempotent)
+	release(breakerOutcome(ctx, resp, err))
+	return resp, err
+}
+
+func doWithRetries(ctx context.Context, req *http.Request, idempotent bool) (*http.Response, error) {
+	client := HTTPClientFor(req.URL.String())
+	for attempt := 0; ; attempt++ {
+		current := req.WithContext(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			current.Body = body
+		}
+
+		resp, err := client.Do(current)
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		if err != nil || !idempotent || !replayable || attempt >= upstream.MaxRetries {
+			return resp, err
+		}
+		delay, retry := retryDelay(resp, attempt)
+		if !retry {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxInspectedBody))
+		resp.Body.Close()
+		log.Printf("Upstream %s %s answered %d, retry %d/%d in %v", req.Method, req.URL.Host, resp.StatusCode, attempt+1, upstream.MaxRetries, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetries shortens the backoff for the duration of a test
+func fastRetries(t *testing.T) {
+	saved := upstream
+	upstream.BaseBackoff = time.Millisecond
+	upstream.MaxBackoff = 5 * time.Millisecond
+	upstream.MaxRetries = 3
+	t.Cleanup(func() { upstream = saved })
+}
+
+func TestDoUpstreamRetries(t *testing.T) {
+	fastRetries(t)
+	tests := []struct {
+		name       string
+		responses  []func(w http.ResponseWriter)
+		idempotent bool
+		wantStatus int
+		wantCalls  int32
+	}{
+		{
+			name: "503 then success",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			idempotent: true,
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name: "secondary rate limit in body",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"message":"You have exceeded a secondary rate limit"}`))
+				},
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			idempotent: true,
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name: "forbidden is not retried",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"message":"Resource not accessible"}`))
+				},
+			},
+			idempotent: true,
+			wantStatus: http.StatusForbidden,
+			wantCalls:  1,
+		},
+		{
+			name: "not idempotent",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			idempotent: false,
+			wantStatus: http.StatusBadGateway,
+			wantCalls:  1,
+		},
+		{
+			name: "gives up after the retries",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			idempotent: true,
+			wantStatus: http.StatusBadGateway,
+			wantCalls:  4,
+		},
+		{
+			name: "Retry-After above the maximum",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "3600")
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+			},
+			idempotent: true,
+			wantStatus: http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				index := int(calls.Add(1)) - 1
+				if index >= len(tt.responses) {
+					index = len(tt.responses) - 1
+				}
+				tt.responses[index](w)
+			}))
+			defer server.Close()
+
+			req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"query":"{ viewer { login } }"}`))
+			resp, err := DoUpstream(context.Background(), req, tt.idempotent)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoUpstreamHonorsRetryAfterAndContext(t *testing.T) {
+	fastRetries(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	start := time.Now()
+	_, err := DoUpstream(ctx, req, true)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline of the context, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("the wait was not cancelled by the context")
+	}
+}
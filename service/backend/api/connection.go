@@ -3,9 +3,12 @@ package api
 import (
 	"errors"
 	"fmt"
+	"githubclone-backend/api/common"
 	"githubclone-backend/db"
 	"githubclone-backend/models"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,12 +17,17 @@ import (
 )
 
 type updateconnectionType struct {
-	ID           uint    `json:"userid"`
-	Type         *string `json:"type"`
-	URL          *string `json:"url"`
-	ClientID     *string `json:"clientid"`
-	ClientSecret *string `json:"clientsecret"`
-	Description  *string `json:"description"`
+	ID            uint    `json:"userid"`
+	Type          *string `json:"type"`
+	URL           *string `json:"url"`
+	CACertificate *string `json:"cacertificate"`
+	ProxyURL      *string `json:"proxyurl"`
+	ClientCert    *string `json:"clientcertificate"`
+	ClientKey     *string `json:"clientkey"`
+	InsecureTLS   *bool   `json:"insecureskipverify"`
+	ClientID      *string `json:"clientid"`
+	ClientSecret  *string `json:"clientsecret"`
+	Description   *string `json:"description"`
 }
 
 type connectionType struct {
@@ -35,6 +43,11 @@ type ConnectionInput struct {
 	ConnectionName string  `json:"name" binding:"required"`
 	Type           string  `json:"type" binding:"required"`
 	URL            *string `json:"url"`
+	CACertificate  *string `json:"cacertificate"`
+	ProxyURL       *string `json:"proxyurl"`
+	ClientCert     *string `json:"clientcertificate"`
+	ClientKey      *string `json:"clientkey"`
+	InsecureTLS    bool    `json:"insecureskipverify"`
 	ClientID       string  `json:"clientid" binding:"required"`
 	ClientSecret   string  `json:"clientsecret" binding:"required"`
 	Deactivated    bool    `json:"deactivated"`
@@ -44,14 +57,20 @@ type ConnectionInput struct {
 func convertToConnection(input ConnectionInput) models.Connection {
 	tnow := time.Now()
 	connection := models.Connection{
-		ConnectionName: input.ConnectionName,
-		Type:           input.Type,
-		CreatedAt:      tnow,
-		UpdatedAt:      tnow,
-		Description:    input.Description,
-		ClientID:       input.ClientID,
-		ClientSecret:   input.ClientSecret,
-		Deactivated:    input.Deactivated,
+		ConnectionName:     input.ConnectionName,
+		Type:               input.Type,
+		URL:                input.URL,
+		CACertificate:      input.CACertificate,
+		ProxyURL:           input.ProxyURL,
+		ClientCertificate:  input.ClientCert,
+		ClientKey:          input.ClientKey,
+		InsecureSkipVerify: input.InsecureTLS,
+		CreatedAt:          tnow,
+		UpdatedAt:          tnow,
+		Description:        input.Description,
+		ClientID:           input.ClientID,
+		ClientSecret:       input.ClientSecret,
+		Deactivated:        input.Deactivated,
 	}
 	return connection
 }
@@ -64,6 +83,10 @@ func CreateConnection(c *gin.Context) {
 	}
 
 	connection := convertToConnection(connectionInput)
+	if _, err := common.NewTransportWithOptions(transportOptions(connection)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	err := db.DB.Transaction(func(tx *gorm.DB) error {
 		var existing models.Connection
@@ -115,6 +138,24 @@ func UpdateConnection(c *gin.Context) {
 		if connectionInput.URL != nil {
 			connection.URL = connectionInput.URL
 		}
+		if connectionInput.CACertificate != nil {
+			connection.CACertificate = connectionInput.CACertificate
+		}
+		if connectionInput.ProxyURL != nil {
+			connection.ProxyURL = connectionInput.ProxyURL
+		}
+		if connectionInput.ClientCert != nil {
+			connection.ClientCertificate = connectionInput.ClientCert
+		}
+		if connectionInput.ClientKey != nil {
+			connection.ClientKey = connectionInput.ClientKey
+		}
+		if connectionInput.InsecureTLS != nil {
+			connection.InsecureSkipVerify = *connectionInput.InsecureTLS
+		}
+		if _, err := common.NewTransportWithOptions(transportOptions(connection)); err != nil {
+			return fmt.Errorf("invalid input: %v", err)
+		}
 		if connectionInput.ClientID != nil {
 			connection.ClientID = *connectionInput.ClientID
 		}
@@ -128,9 +169,17 @@ func UpdateConnection(c *gin.Context) {
 		if err := tx.Save(&connection).Error; err != nil {
 			return fmt.Errorf("failed to update connection: %v", err)
 		}
+		// Sessions of the connection use the new network settings right away
+		if err := configureTransport(connection); err != nil {
+			log.Printf("Could not apply the network settings of connection %d: %v", connection.ID, err)
+		}
 		connectionInputNew.ID = connection.ID
 		connectionInputNew.Type = &connection.Type
 		connectionInputNew.URL = connection.URL
+		connectionInputNew.CACertificate = connection.CACertificate
+		connectionInputNew.ProxyURL = connection.ProxyURL
+		connectionInputNew.ClientCert = connection.ClientCertificate
+		connectionInputNew.InsecureTLS = &connection.InsecureSkipVerify
 		connectionInputNew.ClientID = &connection.ClientID
 		connectionInputNew.ClientSecret = &connection.ClientSecret
 		connectionInputNew.Description = &connection.Description
@@ -241,6 +290,46 @@ func GetUsersForConnection(c *gin.Context) {
 
 }
 
+// transportOptions returns the network settings of a connection
+func transportOptions(connection models.Connection) common.TransportOptions {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return strings.TrimSpace(*s)
+	}
+	return common.TransportOptions{
+		ProxyURL:           value(connection.ProxyURL),
+		CAPEM:              value(connection.CACertificate),
+		ClientCertPEM:      value(connection.ClientCertificate),
+		ClientKeyPEM:       value(connection.ClientKey),
+		InsecureSkipVerify: connection.InsecureSkipVerify,
+	}
+}
+
+// configureTransport applies the network settings of a connection to the hosts of its
+// web interface and api, GitHub Enterprise serves the api on the host api.<url> as well.
+func configureTransport(connection models.Connection) error {
+	var urls []string
+	switch OAuthProvider(connection.Type) {
+	case Local:
+		return nil
+	case Github:
+		urls = []string{"github.com", "api.github.com"}
+	case Gitlab:
+		urls = []string{"gitlab.com"}
+	default:
+		if connection.URL == nil || *connection.URL == "" {
+			return nil
+		}
+		urls = []string{*connection.URL}
+		if OAuthProvider(connection.Type) == GHES {
+			urls = append(urls, "api."+common.HostOf(*connection.URL))
+		}
+	}
+	return common.ConfigureHosts(transportOptions(connection), urls...)
+}
+
 func ConnectionRoutes(r *gin.Engine) {
 	r.POST("/api/connections", CreateConnection)
 	r.PUT("/api/connections/:id", UpdateConnection)
@@ -0,0 +1,163 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"githubclone-backend/api"
+	"githubclone-backend/api/abstracted"
+	"githubclone-backend/api/common"
+	"githubclone-backend/cachable"
+	"githubclone-backend/fakeforge"
+	"githubclone-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The end-to-end test runs the oauth2 login of a GitHub Enterprise connection and browses a
+// repository, all upstream requests are answered by the fake forge.
+
+type memoryBackend struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (m *memoryBackend) Get(key string, dest interface{}) (bool, error) {
+	m.mu.Lock()
+	data, ok := m.values[key]
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, dest)
+}
+
+func (m *memoryBackend) Set(key string, val interface{}, _ bool, _ time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.values[key] = data
+	m.mu.Unlock()
+	return nil
+}
+
+// login runs the authorization code flow against the forge and returns the access token
+func login(t *testing.T, forge *fakeforge.Forge, ghesURL, sessionID string) string {
+	t.Helper()
+	config, err := api.OAuth2Config(models.Connection{
+		Type:         string(api.GHES),
+		URL:          &ghesURL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The browser follows the redirect of /api/login/:provider to the forge
+	browser := &http.Client{
+		Transport:     forge,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := browser.Get(config.AuthCodeURL(sessionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(callback.Path, "/api/callback/github_enterprise") || callback.Query().Get("state") != sessionID {
+		t.Fatalf("authorization redirects to %s", callback)
+	}
+
+	// The token exchange of the callback uses the upstream client
+	token, err := config.Exchange(api.OAuth2Context(config), callback.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.AccessToken
+}
+
+func TestLoginAndBrowse(t *testing.T) {
+	forge, err := fakeforge.NewFromFile("../fakeforge/testdata/seed.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	common.SetUpstreamTransport(forge)
+	defer common.SetUpstreamTransport(nil)
+
+	const sessionID = "e2e-session"
+	token := login(t, forge, "https://ghes.fake.forge", sessionID)
+	api.SetSession(sessionID, 1, map[api.OAuthProvider]api.AccessToken{
+		api.GHES: {Token: token, URL: "ghes.fake.forge", ConnectionID: 1},
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	facade := cachable.NewCacheFacade(context.Background(), &memoryBackend{values: make(map[string][]byte)})
+	router.Use(func(c *gin.Context) {
+		c.Set("cacheFacade", facade)
+		c.Next()
+	})
+	abstracted.SetupRoutes(router)
+
+	get := func(path string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body %s", path, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	const repo = "provider=github_enterprise&owner=octocat&name=hello-world"
+
+	steps := []struct {
+		path string
+		want []string
+	}{
+		{"/api/oauth/loggedinuser", []string{`"login":"octocat"`}},
+		{"/api/oauth/repositories?field=NAME&direction=ASC", []string{`"name":"hello-world"`, `"name":"secret"`}},
+		{"/api/oauth/repository?" + repo, []string{`"defaultBranchRef":{"name":"main"}`, `"totalCount":2`}},
+		{"/api/oauth/repositorybranchcommit?" + repo + "&expression=main", []string{`"messageHeadline":"Describe the guide in the readme"`, `"totalCount":3`}},
+		{"/api/oauth/repositorycontents?" + repo + "&expression=main", []string{`"name":"docs","type":"tree"`, `"name":"README.md","type":"blob"`}},
+		// The second request for the cached tree loads the last commits of the entries
+		{"/api/oauth/repositorycontents?" + repo + "&expression=main&start=docs&limit=2", []string{`"partial":true`, `"message":"Add the guide"`}},
+		{"/api/oauth/repositorycontent?" + repo + "&content=docs/guide.md&expression=main", []string{`"mime":"text/markdown`}},
+		{"/api/oauth/repositorycontributors?" + repo, []string{`"totalCount":2`, `"login":"hubot"`}},
+		{"/api/oauth/repositorycommits?" + repo + "&expression=feature&path=docs", []string{`"messageHeadline":"Remove the logo"`}},
+		{"/api/oauth/repositorytags/all?" + repo, []string{`"name":"v1.0.0"`}},
+	}
+	for _, step := range steps {
+		body := get(step.path)
+		for _, want := range step.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s does not contain %s: %s", step.path, want, body)
+			}
+		}
+	}
+
+	// Cached answers need no upstream request, even after a push
+	requests := forge.Requests()
+	if _, err := forge.Push("octocat", "hello-world", "main", fakeforge.CommitSeed{Message: "Later change", Author: "octocat"}); err != nil {
+		t.Fatal(err)
+	}
+	if body := get("/api/oauth/repositorybranchcommit?" + repo + "&expression=main"); strings.Contains(body, "Later change") {
+		t.Errorf("branch commit was not cached: %s", body)
+	}
+	if forge.Requests() != requests {
+		t.Errorf("cached request reached the forge, %d requests instead of %d", forge.Requests(), requests)
+	}
+}
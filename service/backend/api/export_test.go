@@ -0,0 +1,18 @@
+package api
+
+import (
+	"githubclone-backend/models"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuth2Config returns the oauth2 configuration of a connection for the tests of package api_test
+func OAuth2Config(connection models.Connection) (*oauth2.Config, error) {
+	if baseURL == "" {
+		baseURL = "http://backend.test"
+		internBaseURL = baseURL
+	}
+	return getOAuth2Config(connection)
+}
+
+var OAuth2Context = oauth2Context
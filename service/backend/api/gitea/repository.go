@@ -0,0 +1,90 @@
+package gitea
+
+// The types in this package describe the answers of the Gitea REST API (/api/v1).
+// Forgejo is a fork of Gitea and serves the same API, therefore it's covered as well.
+
+type GiteaUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	FullName  string `json:"full_name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+	Created   string `json:"created"`
+	Location  string `json:"location"`
+	Website   string `json:"website"`
+	Bio       string `json:"description"`
+}
+
+type GiteaRepository struct {
+	ID            int64            `json:"id"`
+	Owner         GiteaUser        `json:"owner"`
+	Name          string           `json:"name"`
+	FullName      string           `json:"full_name"`
+	Description   string           `json:"description"`
+	Private       bool             `json:"private"`
+	Fork          bool             `json:"fork"`
+	Archived      bool             `json:"archived"`
+	Parent        *GiteaRepository `json:"parent"`
+	HTMLURL       string           `json:"html_url"`
+	StarsCount    int              `json:"stars_count"`
+	ForksCount    int              `json:"forks_count"`
+	WatchersCount int              `json:"watchers_count"`
+	DefaultBranch string           `json:"default_branch"`
+	CreatedAt     string           `json:"created_at"`
+	UpdatedAt     string           `json:"updated_at"`
+	Licenses      []string         `json:"licenses"`
+}
+
+// GiteaRepositorySearch is the answer of /repos/search
+type GiteaRepositorySearch struct {
+	OK   bool              `json:"ok"`
+	Data []GiteaRepository `json:"data"`
+}
+
+type GiteaBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID        string `json:"id"`
+		Message   string `json:"message"`
+		Timestamp string `json:"timestamp"`
+	} `json:"commit"`
+}
+
+type GiteaTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+type GiteaRelease struct {
+	Name         string `json:"name"`
+	TagName      string `json:"tag_name"`
+	CreatedAt    string `json:"created_at"`
+	IsDraft      bool   `json:"draft"`
+	IsPrerelease bool   `json:"prerelease"`
+}
+
+type GiteaCommitUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+type GiteaCommit struct {
+	SHA     string `json:"sha"`
+	HTMLURL string `json:"html_url"`
+	Commit  struct {
+		Message   string          `json:"message"`
+		Author    GiteaCommitUser `json:"author"`
+		Committer GiteaCommitUser `json:"committer"`
+	} `json:"commit"`
+	Author *GiteaUser `json:"author"` // Nil if the commit author has no account on the instance
+}
+
+// Languages of a repository, the value is the size in bytes
+type GiteaLanguages map[string]int
+
+// Query parameters which keep the commit listing cheap, stats and signature verification are not needed
+const GiteaCommitListOptions = "stat=false&verification=false&files=false"
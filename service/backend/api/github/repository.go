@@ -144,6 +144,11 @@ type RepositoryNodeWithAttributes struct {
 	} `json:"data"`
 }
 
+// RepositoryPrivate reports the visibility of the repository, ok is false if the answer has no repository
+func (r *RepositoryNodeWithAttributes) RepositoryPrivate() (private bool, ok bool) {
+	return r.Data.Repository.IsPrivate, r.Data.Repository.Name != ""
+}
+
 type RepositoryTree struct {
 	Data struct {
 		Repository struct {
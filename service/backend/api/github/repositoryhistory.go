@@ -0,0 +1,188 @@
+package github
+
+import "githubclone-backend/api/common"
+
+type RepositoryRefNode struct {
+	Name   string `json:"name"`
+	Target struct {
+		Oid           string `json:"oid"`
+		CommittedDate string `json:"committedDate"`
+	} `json:"target"`
+}
+
+type RepositoryRefs struct {
+	Data struct {
+		Repository struct {
+			Refs struct {
+				TotalCount int                 `json:"totalCount"`
+				PageInfo   common.PageInfo     `json:"pageInfo"`
+				Nodes      []RepositoryRefNode `json:"nodes"`
+			} `json:"refs"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+type RepositoryCommitNode struct {
+	Oid             string `json:"oid"`
+	MessageHeadline string `json:"messageHeadline"`
+	CommittedDate   string `json:"committedDate"`
+	Author          struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		User  *struct {
+			Login     string `json:"login"`
+			AvatarURL string `json:"avatarUrl"`
+			URL       string `json:"url"`
+		} `json:"user"`
+	} `json:"author"`
+}
+
+type RepositoryCommitHistory struct {
+	Data struct {
+		Repository struct {
+			Object struct {
+				History struct {
+					TotalCount int                    `json:"totalCount"`
+					PageInfo   common.PageInfo        `json:"pageInfo"`
+					Nodes      []RepositoryCommitNode `json:"nodes"`
+				} `json:"history"`
+			} `json:"object"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+// GithubRepositoryRefsQuery lists branches (refPrefix "refs/heads/") or tags (refPrefix "refs/tags/"),
+// the most recent first. The target of an annotated tag is a tag object, which has no committedDate.
+var GithubRepositoryRefsQuery = `query GetRepositoryRefs(
+  $owner: String!,
+  $name: String!,
+  $refPrefix: String!,
+  $first: Int,
+  $after: String
+) {
+  repository(owner: $owner, name: $name) {
+    refs(
+      refPrefix: $refPrefix
+      first: $first
+      after: $after
+      orderBy: { field: TAG_COMMIT_DATE, direction: DESC }
+    ) {
+      totalCount
+      pageInfo {
+        hasNextPage
+        hasPreviousPage
+        startCursor
+        endCursor
+      }
+      nodes {
+        name
+        target {
+          oid
+          ... on Commit {
+            committedDate
+          }
+        }
+      }
+    }
+  }
+}`
+
+// GithubRepositoryCommitsQuery returns the commit history of a branch, tag or commit SHA,
+// optionally limited to the commits which changed `path`.
+var GithubRepositoryCommitsQuery = `query GetRepositoryCommits(
+  $owner: String!,
+  $name: String!,
+  $expression: String!,
+  $path: String,
+  $first: Int,
+  $after: String
+) {
+  repository(owner: $owner, name: $name) {
+    object(expression: $expression) {
+      ... on Commit {
+        history(first: $first, after: $after, path: $path) {
+          totalCount
+          pageInfo {
+            hasNextPage
+            hasPreviousPage
+            startCursor
+            endCursor
+          }
+          nodes {
+            oid
+            messageHeadline
+            committedDate
+            author {
+              name
+              email
+              user {
+                login
+                avatarUrl
+                url
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type RepositoryBlameRange struct {
+	StartingLine int `json:"startingLine"`
+	EndingLine   int `json:"endingLine"`
+	Age          int `json:"age"`
+	Commit       struct {
+		Oid             string `json:"oid"`
+		MessageHeadline string `json:"messageHeadline"`
+		CommittedDate   string `json:"committedDate"`
+		Author          struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+type RepositoryBlame struct {
+	Data struct {
+		Repository struct {
+			Object struct {
+				Blame struct {
+					Ranges []RepositoryBlameRange `json:"ranges"`
+				} `json:"blame"`
+			} `json:"object"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+// GithubRepositoryBlameQuery returns the ranges of lines of a file together with the commit
+// which changed them last. The age is between 1 (new) and 10 (old).
+var GithubRepositoryBlameQuery = `query GetRepositoryBlame(
+  $owner: String!,
+  $name: String!,
+  $expression: String!,
+  $path: String!
+) {
+  repository(owner: $owner, name: $name) {
+    object(expression: $expression) {
+      ... on Commit {
+        blame(path: $path) {
+          ranges {
+            startingLine
+            endingLine
+            age
+            commit {
+              oid
+              messageHeadline
+              committedDate
+              author {
+                name
+                email
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
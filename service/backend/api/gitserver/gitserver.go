@@ -0,0 +1,229 @@
+package gitserver
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"githubclone-backend/api"
+	"githubclone-backend/db"
+	"githubclone-backend/mirror"
+	"githubclone-backend/models"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The git server provides mirrored repositories read-only over the git smart HTTP protocol,
+// e.g. git clone https://<host>/git/<connection>/<owner>/<repo>.git. The clients authenticate
+// with the credentials of the platform, the token of the linked provider account must be able
+// to see the repository. That token is taken from a live session of the user, i.e. the user has
+// to be logged in to the web interface and the provider while cloning or fetching. The pack is
+// created by git upload-pack of the git installation.
+
+const uploadPack = "git-upload-pack"
+
+// accountStore finds the users and connections of the platform
+type accountStore interface {
+	User(identifier string) (models.User, error) // By email or user name
+	UserByID(id uint) (models.User, error)
+	Connection(name string) (models.Connection, error)
+}
+
+type dbAccounts struct{}
+
+func (dbAccounts) User(identifier string) (models.User, error) {
+	var user models.User
+	err := db.DB.Where("email = ? OR username = ?", identifier, identifier).First(&user).Error
+	return user, err
+}
+
+func (dbAccounts) UserByID(id uint) (models.User, error) {
+	var user models.User
+	err := db.DB.First(&user, id).Error
+	return user, err
+}
+
+func (dbAccounts) Connection(name string) (models.Connection, error) {
+	var connection models.Connection
+	err := db.DB.Where("connection_name = ?", name).First(&connection).Error
+	return connection, err
+}
+
+// accounts reads the platform database, the tests replace it
+var accounts accountStore = dbAccounts{}
+
+// authenticate checks the basic auth credentials against the users of the platform. It writes
+// the response for throttled clients.
+func authenticate(c *gin.Context) (*models.User, bool) {
+	identifier, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	ipKey, userKey := "ip:"+c.ClientIP(), "user:"+strings.ToLower(identifier)
+	if wait, blocked := retryAfter(now, ipKey, userKey); blocked {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.String(http.StatusTooManyRequests, "too many failed logins, try again later")
+		c.Abort()
+		return nil, false
+	}
+
+	credentials := credentialKey(identifier, password)
+	if login, found := lookupVerified(now, credentials); found {
+		if user, err := accounts.UserByID(login.userID); err == nil && !user.Deactivated && user.PasswordHash == login.passwordHash {
+			return &user, true
+		}
+	}
+	user, err := accounts.User(identifier)
+	if err != nil {
+		recordFailure(now, ipKey, userKey)
+		return nil, false
+	}
+	if user.Deactivated || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		recordFailure(now, ipKey, userKey)
+		return nil, false
+	}
+	resetFailures(userKey)
+	rememberVerified(now, credentials, verifiedLogin{userID: user.ID, passwordHash: user.PasswordHash})
+	return &user, true
+}
+
+// repositoryPath authorizes the request and returns the directory of the mirror, the error
+// response is written by this function
+func repositoryPath(c *gin.Context) (string, bool) {
+	if !mirror.Enabled() {
+		c.String(http.StatusServiceUnavailable, mirror.ErrDisabled.Error())
+		return "", false
+	}
+	user, ok := authenticate(c)
+	if c.IsAborted() {
+		return "", false
+	}
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="githubclone"`)
+		c.String(http.StatusUnauthorized, "authentication required")
+		return "", false
+	}
+
+	connection, err := accounts.Connection(c.Param("connection"))
+	if err != nil {
+		c.String(http.StatusNotFound, "repository not found")
+		return "", false
+	}
+	token, err := api.UserAccessToken(user.ID, connection.ID)
+	if err != nil {
+		// The provider token only exists in a live session of the platform
+		c.Header("WWW-Authenticate", `Basic realm="githubclone"`)
+		c.String(http.StatusUnauthorized, "no active session with a login to the provider of the connection %s, log in to the web interface and the provider first", connection.ConnectionName)
+		return "", false
+	}
+
+	owner := c.Param("owner")
+	name := strings.TrimSuffix(c.Param("repo"), ".git")
+	path, err := mirror.Default.Prepare(c.Request.Context(), connection.ID, token, owner, name)
+	switch {
+	case errors.Is(err, mirror.ErrNotMirrored), errors.Is(err, mirror.ErrNotVisible):
+		// Both answers are the same, the existence of a repository must not be revealed
+		c.String(http.StatusNotFound, "repository not found")
+		return "", false
+	case err != nil:
+		log.Printf("Mirror %s/%s cannot be served: %v", owner, name, err)
+		c.String(http.StatusInternalServerError, "repository cannot be served")
+		return "", false
+	}
+	return path, true
+}
+
+// packetLine encodes a line in the pkt-line format of git
+func packetLine(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
+
+// gitCommand runs git upload-pack, the protocol version requested by the client is passed on
+func gitCommand(c *gin.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(c.Request.Context(), "git", args...)
+	cmd.Env = os.Environ()
+	if protocol := c.GetHeader("Git-Protocol"); protocol != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+protocol)
+	}
+	return cmd
+}
+
+func noCache(c *gin.Context) {
+	c.Header("Cache-Control", "no-cache, max-age=0, must-revalidate")
+	c.Header("Pragma", "no-cache")
+	c.Header("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+}
+
+// InfoRefs advertises the refs of a repository, only the upload-pack service is offered
+func InfoRefs(c *gin.Context) {
+	if c.Query("service") != uploadPack {
+		c.String(http.StatusForbidden, "only %s is supported, the repositories are read-only", uploadPack)
+		return
+	}
+	path, ok := repositoryPath(c)
+	if !ok {
+		return
+	}
+	output, err := gitCommand(c, "upload-pack", "--stateless-rpc", "--advertise-refs", path).Output()
+	if err != nil {
+		log.Printf("git upload-pack --advertise-refs failed for %s: %v", path, err)
+		c.String(http.StatusInternalServerError, "refs cannot be advertised")
+		return
+	}
+
+	noCache(c)
+	c.Header("Content-Type", "application/x-git-upload-pack-advertisement")
+	c.Status(http.StatusOK)
+	// Protocol version 2 starts directly with the capabilities
+	if !strings.Contains(c.GetHeader("Git-Protocol"), "version=2") {
+		io.WriteString(c.Writer, packetLine("# service="+uploadPack+"\n")+"0000")
+	}
+	c.Writer.Write(output)
+}
+
+// UploadPack sends the pack with the objects the client asked for
+func UploadPack(c *gin.Context) {
+	path, ok := repositoryPath(c)
+	if !ok {
+		return
+	}
+	body := io.Reader(c.Request.Body)
+	if c.GetHeader("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid gzip body")
+			return
+		}
+		defer reader.Close()
+		body = reader
+	}
+
+	noCache(c)
+	c.Header("Content-Type", "application/x-git-upload-pack-result")
+	c.Status(http.StatusOK)
+	cmd := gitCommand(c, "upload-pack", "--stateless-rpc", path)
+	cmd.Stdin = body
+	cmd.Stdout = c.Writer
+	if err := cmd.Run(); err != nil {
+		// The header is sent already, the client notices the broken pack
+		log.Printf("git upload-pack failed for %s: %v", path, err)
+	}
+}
+
+func SetupRoutes(router *gin.Engine) {
+	router.GET("/git/:connection/:owner/:repo/info/refs", InfoRefs)
+	router.POST("/git/:connection/:owner/:repo/"+uploadPack, UploadPack)
+	// Pushing is not possible
+	router.POST("/git/:connection/:owner/:repo/git-receive-pack", func(c *gin.Context) {
+		c.String(http.StatusForbidden, "the repositories are read-only")
+	})
+}
@@ -0,0 +1,169 @@
+package gitserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"githubclone-backend/api"
+	"githubclone-backend/mirror"
+	"githubclone-backend/mirror/mirrortest"
+	"githubclone-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const testPassword = "correct horse"
+
+// testAccounts are the users of the test by user name and the connection "remote"
+type testAccounts struct {
+	users      map[string]models.User
+	connection models.Connection
+}
+
+func (a testAccounts) User(identifier string) (models.User, error) {
+	if user, ok := a.users[identifier]; ok {
+		return user, nil
+	}
+	return models.User{}, gorm.ErrRecordNotFound
+}
+
+func (a testAccounts) UserByID(id uint) (models.User, error) {
+	for _, user := range a.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return models.User{}, gorm.ErrRecordNotFound
+}
+
+func (a testAccounts) Connection(name string) (models.Connection, error) {
+	if name != a.connection.ConnectionName {
+		return models.Connection{}, gorm.ErrRecordNotFound
+	}
+	return a.connection, nil
+}
+
+// newTestServer serves alice/demo of a remote as mirror. alice and mallory have a live session
+// with a token of the connection, only the token of alice can see the repository. bob has no session.
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	remote := mirrortest.NewRemote(t, "alice-token")
+	oid := remote.Commit(t, "alice", "demo", map[string]string{"README.md": "# demo\n"})
+
+	url := remote.URL
+	connection := models.Connection{Model: gorm.Model{ID: 1}, ConnectionName: "remote", Type: "gitea", URL: &url}
+	store := mirrortest.NewStore(connection)
+	if err := store.Create(&models.Mirror{ConnectionID: 1, Owner: "alice", Name: "demo"}); err != nil {
+		t.Fatal(err)
+	}
+	manager, err := mirror.NewManager(t.TempDir(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := mirror.Default
+	mirror.Default = manager
+	t.Cleanup(func() { mirror.Default = previous })
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := make(map[string]models.User)
+	for i, username := range []string{"alice", "mallory", "bob"} {
+		users[username] = models.User{Model: gorm.Model{ID: uint(9001 + i)}, Username: username, PasswordHash: string(hash)}
+	}
+	accounts = testAccounts{users: users, connection: connection}
+	t.Cleanup(func() { accounts = dbAccounts{} })
+	for username, token := range map[string]string{"alice": remote.Token, "mallory": "mallory-token"} {
+		api.SetSession("gitserver-session-"+username, users[username].ID, map[api.OAuthProvider]api.AccessToken{
+			api.Gitea: {Token: token, URL: remote.URL, ConnectionID: 1},
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, oid
+}
+
+func TestCloneMirror(t *testing.T) {
+	server, oid := newTestServer(t)
+	url := server.URL + "/git/remote/alice/demo.git"
+
+	repo, err := git.PlainClone(t.TempDir(), true, &git.CloneOptions{URL: url, Auth: &githttp.BasicAuth{Username: "alice", Password: testPassword}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := repo.Head(); err != nil || head.Hash().String() != oid {
+		t.Fatalf("head of the clone = %v, %v, want %s", head, err, oid)
+	}
+
+	// git sends large negotiations gzip compressed
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	fmt.Fprintf(writer, "%s0000%s", packetLine("want "+oid+"\n"), packetLine("done\n"))
+	writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, url+"/git-upload-pack", &body)
+	req.SetBasicAuth("alice", testPassword)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var pack bytes.Buffer
+	pack.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(pack.String(), "PACK") {
+		t.Fatalf("gzip upload-pack: status %d, body %q", resp.StatusCode, pack.String())
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	server, _ := newTestServer(t)
+	url := server.URL + "/git/remote/alice/demo.git"
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		username string
+		password string
+		want     int
+	}{
+		{"anonymous", http.MethodGet, "/info/refs?service=git-upload-pack", "", "", http.StatusUnauthorized},
+		{"wrong password", http.MethodGet, "/info/refs?service=git-upload-pack", "alice", "wrong", http.StatusUnauthorized},
+		{"no live session", http.MethodGet, "/info/refs?service=git-upload-pack", "bob", testPassword, http.StatusUnauthorized},
+		{"not visible", http.MethodGet, "/info/refs?service=git-upload-pack", "mallory", testPassword, http.StatusNotFound},
+		{"advertise receive-pack", http.MethodGet, "/info/refs?service=git-receive-pack", "alice", testPassword, http.StatusForbidden},
+		{"receive-pack", http.MethodPost, "/git-receive-pack", "alice", testPassword, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, url+tt.path, nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package gitserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Every failed basic auth attempt is counted per client IP and per user name. After maxFailures
+// within failureWindow the key is rejected without checking the password until the window ends,
+// so passwords cannot be guessed and bcrypt cannot be used to exhaust the CPU. Successful checks
+// are remembered for verifiedTTL, a clone sends several requests with the same credentials.
+
+const (
+	maxFailures   = 10
+	failureWindow = 15 * time.Minute
+	verifiedTTL   = 5 * time.Minute
+)
+
+type failureCount struct {
+	count int
+	first time.Time
+}
+
+type verifiedLogin struct {
+	userID       uint
+	passwordHash string // A changed password invalidates the entry
+	until        time.Time
+}
+
+var (
+	throttleMutex sync.Mutex
+	failures      = make(map[string]*failureCount)
+	verified      = make(map[string]verifiedLogin)
+	lastSweep     time.Time
+)
+
+// retryAfter returns how long the first of keys which exceeded maxFailures stays blocked
+func retryAfter(now time.Time, keys ...string) (time.Duration, bool) {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	for _, key := range keys {
+		if f, ok := failures[key]; ok && f.count >= maxFailures && now.Sub(f.first) < failureWindow {
+			return f.first.Add(failureWindow).Sub(now), true
+		}
+	}
+	return 0, false
+}
+
+// recordFailure counts a failed attempt for each key
+func recordFailure(now time.Time, keys ...string) {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	sweep(now)
+	for _, key := range keys {
+		f, ok := failures[key]
+		if !ok || now.Sub(f.first) >= failureWindow {
+			f = &failureCount{first: now}
+			failures[key] = f
+		}
+		f.count++
+	}
+}
+
+// resetFailures forgets the failures of a key after a successful attempt
+func resetFailures(key string) {
+	throttleMutex.Lock()
+	delete(failures, key)
+	throttleMutex.Unlock()
+}
+
+// credentialKey identifies a pair of user name and password without keeping the password
+func credentialKey(identifier, password string) string {
+	sum := sha256.Sum256([]byte(identifier + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func lookupVerified(now time.Time, key string) (verifiedLogin, bool) {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	login, ok := verified[key]
+	return login, ok && now.Before(login.until)
+}
+
+func rememberVerified(now time.Time, key string, login verifiedLogin) {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	sweep(now)
+	login.until = now.Add(verifiedTTL)
+	verified[key] = login
+}
+
+// sweep removes the expired entries once per minute, the caller holds throttleMutex
+func sweep(now time.Time) {
+	if now.Sub(lastSweep) < time.Minute {
+		return
+	}
+	lastSweep = now
+	for key, f := range failures {
+		if now.Sub(f.first) >= failureWindow {
+			delete(failures, key)
+		}
+	}
+	for key, login := range verified {
+		if !now.Before(login.until) {
+			delete(verified, key)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package gitserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailedLoginsAreThrottled(t *testing.T) {
+	now := time.Now()
+	ip, user := "ip:"+t.Name(), "user:"+t.Name()
+	for i := 0; i < maxFailures-1; i++ {
+		recordFailure(now, ip, user)
+	}
+	if _, blocked := retryAfter(now, ip, user); blocked {
+		t.Fatal("blocked before the limit")
+	}
+	recordFailure(now, ip, user)
+	if wait, blocked := retryAfter(now, ip, user); !blocked || wait != failureWindow {
+		t.Fatalf("retryAfter = %v, %v", wait, blocked)
+	}
+
+	// A successful login of the user does not unblock the client IP
+	resetFailures(user)
+	if _, blocked := retryAfter(now, user); blocked {
+		t.Error("the user is still blocked after a successful login")
+	}
+	if _, blocked := retryAfter(now, ip); !blocked {
+		t.Error("the client IP is not blocked anymore")
+	}
+	if _, blocked := retryAfter(now.Add(failureWindow), ip); blocked {
+		t.Error("the client IP is blocked after the window")
+	}
+}
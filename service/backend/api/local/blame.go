@@ -0,0 +1,74 @@
+package local
+
+import (
+	"githubclone-backend/api/github"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// blameAge maps the date of a commit to the age of the GitHub blame, 1 is the newest and 10
+// the oldest commit of the file
+func blameAge(when, newest, oldest int64) int {
+	if newest == oldest {
+		return 1
+	}
+	return 1 + int(9*(newest-when)/(newest-oldest))
+}
+
+// Blame returns the ranges of lines of a file at a revision with the commit which changed them last
+func Blame(repo *git.Repository, revision, path string) (*github.RepositoryBlame, error) {
+	commit, err := resolveCommit(repo, revision)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := commit.File(path); err != nil {
+		return nil, ErrPathNotFound
+	}
+	blame, err := git.Blame(commit, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryBlame
+	ranges := []github.RepositoryBlameRange{}
+	commits := make(map[plumbing.Hash]*object.Commit)
+	var newest, oldest int64
+	for i, line := range blame.Lines {
+		if _, ok := commits[line.Hash]; !ok {
+			c, err := repo.CommitObject(line.Hash)
+			if err != nil {
+				return nil, err
+			}
+			commits[line.Hash] = c
+			when := c.Committer.When.Unix()
+			if len(commits) == 1 || when > newest {
+				newest = when
+			}
+			if len(commits) == 1 || when < oldest {
+				oldest = when
+			}
+		}
+		last := len(ranges) - 1
+		if last >= 0 && ranges[last].Commit.Oid == line.Hash.String() && ranges[last].EndingLine == i {
+			ranges[last].EndingLine = i + 1
+			continue
+		}
+		var blameRange github.RepositoryBlameRange
+		blameRange.StartingLine = i + 1
+		blameRange.EndingLine = i + 1
+		blameRange.Commit.Oid = line.Hash.String()
+		ranges = append(ranges, blameRange)
+	}
+	for i := range ranges {
+		c := commits[plumbing.NewHash(ranges[i].Commit.Oid)]
+		ranges[i].Age = blameAge(c.Committer.When.Unix(), newest, oldest)
+		ranges[i].Commit.MessageHeadline = messageHeadline(c.Message)
+		ranges[i].Commit.CommittedDate = formatDate(c.Committer.When)
+		ranges[i].Commit.Author.Name = c.Author.Name
+		ranges[i].Commit.Author.Email = c.Author.Email
+	}
+	result.Data.Repository.Object.Blame.Ranges = ranges
+	return &result, nil
+}
@@ -0,0 +1,232 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/github"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// resolveCommit resolves a branch, a tag, a commit SHA or any other git revision to a commit.
+// An empty revision is resolved to HEAD.
+func resolveCommit(repo *git.Repository, revision string) (*object.Commit, error) {
+	revision = strings.TrimPrefix(revision, "refs/heads/")
+	if revision == "" {
+		revision = "HEAD"
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRevisionNotFound, revision)
+	}
+	return repo.CommitObject(*hash)
+}
+
+// splitExpression splits an expression like "main:src/api" into revision and path
+func splitExpression(expression string) (string, string) {
+	revision, path, _ := strings.Cut(expression, ":")
+	return revision, strings.Trim(path, "/")
+}
+
+func messageHeadline(message string) string {
+	return strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+}
+
+// peelToCommit returns the commit a reference points to, annotated tags are followed
+func peelToCommit(repo *git.Repository, ref *plumbing.Reference) (*object.Commit, error) {
+	if tag, err := repo.TagObject(ref.Hash()); err == nil {
+		return tag.Commit()
+	}
+	return repo.CommitObject(ref.Hash())
+}
+
+// Refs lists the branches (prefix "refs/heads/") or tags (prefix "refs/tags/"), the most recent first
+func Refs(repo *git.Repository, prefix string) (*github.RepositoryRefs, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []github.RepositoryRefNode
+	dates := make(map[string]int64)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if ref.Type() != plumbing.HashReference || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		node := github.RepositoryRefNode{Name: strings.TrimPrefix(name, prefix)}
+		node.Target.Oid = ref.Hash().String()
+		if commit, err := peelToCommit(repo, ref); err == nil {
+			node.Target.CommittedDate = formatDate(commit.Committer.When)
+			dates[node.Name] = commit.Committer.When.Unix()
+		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if dates[nodes[i].Name] != dates[nodes[j].Name] {
+			return dates[nodes[i].Name] > dates[nodes[j].Name]
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	var result github.RepositoryRefs
+	result.Data.Repository.Refs.TotalCount = len(nodes)
+	result.Data.Repository.Refs.Nodes = nodes
+	return &result, nil
+}
+
+// countCommits counts the commits which are reachable from commit
+func countCommits(repo *git.Repository, commit *object.Commit) (int, error) {
+	iter, err := repo.Log(&git.LogOptions{From: commit.Hash})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = iter.ForEach(func(*object.Commit) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// BranchCommit returns the commit of a revision in the shape of the GitHub branch commit query
+func BranchCommit(repo *git.Repository, expression string) (*github.RepositoryBranchCommit, error) {
+	revision, _ := splitExpression(expression)
+	commit, err := resolveCommit(repo, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryBranchCommit
+	target := &result.Data.Repository.Ref.Target
+	target.OID = commit.Hash.String()
+	target.CommittedDate = formatDate(commit.Committer.When)
+	target.MessageHeadline = messageHeadline(commit.Message)
+	target.Author.Name = commit.Author.Name
+	target.Author.Email = commit.Author.Email
+	if commit.PGPSignature != "" {
+		// The signature is passed on, but there is no keyring to verify it
+		target.Signature = &struct {
+			IsValid   bool   `json:"isValid"`
+			Payload   string `json:"payload"`
+			Signature string `json:"signature"`
+			Signer    *struct {
+				Name  string `json:"name"`
+				Email string `json:"email"`
+			} `json:"signer"`
+		}{
+			Signature: commit.PGPSignature,
+		}
+	}
+	target.History.TotalCount, err = countCommits(repo, commit)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Commits returns a page of the history of a revision, optionally limited to the commits which changed path.
+// The cursor `after` is the offset of the next page.
+func Commits(repo *git.Repository, expression, path string, first int, after string) (*github.RepositoryCommitHistory, error) {
+	revision, _ := splitExpression(expression)
+	commit, err := resolveCommit(repo, revision)
+	if err != nil {
+		return nil, err
+	}
+	if first < 1 {
+		first = common.DefaultFirst
+	}
+	offset, err := strconv.Atoi(after)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	options := &git.LogOptions{From: commit.Hash, Order: git.LogOrderCommitterTime}
+	if path = strings.Trim(path, "/"); path != "" {
+		options.PathFilter = func(file string) bool {
+			return file == path || strings.HasPrefix(file, path+"/")
+		}
+	}
+	iter, err := repo.Log(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryCommitHistory
+	history := &result.Data.Repository.Object.History
+	history.Nodes = []github.RepositoryCommitNode{}
+	index := 0
+	err = iter.ForEach(func(c *object.Commit) error {
+		if index >= offset && index < offset+first {
+			node := github.RepositoryCommitNode{
+				Oid:             c.Hash.String(),
+				MessageHeadline: messageHeadline(c.Message),
+				CommittedDate:   formatDate(c.Committer.When),
+			}
+			node.Author.Name = c.Author.Name
+			node.Author.Email = c.Author.Email
+			history.Nodes = append(history.Nodes, node)
+		}
+		index++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	history.TotalCount = index
+	history.PageInfo = common.PageInfo{
+		HasNextPage:     offset+first < index,
+		HasPreviousPage: offset > 0,
+		StartCursor:     strconv.Itoa(offset),
+		EndCursor:       strconv.Itoa(offset + len(history.Nodes)),
+	}
+	return &result, nil
+}
+
+// Contributors counts the commits per author of the history of HEAD. Without accounts the
+// author name is used as login.
+func Contributors(repo *git.Repository, limit int) (*github.RepositoryContributor, error) {
+	commit := headCommit(repo)
+	if commit == nil {
+		return &github.RepositoryContributor{Nodes: []github.RepositoryContributorNode{}}, nil
+	}
+	iter, err := repo.Log(&git.LogOptions{From: commit.Hash})
+	if err != nil {
+		return nil, err
+	}
+	contributions := make(map[string]int)
+	err = iter.ForEach(func(c *object.Commit) error {
+		contributions[c.Author.Name]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]github.RepositoryContributorNode, 0, len(contributions))
+	for name, count := range contributions {
+		nodes = append(nodes, github.RepositoryContributorNode{Login: name, Contributions: count})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Contributions != nodes[j].Contributions {
+			return nodes[i].Contributions > nodes[j].Contributions
+		}
+		return nodes[i].Login < nodes[j].Login
+	})
+	total := len(nodes)
+	if len(nodes) > limit {
+		nodes = nodes[:limit]
+	}
+	return &github.RepositoryContributor{TotalCount: total, Nodes: nodes}, nil
+}
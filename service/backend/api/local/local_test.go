@@ -0,0 +1,108 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// createRepository creates <root>/alice/demo with two commits
+func createRepository(t *testing.T, root string) {
+	dir := filepath.Join(root, "alice", "demo")
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []map[string]string{
+		{"README.md": "# demo\n", "src/main.go": "package main\n"},
+		{"src/main.go": "package main\n\nfunc main() {}\n"},
+	}
+	for i, changes := range files {
+		for name, content := range changes {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worktree.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		signature := &object.Signature{Name: "Alice", Email: "alice@example.com", When: time.Unix(int64(1700000000+i*60), 0)}
+		if _, err := worktree.Commit("commit "+string(rune('1'+i)), &git.CommitOptions{Author: signature, Committer: signature}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocalRepository(t *testing.T) {
+	root := t.TempDir()
+	createRepository(t, root)
+
+	repos, err := ListRepositories(root, 10, "", "NAME", "ASC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes := repos.Data.Viewer.Repositories.Nodes; len(nodes) != 1 || nodes[0].Name != "demo" {
+		t.Fatalf("unexpected repositories: %+v", nodes)
+	}
+
+	if _, err := OpenRepository(root, "..", "demo"); err != ErrRepositoryNotFound {
+		t.Fatalf("expected ErrRepositoryNotFound, got %v", err)
+	}
+
+	repo, err := OpenRepository(root, "alice", "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := TreeCommit(repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := tree.Data.Repository.Object.Entries
+	if len(entries) != 2 || entries[0].Name != "src" || entries[0].Type != "tree" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if entries[0].Message != "commit 2" || entries[1].Message != "commit 1" {
+		t.Fatalf("unexpected last commits: %q, %q", entries[0].Message, entries[1].Message)
+	}
+
+	history, err := Commits(repo, "", "README.md", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Data.Repository.Object.History.TotalCount != 1 {
+		t.Fatalf("expected one commit for README.md, got %d", history.Data.Repository.Object.History.TotalCount)
+	}
+
+	branch, err := BranchCommit(repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch.Data.Repository.Ref.Target.History.TotalCount != 2 {
+		t.Fatalf("expected two commits, got %d", branch.Data.Repository.Ref.Target.History.TotalCount)
+	}
+
+	blame, err := Blame(repo, "", "src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := blame.Data.Repository.Object.Blame.Ranges
+	if len(ranges) != 2 || ranges[0].EndingLine != 1 || ranges[1].StartingLine != 2 || ranges[1].EndingLine != 3 {
+		t.Fatalf("unexpected blame ranges: %+v", ranges)
+	}
+	if ranges[0].Commit.MessageHeadline != "commit 1" || ranges[1].Commit.MessageHeadline != "commit 2" {
+		t.Fatalf("unexpected blame commits: %+v", ranges)
+	}
+}
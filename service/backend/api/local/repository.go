@@ -0,0 +1,220 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/github"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The local provider serves git repositories from a directory, no forge is involved.
+// The repositories are expected as <root>/<owner>/<name>.git (bare) or <root>/<owner>/<name>.
+// All answers are mapped into the GitHub shaped types like the other providers do it.
+
+const defaultDescription = "Unnamed repository;" // Written by git init into the description file
+
+var ErrRepositoryNotFound = errors.New("repository not found")
+
+// formatDate formats a commit date like the GitHub api does it
+func formatDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ValidName prevents that an owner or repository name leaves the root directory
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// OpenRepository opens the repository owner/name below the root directory
+func OpenRepository(root, owner, name string) (*git.Repository, error) {
+	if !ValidName(owner) || !ValidName(name) {
+		return nil, ErrRepositoryNotFound
+	}
+	for _, dir := range []string{name + ".git", name} {
+		repo, err := git.PlainOpen(filepath.Join(root, owner, dir))
+		if err == nil {
+			return repo, nil
+		}
+	}
+	return nil, ErrRepositoryNotFound
+}
+
+type repositoryInfo struct {
+	node  github.RepositoryNode
+	owner string
+	head  time.Time
+}
+
+// readDescription returns the content of the description file of a bare repository
+func readDescription(path string) string {
+	data, err := os.ReadFile(filepath.Join(path, "description"))
+	if err != nil {
+		return ""
+	}
+	description := strings.TrimSpace(string(data))
+	if strings.HasPrefix(description, defaultDescription) {
+		return ""
+	}
+	return description
+}
+
+// headCommit returns the commit HEAD points to, nil for an empty repository
+func headCommit(repo *git.Repository) *object.Commit {
+	head, err := repo.Head()
+	if err != nil {
+		return nil
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil
+	}
+	return commit
+}
+
+func scanRepositories(root string) ([]repositoryInfo, error) {
+	owners, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read repository root: %w", err)
+	}
+	var infos []repositoryInfo
+	for _, owner := range owners {
+		if !owner.IsDir() || strings.HasPrefix(owner.Name(), ".") {
+			continue
+		}
+		candidates, err := os.ReadDir(filepath.Join(root, owner.Name()))
+		if err != nil {
+			continue
+		}
+		for _, candidate := range candidates {
+			if !candidate.IsDir() {
+				continue
+			}
+			path := filepath.Join(root, owner.Name(), candidate.Name())
+			repo, err := git.PlainOpen(path)
+			if err != nil {
+				continue // Not a git repository
+			}
+			info := repositoryInfo{
+				owner: owner.Name(),
+				node: github.RepositoryNode{
+					Name:        strings.TrimSuffix(candidate.Name(), ".git"),
+					Description: readDescription(path),
+				},
+			}
+			if commit := headCommit(repo); commit != nil {
+				info.head = commit.Committer.When
+				info.node.UpdatedAt = formatDate(info.head)
+				info.node.PushedAt = info.node.UpdatedAt
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// ListRepositories lists all repositories below root. The cursor `after` is the offset of the next page.
+func ListRepositories(root string, first int, after, field, direction string) (*github.GitHubRepositoriesOfViewer, error) {
+	infos, err := scanRepositories(root)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		var less bool
+		if field == "NAME" {
+			less = infos[i].owner+"/"+infos[i].node.Name < infos[j].owner+"/"+infos[j].node.Name
+		} else {
+			// There are no stars and no creation date on a filesystem, the head commit is used instead
+			less = infos[i].head.Before(infos[j].head)
+		}
+		if direction == "DESC" {
+			return !less
+		}
+		return less
+	})
+
+	if first < 1 {
+		first = common.DefaultFirst
+	}
+	offset, err := strconv.Atoi(after)
+	if err != nil || offset < 0 || offset > len(infos) {
+		offset = 0
+	}
+	end := offset + first
+	if end > len(infos) {
+		end = len(infos)
+	}
+
+	var result github.GitHubRepositoriesOfViewer
+	result.Data.Viewer.Repositories.Nodes = make([]github.RepositoryNode, 0, end-offset)
+	for _, info := range infos[offset:end] {
+		result.Data.Viewer.Repositories.Nodes = append(result.Data.Viewer.Repositories.Nodes, info.node)
+	}
+	result.Data.Viewer.Repositories.PageInfo = common.PageInfo{
+		HasNextPage:     end < len(infos),
+		HasPreviousPage: offset > 0,
+		StartCursor:     strconv.Itoa(offset),
+		EndCursor:       strconv.Itoa(end),
+	}
+	return &result, nil
+}
+
+// Repository returns the metadata of a repository in the shape of the GitHub repository query
+func Repository(root, owner, name string) (*github.RepositoryNodeWithAttributes, error) {
+	repo, err := OpenRepository(root, owner, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryNodeWithAttributes
+	extended := &result.Data.Repository
+	extended.Name = name
+	for _, dir := range []string{name + ".git", name} {
+		if description := readDescription(filepath.Join(root, owner, dir)); description != "" {
+			extended.Description = description
+		}
+	}
+	if commit := headCommit(repo); commit != nil {
+		extended.UpdatedAt = formatDate(commit.Committer.When)
+		extended.PushedAt = extended.UpdatedAt
+	}
+	if head, err := repo.Reference(plumbing.HEAD, false); err == nil && head.Type() == plumbing.SymbolicReference {
+		extended.DefaultBranchRef.Name = head.Target().Short()
+	}
+
+	// Same limits as in the GitHub query: 9 branches and 10 tags
+	branches, err := Refs(repo, "refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	refs := branches.Data.Repository.Refs
+	extended.Branches.TotalCount = refs.TotalCount
+	for i := 0; i < len(refs.Nodes) && i < 9; i++ {
+		extended.Branches.Nodes = append(extended.Branches.Nodes, struct {
+			Name string `json:"name"`
+		}{Name: refs.Nodes[i].Name})
+	}
+
+	tags, err := Refs(repo, "refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	refs = tags.Data.Repository.Refs
+	extended.Tags.TotalCount = refs.TotalCount
+	for i := 0; i < len(refs.Nodes) && i < 10; i++ {
+		extended.Tags.Nodes = append(extended.Tags.Nodes, struct {
+			Name string `json:"name"`
+		}{Name: refs.Nodes[i].Name})
+	}
+	return &result, nil
+}
@@ -0,0 +1,184 @@
+package local
+
+import (
+	"encoding/base64"
+	"errors"
+	"githubclone-backend/api/github"
+	"githubclone-backend/utils"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The search for the last commit of each entry stops after this number of commits,
+// entries which are older keep empty commit information
+const maxHistoryWalk = 10000
+
+var ErrPathNotFound = errors.New("path not found")
+
+// entryType maps the git file mode to the type and mode which the GitHub conversion produces
+func entryType(mode filemode.FileMode) (string, string) {
+	switch mode {
+	case filemode.Dir:
+		return "tree", "040000"
+	case filemode.Submodule:
+		return "commit", "160000"
+	case filemode.Symlink:
+		return "symlink", "120000"
+	case filemode.Executable:
+		return "blob", "100755"
+	default:
+		return "blob", "100644"
+	}
+}
+
+// treeAt returns the tree of the directory path in commit, nil if it does not exist
+func treeAt(commit *object.Commit, path string) *object.Tree {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil
+	}
+	if path == "" {
+		return tree
+	}
+	subtree, err := tree.Tree(path)
+	if err != nil {
+		return nil
+	}
+	return subtree
+}
+
+// entryHashes maps the names of the entries of a tree to their object hashes
+func entryHashes(tree *object.Tree) map[string]plumbing.Hash {
+	hashes := make(map[string]plumbing.Hash)
+	if tree != nil {
+		for _, entry := range tree.Entries {
+			hashes[entry.Name] = entry.Hash
+		}
+	}
+	return hashes
+}
+
+// lastCommits finds for every entry of the directory path the last commit which changed it.
+// All entries are resolved with a single walk through the history. A commit changed an entry
+// if the entry differs from the entry in each of its parents.
+func lastCommits(repo *git.Repository, head *object.Commit, path string, names []string) (map[string]*object.Commit, error) {
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+	result := make(map[string]*object.Commit, len(names))
+
+	iter, err := repo.Log(&git.LogOptions{From: head.Hash, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for walked := 0; len(pending) > 0 && walked < maxHistoryWalk; walked++ {
+		commit, err := iter.Next()
+		if err != nil {
+			break // End of the history
+		}
+		current := entryHashes(treeAt(commit, path))
+		var parents []map[string]plumbing.Hash
+		_ = commit.Parents().ForEach(func(parent *object.Commit) error {
+			parents = append(parents, entryHashes(treeAt(parent, path)))
+			return nil
+		})
+
+		for name := range pending {
+			hash, exists := current[name]
+			if !exists {
+				continue
+			}
+			changed := true
+			for _, parent := range parents {
+				if parentHash, ok := parent[name]; ok && parentHash == hash {
+					changed = false
+					break
+				}
+			}
+			if changed {
+				result[name] = commit
+				delete(pending, name)
+			}
+		}
+	}
+	return result, nil
+}
+
+// TreeCommit lists the directory of an expression like "main" or "main:src/api" together with
+// the last commit of each entry, directories and submodules first
+func TreeCommit(repo *git.Repository, expression string) (*github.RepositoryTreeCommit, error) {
+	revision, path := splitExpression(expression)
+	head, err := resolveCommit(repo, revision)
+	if err != nil {
+		return nil, err
+	}
+	tree := treeAt(head, path)
+	if tree == nil {
+		return nil, ErrPathNotFound
+	}
+
+	names := make([]string, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		names = append(names, entry.Name)
+	}
+	commits, err := lastCommits(repo, head, path, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var result github.RepositoryTreeCommit
+	entries := make([]github.RepositoryEntryTreeCommit, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		entryType, mode := entryType(entry.Mode)
+		treeEntry := github.RepositoryEntryTreeCommit{
+			Name: entry.Name,
+			Type: entryType,
+			Mode: mode,
+		}
+		if commit, ok := commits[entry.Name]; ok {
+			treeEntry.Oid = commit.Hash.String()
+			treeEntry.Message = commit.Message
+			treeEntry.CommittedDate = formatDate(commit.Committer.When)
+		}
+		entries = append(entries, treeEntry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		isPreferred := func(t string) bool {
+			return t == "tree" || t == "commit"
+		}
+		if isPreferred(entries[i].Type) != isPreferred(entries[j].Type) {
+			return isPreferred(entries[i].Type)
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	result.Data.Repository.Object.Entries = entries
+	return &result, nil
+}
+
+// File returns the base64 encoded content of a file and its MIME type
+func File(repo *git.Repository, revision, path string) (*github.GitHubFile, error) {
+	commit, err := resolveCommit(repo, revision)
+	if err != nil {
+		return nil, err
+	}
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, ErrPathNotFound
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	content := []byte(contents)
+	return &github.GitHubFile{
+		Content: base64.StdEncoding.EncodeToString(content),
+		MIME:    utils.DetectMIME(path, content),
+	}, nil
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"fmt"
+	"githubclone-backend/api/common"
 	"githubclone-backend/cachable"
 	"githubclone-backend/db"
 	"githubclone-backend/models"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,8 +34,15 @@ const (
 	Github OAuthProvider = "github"
 	Gitlab OAuthProvider = "gitlab"
 	GHES   OAuthProvider = "github_enterprise"
+
+	GitlabSelfManaged OAuthProvider = "gitlab_selfmanaged"
+	Gitea             OAuthProvider = "gitea" // Gitea and Forgejo
+	Local             OAuthProvider = "local" // Bare repositories in a directory of the server
 )
 
+// A local connection needs no authorization, its session carries this placeholder as token
+const LocalAccessToken = "local"
+
 var (
 	GithubAddress string = "github.com"
 	GitlabAddress string = "gitlab.com"
@@ -53,8 +62,9 @@ type OAuthConfig struct {
 }
 
 type AccessToken struct {
-	Token string
-	URL   string
+	Token        string
+	URL          string
+	ConnectionID uint
 }
 
 var sessionConfig = make(map[string]OAuthConfig)
@@ -64,6 +74,10 @@ var OAuthProviderURL = map[OAuthProvider]*string{
 	Github: &GithubAddress,
 	Gitlab: &GitlabAddress,
 	GHES:   nil,
+
+	GitlabSelfManaged: nil,
+	Gitea:             nil,
+	Local:             nil,
 }
 
 func IsValidSession(sessionID string) bool {
@@ -94,12 +108,21 @@ func GenerateJWT(userID uint, tokens map[string]string) (string, error) {
 	return tokenString, nil
 }
 
-func getOAuth2Config(clientID, clientSecret string, serviceType OAuthProvider, ghesURL *string) (*oauth2.Config, error) {
+func getOAuth2Config(connection models.Connection) (*oauth2.Config, error) {
 	// Check the base URL
 	if baseURL == "" {
 		return nil, fmt.Errorf("baseURL is not set")
 	}
 
+	clientID := connection.ClientID
+	clientSecret := connection.ClientSecret
+	serviceType := OAuthProvider(connection.Type)
+
+	// Proxy, certificate authority and client certificate apply to the token exchange, the refresh and all api calls
+	if err := configureTransport(connection); err != nil {
+		return nil, err
+	}
+
 	var config *oauth2.Config
 
 	switch serviceType {
@@ -113,6 +136,7 @@ func getOAuth2Config(clientID, clientSecret string, serviceType OAuthProvider, g
 		}
 	case GHES:
 		// Check if the url is set, other it's not possible to work with a github enterprise server
+		ghesURL := connection.URL
 		if ghesURL == nil || *ghesURL == "" {
 			return nil, fmt.Errorf("GitHub Enterprise URL is required but not provided")
 		}
@@ -134,6 +158,44 @@ func getOAuth2Config(clientID, clientSecret string, serviceType OAuthProvider, g
 			Scopes:       []string{"read_user", "api"},
 			Endpoint:     gitlab.Endpoint,
 		}
+	case GitlabSelfManaged:
+		// A self-managed instance has the same oauth2 paths as gitlab.com, but below its own URL
+		if connection.URL == nil || *connection.URL == "" {
+			return nil, fmt.Errorf("GitLab self-managed URL is required but not provided")
+		}
+		gitlabURL := strings.TrimRight(*connection.URL, "/")
+		config = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  fmt.Sprintf("%s/api/callback/gitlab_selfmanaged", internBaseURL),
+			Scopes:       []string{"read_user", "api"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  fmt.Sprintf("%s/oauth/authorize", gitlabURL),
+				TokenURL: fmt.Sprintf("%s/oauth/token", gitlabURL),
+			},
+		}
+	case Local:
+		// The repositories are read from the directory in the URL, there is no oauth2 authorization
+		if connection.URL == nil || *connection.URL == "" {
+			return nil, fmt.Errorf("directory of the local repositories is required but not provided")
+		}
+		return nil, nil
+	case Gitea:
+		// Gitea (and Forgejo) is always self-hosted, the oauth2 paths follow the GitHub naming
+		if connection.URL == nil || *connection.URL == "" {
+			return nil, fmt.Errorf("Gitea URL is required but not provided")
+		}
+		giteaURL := strings.TrimRight(*connection.URL, "/")
+		config = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  fmt.Sprintf("%s/api/callback/gitea", internBaseURL),
+			Scopes:       []string{"read:user", "read:repository"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  fmt.Sprintf("%s/login/oauth/authorize", giteaURL),
+				TokenURL: fmt.Sprintf("%s/login/oauth/access_token", giteaURL),
+			},
+		}
 	default:
 		return nil, fmt.Errorf("unsupported service type: %s", serviceType)
 	}
@@ -141,6 +203,29 @@ func getOAuth2Config(clientID, clientSecret string, serviceType OAuthProvider, g
 	return config, nil
 }
 
+// newProviderType creates the state of a connection within a session. A local connection
+// is usable right away, all other connections need the oauth2 login.
+func newProviderType(connection models.Connection, sessionID string, config *oauth2.Config) OAuthProviderType {
+	providerType := OAuthProviderType{
+		token:         nil,
+		url:           fmt.Sprintf("%s/api/login/%s?state=%s", internBaseURL, connection.Type, sessionID),
+		oauthconfig:   config,
+		connectionURL: connection.URL,
+		connectionID:  connection.ID,
+	}
+	if OAuthProvider(connection.Type) == Local {
+		providerType.token = &oauth2.Token{AccessToken: LocalAccessToken}
+		providerType.url = ""
+	}
+	return providerType
+}
+
+// oauth2Context returns a context that makes the oauth2 package use the http client
+// registered for the token endpoint, e.g. one trusting a custom certificate authority.
+func oauth2Context(config *oauth2.Config) context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, common.HTTPClientFor(config.Endpoint.TokenURL))
+}
+
 func RestoreLogin(facade *cachable.CacheFacade, session models.Session) {
 
 	tx := db.DB.Begin() // Start of transaction
@@ -175,17 +260,12 @@ func RestoreLogin(facade *cachable.CacheFacade, session models.Session) {
 	}
 
 	for _, connection := range userConnections {
-		config, err := getOAuth2Config(connection.ClientID, connection.ClientSecret, OAuthProvider(connection.Type), connection.URL)
+		config, err := getOAuth2Config(connection)
 		if err != nil {
 			oauthConfigMutex.Unlock()
 			return
 		}
-		sessionConfig[sessionID].config[OAuthProvider(connection.Type)] = OAuthProviderType{
-			token:         nil,
-			url:           fmt.Sprintf("%s/api/login/%s?state=%s", internBaseURL, connection.Type, sessionID),
-			oauthconfig:   config,
-			connectionURL: connection.URL,
-		}
+		sessionConfig[sessionID].config[OAuthProvider(connection.Type)] = newProviderType(connection, sessionID, config)
 	}
 	// copy structure to provide an answer
 	loginURLs := make(map[string]string)
@@ -250,19 +330,13 @@ func Login(c *gin.Context) {
 	}
 
 	for _, connection := range userConnections {
-		config, err := getOAuth2Config(connection.ClientID, connection.ClientSecret, OAuthProvider(connection.Type), connection.URL)
+		config, err := getOAuth2Config(connection)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "OAuth2 configuration failed"})
 			oauthConfigMutex.Unlock()
 			return
 		}
-		sessionConfig[sessionID].config[OAuthProvider(connection.Type)] = OAuthProviderType{
-			token:         nil,
-			url:           fmt.Sprintf("%s/api/login/%s?state=%s", internBaseURL, connection.Type, sessionID),
-			oauthconfig:   config,
-			connectionURL: connection.URL,
-			connectionID:  connection.ID,
-		}
+		sessionConfig[sessionID].config[OAuthProvider(connection.Type)] = newProviderType(connection, sessionID, config)
 	}
 	// copy structure to provide an answer
 	loginURLs := make(map[string]string)
@@ -336,7 +410,7 @@ func LoginProvider(c *gin.Context) {
 
 	// log.Printf("oauthConfigMap: %v", sessionConfig)
 
-	if !exists {
+	if !exists || config.oauthconfig == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No OAuth2 config found for this session"})
 		return
 	}
@@ -358,12 +432,12 @@ func CallbackProvider(c *gin.Context) {
 	oauthConfigMutex.Lock()
 	providerConfig, exists := sessionConfig[sessionID].config[OAuthProvider(provider)]
 
-	if !exists {
+	if !exists || providerConfig.oauthconfig == nil {
 		oauthConfigMutex.Unlock()
 		c.Redirect(http.StatusFound, "/login?error=Invalid provider")
 		return
 	}
-	token, err := providerConfig.oauthconfig.Exchange(context.Background(), code)
+	token, err := providerConfig.oauthconfig.Exchange(oauth2Context(providerConfig.oauthconfig), code)
 	if err != nil {
 		oauthConfigMutex.Unlock()
 		c.Redirect(http.StatusFound, "/login?error=Token exchange failed")
@@ -419,7 +493,7 @@ func RestoreProvider(oauth2session models.OAuth2Session) {
 		oauth2session.ExpiresAt.Before(time.Now()) &&
 		oauth2session.RefreshToken != "" &&
 		providerConfig.oauthconfig != nil {
-		ctx := context.Background()
+		ctx := oauth2Context(providerConfig.oauthconfig)
 		tokenSource := providerConfig.oauthconfig.TokenSource(ctx, &token)
 		newToken, err := tokenSource.Token()
 		if err != nil {
@@ -553,7 +627,7 @@ func GetToken(sessionID string) (map[OAuthProvider]AccessToken, error) {
 			u = GithubAddress
 		case Gitlab:
 			u = GitlabAddress
-		case GHES:
+		case GHES, GitlabSelfManaged, Gitea, Local:
 			if value.connectionURL != nil {
 				u = *value.connectionURL
 			}
@@ -566,8 +640,9 @@ func GetToken(sessionID string) (map[OAuthProvider]AccessToken, error) {
 		}
 		if value.token != nil {
 			at[key] = AccessToken{
-				Token: value.token.AccessToken,
-				URL:   u,
+				Token:        value.token.AccessToken,
+				URL:          u,
+				ConnectionID: value.connectionID,
 			}
 		}
 	}
@@ -576,6 +651,25 @@ func GetToken(sessionID string) (map[OAuthProvider]AccessToken, error) {
 	return at, nil
 }
 
+// UserAccessToken returns a valid token of any session of the user for a connection, it's used
+// by requests which authenticate without a session cookie, e.g. from git clients
+func UserAccessToken(userID uint, connectionID uint) (string, error) {
+	id := fmt.Sprintf("%d", userID)
+	oauthConfigMutex.Lock()
+	defer oauthConfigMutex.Unlock()
+	for _, session := range sessionConfig {
+		if session.user["id"] != id {
+			continue
+		}
+		for _, value := range session.config {
+			if value.connectionID == connectionID && value.token != nil && value.token.Valid() {
+				return value.token.AccessToken, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("the user has no session with a token for the connection %d", connectionID)
+}
+
 func SessionRoutes(r *gin.Engine) {
 	r.POST("/api/login", Login)
 	r.POST("/api/logout", Logout)
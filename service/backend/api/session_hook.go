@@ -0,0 +1,26 @@
+//go:build !release
+
+package api
+
+import (
+	"strconv"
+
+	"golang.org/x/oauth2"
+)
+
+// SetSession registers a session with the tokens of logged in providers, e.g. for the tests of
+// the handlers which need a session but no login flow. Release builds (-tags release) leave it out.
+func SetSession(sessionID string, userID uint, tokens map[OAuthProvider]AccessToken) {
+	config := make(map[OAuthProvider]OAuthProviderType)
+	for provider, access := range tokens {
+		connectionURL := access.URL
+		config[provider] = OAuthProviderType{
+			token:         &oauth2.Token{AccessToken: access.Token},
+			connectionURL: &connectionURL,
+			connectionID:  access.ConnectionID,
+		}
+	}
+	oauthConfigMutex.Lock()
+	sessionConfig[sessionID] = OAuthConfig{user: map[string]string{"id": strconv.FormatUint(uint64(userID), 10)}, config: config}
+	oauthConfigMutex.Unlock()
+}
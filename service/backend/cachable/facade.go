@@ -2,8 +2,10 @@ package cachable
 
 import (
 	"context"
+	"githubclone-backend/api/common"
 	"githubclone-backend/api/github"
 	"githubclone-backend/cache"
+	"reflect"
 	"time"
 )
 
@@ -15,24 +17,76 @@ type CacheFacade struct {
 	GitHubRepositoryContributorCache        *cache.TypedCache[github.RepositoryContributor]
 	GitHubFileCache                         *cache.TypedCache[github.GitHubFile]
 	GitHubRepositoryTreeCommit              *cache.TypedCache[github.RepositoryTreeCommit]
+	GitHubRepositoryRefsCache               *cache.TypedCache[github.RepositoryRefs]
+	GitHubRepositoryCommitHistoryCache      *cache.TypedCache[github.RepositoryCommitHistory]
+	GitHubRepositoryBlameCache              *cache.TypedCache[github.RepositoryBlame]
+	RevalidationCache                       *cache.TypedCache[common.RevalidationEntry]
+	RepositoryVisibilityCache               *cache.TypedCache[RepositoryVisibility]
+
+	backend cache.CacheBackend
+}
+
+// CommitKey is the last segment of a cache key for a resolved commit id. Only the handlers which
+// resolved the id use it, a branch or path which looks like a commit id does not get the segment.
+func CommitKey(oid string) string {
+	return "oid:" + oid
+}
+
+// commitAddressed keeps the entries whose key ends with a CommitKey until they are evicted, the
+// content of a commit cannot change. Other keys, e.g. of a branch, expire after ttl.
+func commitAddressed(ttl time.Duration) cache.TTLPolicy {
+	return cache.PatternTTL{
+		Rules:   []cache.PatternRule{cache.MatchTTL(`:oid:[0-9a-f]{40}$`, cache.NoExpiry)},
+		Default: cache.FixedTTL{Duration: ttl},
+	}
 }
 
-func newTypedCache[T any](ctx context.Context, backend cache.CacheBackend, name string, persist bool, ttl time.Duration) *cache.TypedCache[T] {
-	return cache.NewTypedCache[T](ctx, backend, name, persist, cache.FixedTTL{Duration: ttl})
+func newTypedCache[T any](ctx context.Context, backend cache.CacheBackend, name string, scope cache.Scope, persist bool, ttl time.Duration) *cache.TypedCache[T] {
+	return cache.NewTypedCache[T](ctx, backend, name, scope, persist, cache.FixedTTL{Duration: ttl})
 }
 
+// The data of a repository is shared by the users of a connection while the repository is known
+// to be public, the list of repositories depends on the viewer. The revalidation entries contain
+// the hash of the token in their key.
 func NewCacheFacade(ctx context.Context, backend cache.CacheBackend) *CacheFacade {
 	return &CacheFacade{
-		ConfigValueCache:                        newTypedCache[ConfigurationValue](ctx, backend, "config", true, 5*time.Minute),
-		GitHubRepositoriesOfViewerCache:         newTypedCache[github.GitHubRepositoriesOfViewer](ctx, backend, "githubrepositoriesofviewer", true, 10*time.Minute),
-		GitHubRepositoryNodeWithAttributesCache: newTypedCache[github.RepositoryNodeWithAttributes](ctx, backend, "githubrepositorynodewithattributes", true, 10*time.Minute),
-		GitHubRepositoryBranchCommitCache:       newTypedCache[github.RepositoryBranchCommit](ctx, backend, "githubrepositorybranchcommit", true, 10*time.Minute),
-		GitHubRepositoryContributorCache:        newTypedCache[github.RepositoryContributor](ctx, backend, "githubrepositorycontributor", true, 20*time.Minute),
-		GitHubFileCache:                         newTypedCache[github.GitHubFile](ctx, backend, "githubfile", true, 20*time.Minute),
-		GitHubRepositoryTreeCommit:              newTypedCache[github.RepositoryTreeCommit](ctx, backend, "githubrepositorytreecommit", true, 20*time.Minute),
+		backend:                                 backend,
+		ConfigValueCache:                        newTypedCache[ConfigurationValue](ctx, backend, "config", cache.ScopePublic, true, 5*time.Minute),
+		GitHubRepositoriesOfViewerCache:         newTypedCache[github.GitHubRepositoriesOfViewer](ctx, backend, "githubrepositoriesofviewer", cache.ScopeToken, true, 10*time.Minute),
+		GitHubRepositoryNodeWithAttributesCache: newTypedCache[github.RepositoryNodeWithAttributes](ctx, backend, "githubrepositorynodewithattributes", cache.ScopeConnection, true, 10*time.Minute),
+		GitHubRepositoryBranchCommitCache:       newTypedCache[github.RepositoryBranchCommit](ctx, backend, "githubrepositorybranchcommit", cache.ScopeConnection, true, 10*time.Minute),
+		GitHubRepositoryContributorCache:        newTypedCache[github.RepositoryContributor](ctx, backend, "githubrepositorycontributor", cache.ScopeConnection, true, 20*time.Minute),
+		GitHubFileCache:                         cache.NewTypedCache[github.GitHubFile](ctx, backend, "githubfile", cache.ScopeConnection, true, commitAddressed(20*time.Minute)),
+		GitHubRepositoryTreeCommit:              cache.NewTypedCache[github.RepositoryTreeCommit](ctx, backend, "githubrepositorytreecommit", cache.ScopeConnection, true, commitAddressed(20*time.Minute)),
+		GitHubRepositoryRefsCache:               newTypedCache[github.RepositoryRefs](ctx, backend, "githubrepositoryrefs", cache.ScopeConnection, true, 10*time.Minute),
+		GitHubRepositoryCommitHistoryCache:      newTypedCache[github.RepositoryCommitHistory](ctx, backend, "githubrepositorycommithistory", cache.ScopeConnection, true, 10*time.Minute),
+		GitHubRepositoryBlameCache:              newTypedCache[github.RepositoryBlame](ctx, backend, "githubrepositoryblame", cache.ScopeConnection, true, 20*time.Minute),
+		RevalidationCache:                       newTypedCache[common.RevalidationEntry](ctx, backend, "revalidation", cache.ScopePublic, true, 24*time.Hour),
+		RepositoryVisibilityCache:               newTypedCache[RepositoryVisibility](ctx, backend, "repositoryvisibility", cache.ScopePublic, true, 10*time.Minute),
 	}
 }
 
+// Caches lists the typed caches of the facade
+func (f *CacheFacade) Caches() []cache.Info {
+	var infos []cache.Info
+	value := reflect.ValueOf(f).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if !value.Type().Field(i).IsExported() {
+			continue
+		}
+		if typed, ok := value.Field(i).Interface().(interface{ Info() cache.Info }); ok {
+			infos = append(infos, typed.Info())
+		}
+	}
+	return infos
+}
+
+// Admin returns the backend for the administration, false if the backend does not support it
+func (f *CacheFacade) Admin() (cache.AdminBackend, bool) {
+	admin, ok := f.backend.(cache.AdminBackend)
+	return admin, ok
+}
+
 // func NewCacheFacade(ctx context.Context, backend cache.CacheBackend) *CacheFacade {
 // 	return &CacheFacade{
 // 		ConfigValueCache: cache.NewTypedCache[ConfigurationValue](
@@ -3,3 +3,9 @@ package cachable
 type ConfigurationValue struct {
 	Value string `json:"value"`
 }
+
+// RepositoryVisibility is learned from the answers of the providers, it decides whether the
+// cached data of a repository is shared by the users of a connection
+type RepositoryVisibility struct {
+	Private bool `json:"private"`
+}
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// The administration lists, inspects and deletes the entries of both levels, the second level is
+// the store, i.e. Redis or the file. Patterns use the glob syntax of Redis: * matches any text, ? a single character and [abc] a set of characters.
+
+// KeyInfo describes an entry for the administration
+type KeyInfo struct {
+	Key     string        `json:"key"`
+	InRAM   bool          `json:"ram"`
+	InStore bool          `json:"store"`         // In Redis or on disk
+	Size    int64         `json:"size"`          // Stored size in bytes, compressed values are smaller than their JSON
+	TTL     time.Duration `json:"ttl,omitempty"` // Remaining time in the store, 0 without expiry
+}
+
+// LayerStats counts the entries and their size of one level
+type LayerStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// AdminBackend is implemented by backends which support the administration
+type AdminBackend interface {
+	// Keys returns the entries matching the pattern, at most limit entries if limit is positive
+	Keys(pattern string, limit int) ([]KeyInfo, error)
+	// Stats counts the entries matching the pattern per level
+	Stats(pattern string) (ram LayerStats, store LayerStats, err error)
+	// Lookup returns the entry and its JSON value, nil if the key is unknown
+	Lookup(key string) (*KeyInfo, []byte, error)
+	// Delete removes the keys from all levels and returns how many existed
+	Delete(keys ...string) (int, error)
+}
+
+// ErrInvalidPattern is returned for an empty pattern, flushing everything needs "*"
+var ErrInvalidPattern = errors.New("invalid key pattern")
+
+// globRegexp converts a glob pattern of Redis into a regular expression
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, ErrInvalidPattern
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, ErrInvalidPattern
+			}
+			b.WriteString(pattern[i : i+end+1])
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// EscapeGlob escapes the special characters of a glob pattern, e.g. in a repository name
+func EscapeGlob(s string) string {
+	var b strings.Builder
+	for _, ch := range s {
+		if strings.ContainsRune(`*?[]\`, ch) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// ramKeys returns the indexed keys matching re, entries which left the RAM are removed from the index
+func (c *MultiLevelCache) ramKeys(re *regexp.Regexp) map[string]int64 {
+	c.indexMutex.Lock()
+	candidates := make([]ramEntry, 0)
+	for _, entry := range c.index {
+		if re.MatchString(entry.key) {
+			candidates = append(candidates, entry)
+		}
+	}
+	c.indexMutex.Unlock()
+
+	keys := make(map[string]int64, len(candidates))
+	for _, entry := range candidates {
+		if _, found := c.ram.Get(entry.key); found {
+			keys[entry.key] = entry.size
+		} else {
+			hash, _ := z.KeyToHash(entry.key)
+			c.indexMutex.Lock()
+			delete(c.index, hash)
+			c.indexMutex.Unlock()
+		}
+	}
+	return keys
+}
+
+// storeKeys returns the keys of the store matching pattern
+func (c *MultiLevelCache) storeKeys(pattern string, limit int) (map[string]int64, error) {
+	if c.store == nil {
+		return map[string]int64{}, nil
+	}
+	return c.store.Keys(c.ctx, pattern, limit)
+}
+
+func (c *MultiLevelCache) Keys(pattern string, limit int) ([]KeyInfo, error) {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]*KeyInfo)
+	for key, size := range c.ramKeys(re) {
+		infos[key] = &KeyInfo{Key: key, InRAM: true, Size: size}
+	}
+	storeKeys, err := c.storeKeys(pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	for key, size := range storeKeys {
+		if info, ok := infos[key]; ok {
+			info.InStore = true
+		} else {
+			infos[key] = &KeyInfo{Key: key, InStore: true, Size: size}
+		}
+	}
+
+	result := make([]KeyInfo, 0, len(infos))
+	for _, info := range infos {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (c *MultiLevelCache) Stats(pattern string) (LayerStats, LayerStats, error) {
+	var ram, persisted LayerStats
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return ram, persisted, err
+	}
+	for _, size := range c.ramKeys(re) {
+		ram.Entries++
+		ram.Bytes += size
+	}
+	storeKeys, err := c.storeKeys(pattern, 0)
+	if err != nil {
+		return ram, persisted, err
+	}
+	for _, size := range storeKeys {
+		persisted.Entries++
+		persisted.Bytes += size
+	}
+	return ram, persisted, nil
+}
+
+func (c *MultiLevelCache) Lookup(key string) (*KeyInfo, []byte, error) {
+	info := &KeyInfo{Key: key}
+	var value []byte
+	if val, found := c.ram.Get(key); found {
+		if bytes, ok := val.([]byte); ok {
+			info.InRAM, info.Size, value = true, int64(len(bytes)), bytes
+		}
+	}
+	if c.store != nil {
+		stored, ttl, found, err := c.store.Get(c.ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if found {
+			info.InStore, info.TTL = true, ttl
+			if value == nil {
+				info.Size, value = int64(len(stored)), stored
+			}
+		}
+	}
+	if !info.InRAM && !info.InStore {
+		return nil, nil, nil
+	}
+	value, err := decodeValue(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, value, nil
+}
+
+func (c *MultiLevelCache) Delete(keys ...string) (int, error) {
+	deleted := 0
+	for _, key := range keys {
+		if _, found := c.ram.Get(key); found {
+			deleted++
+		}
+		c.deleteRAM(key)
+	}
+	if c.store == nil || len(keys) == 0 {
+		return deleted, nil
+	}
+	// Keys in both levels are counted once
+	inStore, err := c.store.Delete(c.ctx, keys...)
+	c.announce(opDelete, keys...)
+	if err != nil {
+		return deleted, err
+	}
+	if inStore > deleted {
+		deleted = inStore
+	}
+	return deleted, nil
+}
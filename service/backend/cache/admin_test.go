@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdminRAM(t *testing.T) {
+	c, err := newRAMCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"file:c:1:content:github:octocat:hello:README", "file:c:1:content:github:octocat:other:README", "refs:t:ab:refs:github:octocat:hello"} {
+		if err := c.Set(key, "value", false, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.ram.Wait()
+
+	keys, err := c.Keys("*:octocat:hello*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Key != "file:c:1:content:github:octocat:hello:README" || !keys[0].InRAM || keys[0].Size != int64(len(`"value"`)) {
+		t.Fatalf("keys = %+v", keys)
+	}
+
+	ram, store, err := c.Stats("file:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ram.Entries != 2 || ram.Bytes != 14 || store.Entries != 0 {
+		t.Errorf("stats = %+v, %+v", ram, store)
+	}
+
+	info, value, err := c.Lookup("refs:t:ab:refs:github:octocat:hello")
+	if err != nil || info == nil || string(value) != `"value"` {
+		t.Fatalf("lookup = %+v, %s, %v", info, value, err)
+	}
+
+	deleted, err := c.Delete("refs:t:ab:refs:github:octocat:hello", "missing")
+	if err != nil || deleted != 1 {
+		t.Fatalf("deleted = %d, %v", deleted, err)
+	}
+	if info, _, _ := c.Lookup("refs:t:ab:refs:github:octocat:hello"); info != nil {
+		t.Errorf("key still present: %+v", info)
+	}
+	if keys, _ := c.Keys("refs:*", 0); len(keys) != 0 {
+		t.Errorf("index still contains %+v", keys)
+	}
+}
+
+// A set which ristretto does not take must not stay in the index
+func TestDroppedSetIsNotIndexed(t *testing.T) {
+	c, err := newRAMCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.setRAM("file:c:1:content:github:octocat:hello:README", []byte(`"value"`), -time.Second)
+	c.ram.Wait()
+	c.indexMutex.Lock()
+	defer c.indexMutex.Unlock()
+	if len(c.index) != 0 {
+		t.Errorf("index contains %+v", c.index)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*:octo\\*cat:*", "x:octo*cat:y", true},
+		{"*:octo\\*cat:*", "x:octoXcat:y", false},
+		{EscapeGlob("a[1]") + "*", "a[1]:b", true},
+		{"file:?:1", "file:c:1", true},
+		{"file:[ct]:1", "file:t:1", true},
+		{"file:[ct]:1", "file:x:1", false},
+	}
+	for _, tt := range tests {
+		re, err := globRegexp(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := re.MatchString(tt.key); got != tt.want {
+			t.Errorf("%s matches %s = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+	if _, err := globRegexp(""); err == nil {
+		t.Error("empty pattern accepted")
+	}
+}
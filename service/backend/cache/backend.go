@@ -1,6 +1,9 @@
 package cache
 
+import "time"
+
+// CacheBackend stores JSON values. Set receives the TTL of the entry, 0 means no expiry.
 type CacheBackend interface {
 	Get(key string, dest interface{}) (bool, error)
-	Set(key string, val interface{}, persist bool) error
+	Set(key string, val interface{}, persist bool, ttl time.Duration) error
 }
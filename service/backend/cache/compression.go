@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Values above CompressThreshold are stored compressed with zstd in RAM and in Redis, if that
+// saves space. A compressed value starts with the magic number of zstd, which JSON never does,
+// so older uncompressed entries can still be read. Values which are larger than the maximum
+// size of their prefix after compression are not cached.
+
+// CompressThreshold is the size of the JSON value in bytes from which it's compressed, it can be
+// set with CACHE_COMPRESS_THRESHOLD, 0 disables the compression
+var CompressThreshold = loadCompressThreshold()
+
+// MaxEntrySizes limits the stored size of an entry per prefix of the key, the entry "" applies to
+// all other prefixes and 0 means unlimited. It can be set with CACHE_MAX_ENTRY_SIZE, e.g.
+// "8MiB,githubfile=2MiB".
+var MaxEntrySizes = loadMaxEntrySizes()
+
+// maxDecodedSize protects against values which decompress to more than fits into the RAM level
+const maxDecodedSize = 256 << 20
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var (
+	encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecodedSize))
+)
+
+var (
+	compressionRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "githubclone_cache_compression_ratio",
+		Help:    "Stored size of compressed cache values relative to their JSON size",
+		Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.8, 1},
+	}, []string{"prefix"})
+	rejectedEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_rejected_entries_total",
+		Help: "Cache values which were not stored because they exceed the maximum entry size of their prefix",
+	}, []string{"prefix"})
+)
+
+func loadCompressThreshold() int {
+	threshold := 4 << 10
+	if value := os.Getenv("CACHE_COMPRESS_THRESHOLD"); value != "" {
+		if size, err := parseSize(value); err == nil {
+			threshold = size
+		} else {
+			log.Printf("Invalid CACHE_COMPRESS_THRESHOLD %q, using %d: %v", value, threshold, err)
+		}
+	}
+	return threshold
+}
+
+func loadMaxEntrySizes() map[string]int {
+	sizes := map[string]int{"": 8 << 20}
+	value := os.Getenv("CACHE_MAX_ENTRY_SIZE")
+	if value == "" {
+		return sizes
+	}
+	for _, item := range strings.Split(value, ",") {
+		prefix, size, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			prefix, size = "", prefix
+		}
+		parsed, err := parseSize(size)
+		if err != nil {
+			log.Printf("Invalid CACHE_MAX_ENTRY_SIZE item %q is ignored: %v", item, err)
+			continue
+		}
+		sizes[prefix] = parsed
+	}
+	return sizes
+}
+
+// parseSize reads a size in bytes with the optional units KiB, MiB and GiB
+func parseSize(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	factor := 1
+	for unit, f := range map[string]int{"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30} {
+		if strings.HasSuffix(value, unit) {
+			value, factor = strings.TrimSpace(strings.TrimSuffix(value, unit)), f
+			break
+		}
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("size %q is not a number of bytes", value)
+	}
+	return size * factor, nil
+}
+
+// keyPrefix returns the prefix of the TypedCache of a key
+func keyPrefix(key string) string {
+	prefix, _, _ := strings.Cut(key, ":")
+	return prefix
+}
+
+// maxEntrySize returns the limit for a prefix, 0 means unlimited
+func maxEntrySize(prefix string) int {
+	if size, ok := MaxEntrySizes[prefix]; ok {
+		return size
+	}
+	return MaxEntrySizes[""]
+}
+
+// encodeValue returns the stored form of a JSON value
+func encodeValue(prefix string, raw []byte) []byte {
+	if CompressThreshold <= 0 || len(raw) < CompressThreshold {
+		return raw
+	}
+	compressed := encoder.EncodeAll(raw, make([]byte, 0, len(raw)/4))
+	if len(compressed) >= len(raw) {
+		return raw
+	}
+	compressionRatio.WithLabelValues(prefix).Observe(float64(len(compressed)) / float64(len(raw)))
+	return compressed
+}
+
+// decodeValue returns the JSON value of a stored value
+func decodeValue(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, zstdMagic) {
+		return stored, nil
+	}
+	raw, err := decoder.DecodeAll(stored, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cache value cannot be decompressed: %w", err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressedValues(t *testing.T) {
+	c, err := newRAMCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := func(key string) []byte {
+		c.ram.Wait()
+		val, found := c.ram.Get(key)
+		if !found {
+			return nil
+		}
+		return val.([]byte)
+	}
+
+	large := strings.Repeat("compressible content ", 1000)
+	if err := c.Set("githubfile:large", large, false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value := stored("githubfile:large"); !bytes.HasPrefix(value, zstdMagic) || len(value) >= len(large) {
+		t.Fatalf("large value is not compressed, %d bytes", len(value))
+	}
+	var got string
+	if found, err := c.Get("githubfile:large", &got); err != nil || !found || got != large {
+		t.Fatalf("found %v, err %v, equal %v", found, err, got == large)
+	}
+
+	if err := c.Set("config:small", "small", false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value := stored("config:small"); string(value) != `"small"` {
+		t.Errorf("small value is stored as %q", value)
+	}
+
+	// A value above the limit of its prefix removes the older entry
+	defer func(sizes map[string]int) { MaxEntrySizes = sizes }(MaxEntrySizes)
+	MaxEntrySizes = map[string]int{"": 0, "githubfile": 64}
+	if err := c.Set("githubfile:large", strings.Repeat("x", 100), false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value := stored("githubfile:large"); value != nil {
+		t.Errorf("value above the limit is stored, %d bytes", len(value))
+	}
+	if err := c.Set("config:large", strings.Repeat("x", 100), false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value := stored("config:large"); value == nil {
+		t.Error("value without limit is not stored")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int{"0": 0, "4096": 4096, "512KiB": 512 << 10, "8 MiB": 8 << 20, "1GiB": 1 << 30}
+	for value, want := range tests {
+		if got, err := parseSize(value); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "-1", "8MB", "lots"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("parseSize(%q) accepted", value)
+		}
+	}
+}
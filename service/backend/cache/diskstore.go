@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// diskStore keeps the entries in a bbolt file, they survive a restart without Redis. The file
+// belongs to one instance. Every value is prefixed with its expiry as unix time in nanoseconds,
+// 0 means no expiry. Expired entries are skipped when read and removed periodically.
+
+var diskBucket = []byte("cache")
+
+// diskPurgeInterval is the time between two removals of the expired entries
+const diskPurgeInterval = 10 * time.Minute
+
+type diskStore struct {
+	db     *bolt.DB
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+func newDiskStore(path string) (*diskStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cache file %s cannot be opened: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &diskStore{db: db, cancel: cancel}
+	s.done.Add(1)
+	go s.purgeExpired(ctx)
+	return s, nil
+}
+
+// decodeDiskValue splits a stored value into expiry and value, expired is true after the expiry
+func decodeDiskValue(data []byte, now time.Time) (value []byte, ttl time.Duration, expired bool) {
+	if len(data) < 8 {
+		return nil, 0, true
+	}
+	expiry := int64(binary.BigEndian.Uint64(data[:8]))
+	if expiry != 0 {
+		ttl = time.Unix(0, expiry).Sub(now)
+		if ttl <= 0 {
+			return nil, 0, true
+		}
+	}
+	return data[8:], ttl, false
+}
+
+func (s *diskStore) Get(_ context.Context, key string) ([]byte, time.Duration, bool, error) {
+	var value []byte
+	var ttl time.Duration
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(diskBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		v, t, expired := decodeDiskValue(data, time.Now())
+		if expired {
+			return nil
+		}
+		// The data is only valid during the transaction
+		value, ttl, found = append([]byte(nil), v...), t, true
+		return nil
+	})
+	return value, ttl, found, err
+}
+
+func (s *diskStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	data := make([]byte, 8, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	data = append(data, value...)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *diskStore) Delete(_ context.Context, keys ...string) (int, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskBucket)
+		for _, key := range keys {
+			if data := bucket.Get([]byte(key)); data != nil {
+				if _, _, expired := decodeDiskValue(data, time.Now()); !expired {
+					deleted++
+				}
+				if err := bucket.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (s *diskStore) Keys(_ context.Context, pattern string, limit int) (map[string]int64, error) {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]int64)
+	now := time.Now()
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskBucket).ForEach(func(k, data []byte) error {
+			if limit > 0 && len(keys) >= limit {
+				return nil
+			}
+			value, _, expired := decodeDiskValue(data, now)
+			if !expired && re.Match(k) {
+				keys[string(k)] = int64(len(value))
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// purgeExpired removes the expired entries periodically
+func (s *diskStore) purgeExpired(ctx context.Context) {
+	defer s.done.Done()
+	ticker := time.NewTicker(diskPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.purge(time.Now()); err != nil {
+				log.Printf("Expired cache entries cannot be removed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *diskStore) purge(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskBucket)
+		// Deleting while iterating skips entries, the keys are collected first
+		var expired [][]byte
+		err := bucket.ForEach(func(k, data []byte) error {
+			if _, _, isExpired := decodeDiskValue(data, now); isExpired {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *diskStore) Close() error {
+	s.cancel()
+	s.done.Wait()
+	return s.db.Close()
+}
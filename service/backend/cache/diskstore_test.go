@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := New(Config{Backend: BackendDisk, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("refs:c:1:refs:github:octocat:hello", "kept", true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("refs:c:1:refs:github:octocat:other", "expires", true, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("refs:c:1:refs:github:octocat:ram", "ram", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// The persisted entries survive the restart, the expired one and the one in RAM don't
+	c, err = New(Config{Backend: BackendDisk, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var value string
+	if found, err := c.Get("refs:c:1:refs:github:octocat:hello", &value); err != nil || !found || value != "kept" {
+		t.Errorf("get = %v, %q, %v", found, value, err)
+	}
+	for _, key := range []string{"refs:c:1:refs:github:octocat:other", "refs:c:1:refs:github:octocat:ram"} {
+		if found, err := c.Get(key, &value); err != nil || found {
+			t.Errorf("get %s = %v, %v", key, found, err)
+		}
+	}
+
+	keys, err := c.store.Keys(c.ctx, "refs:*:octocat:*", 0)
+	if err != nil || len(keys) != 1 || keys["refs:c:1:refs:github:octocat:hello"] != int64(len(`"kept"`)) {
+		t.Errorf("keys = %v, %v", keys, err)
+	}
+	if err := c.store.(*diskStore).purge(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := c.Delete("refs:c:1:refs:github:octocat:hello", "refs:c:1:refs:github:octocat:other")
+	if err != nil || deleted != 1 {
+		t.Errorf("deleted = %d, %v", deleted, err)
+	}
+	if found, _ := c.Get("refs:c:1:refs:github:octocat:hello", &value); found {
+		t.Error("deleted key is still found")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c, err := New(Config{Backend: BackendMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Redis() != nil {
+		t.Error("memory cache has a Redis client")
+	}
+	if err := c.Set("refs:key", "value", true, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	c.ram.Wait()
+	var value string
+	if found, err := c.Get("refs:key", &value); err != nil || !found || value != "value" {
+		t.Errorf("get = %v, %q, %v", found, value, err)
+	}
+	if _, err := New(Config{Backend: "unknown"}); err == nil {
+		t.Error("unknown backend is accepted")
+	}
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every instance keeps its own RAM level. Persisted writes and deletions are published on a
+// Redis channel, the other instances evict the keys from their RAM level and read them from
+// Redis again. The events of an instance are numbered, a gap means lost events and the receiver
+// clears its RAM level. The same happens after the subscription was interrupted.
+
+const invalidationChannel = "githubclone:cache:invalidation"
+
+// invalidationQueue limits the events waiting for publication, further events are dropped
+const invalidationQueue = 1024
+
+// instanceIdle is the time after which the sequence number of a silent instance is forgotten.
+// Every restart has a new instance id, stopped instances must not stay in lastSeq.
+const instanceIdle = time.Hour
+
+type invalidationOp string
+
+const (
+	opSet    invalidationOp = "set"
+	opDelete invalidationOp = "delete"
+)
+
+type invalidationEvent struct {
+	Instance string         `json:"instance"`
+	Seq      uint64         `json:"seq"`
+	Op       invalidationOp `json:"op"`
+	Keys     []string       `json:"keys"`
+}
+
+// invalidation publishes the events of this instance and applies those of the others
+type invalidation struct {
+	instance string
+	queue    chan invalidationEvent
+	cancel   context.CancelFunc
+	done     sync.WaitGroup
+
+	dropped atomic.Uint64 // events which did not fit into the queue, they are counted in seq
+	lastSeq map[string]instanceSeq
+	swept   time.Time // last removal of idle instances from lastSeq
+}
+
+// instanceSeq is the last sequence number of an instance and when it was received
+type instanceSeq struct {
+	seq    uint64
+	seenAt time.Time
+}
+
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().Format("150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
+
+// startInvalidation starts publisher and subscriber, it needs Redis
+func (c *MultiLevelCache) startInvalidation() {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.invalidation = &invalidation{
+		instance: newInstanceID(),
+		queue:    make(chan invalidationEvent, invalidationQueue),
+		cancel:   cancel,
+		lastSeq:  make(map[string]instanceSeq),
+	}
+	c.invalidation.done.Add(2)
+	go c.publishInvalidations(ctx)
+	go c.receiveInvalidations(ctx)
+}
+
+func (c *MultiLevelCache) stopInvalidation() {
+	if c.invalidation == nil {
+		return
+	}
+	c.invalidation.cancel()
+	c.invalidation.done.Wait()
+}
+
+// announce queues an event, it does not wait for Redis
+func (c *MultiLevelCache) announce(op invalidationOp, keys ...string) {
+	if c.invalidation == nil || len(keys) == 0 {
+		return
+	}
+	select {
+	case c.invalidation.queue <- invalidationEvent{Op: op, Keys: keys}:
+	default:
+		// The sequence number is skipped, the other instances notice the gap
+		log.Printf("Cache invalidation queue is full, dropping event for %d keys", len(keys))
+		c.invalidation.dropped.Add(1)
+	}
+}
+
+// publishInvalidations numbers the events in the order of the queue and publishes them
+func (c *MultiLevelCache) publishInvalidations(ctx context.Context) {
+	defer c.invalidation.done.Done()
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-c.invalidation.queue:
+			seq += c.invalidation.dropped.Swap(0) + 1
+			event.Instance, event.Seq = c.invalidation.instance, seq
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Cache invalidation cannot be encoded: %v", err)
+				continue
+			}
+			if err := c.redis.Publish(ctx, invalidationChannel, payload).Err(); err != nil && ctx.Err() == nil {
+				log.Printf("Cache invalidation cannot be published: %v", err)
+			}
+		}
+	}
+}
+
+// receiveInvalidations applies the events of the other instances. go-redis reconnects and
+// subscribes again by itself, every subscription after the first one follows an interruption.
+func (c *MultiLevelCache) receiveInvalidations(ctx context.Context) {
+	defer c.invalidation.done.Done()
+	pubsub := c.redis.Subscribe(ctx, invalidationChannel)
+	defer pubsub.Close()
+
+	subscribed := false
+	interrupted := false
+	backoff := 100 * time.Millisecond
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if !interrupted {
+				log.Printf("Cache invalidation subscription interrupted: %v", err)
+			}
+			interrupted = true
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, 10*time.Second)
+			continue
+		}
+		backoff = 100 * time.Millisecond
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+			if subscribed || interrupted {
+				log.Printf("Cache invalidation subscription restored, clearing the RAM level")
+				c.clearRAM()
+				// The RAM level is empty, the sequences start anew
+				clear(c.invalidation.lastSeq)
+			}
+			subscribed, interrupted = true, false
+		case *redis.Message:
+			var event invalidationEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("Invalid cache invalidation event: %v", err)
+				continue
+			}
+			c.applyInvalidation(event)
+		}
+	}
+}
+
+// applyInvalidation evicts the keys of an event of another instance. Missing events of an
+// instance clear the RAM level, repeated or older events are ignored.
+func (c *MultiLevelCache) applyInvalidation(event invalidationEvent) {
+	if event.Instance == c.invalidation.instance {
+		return
+	}
+	now := time.Now()
+	c.forgetIdleInstances(now)
+	last, known := c.invalidation.lastSeq[event.Instance]
+	if known && event.Seq <= last.seq {
+		return
+	}
+	c.invalidation.lastSeq[event.Instance] = instanceSeq{seq: event.Seq, seenAt: now}
+	if known && event.Seq > last.seq+1 {
+		log.Printf("Missed %d cache invalidations of instance %s, clearing the RAM level", event.Seq-last.seq-1, event.Instance)
+		c.clearRAM()
+		return
+	}
+	for _, key := range event.Keys {
+		c.deleteRAM(key)
+	}
+}
+
+// forgetIdleInstances removes the instances without events for instanceIdle, at most once per
+// instanceIdle
+func (c *MultiLevelCache) forgetIdleInstances(now time.Time) {
+	if now.Sub(c.invalidation.swept) < instanceIdle {
+		return
+	}
+	c.invalidation.swept = now
+	for instance, last := range c.invalidation.lastSeq {
+		if now.Sub(last.seenAt) >= instanceIdle {
+			delete(c.invalidation.lastSeq, instance)
+		}
+	}
+}
+
+// deleteRAM removes a key from the RAM level and the index
+func (c *MultiLevelCache) deleteRAM(key string) {
+	c.ram.Del(key)
+	hash, _ := z.KeyToHash(key)
+	c.indexMutex.Lock()
+	delete(c.index, hash)
+	c.indexMutex.Unlock()
+}
+
+// clearRAM removes all entries of the RAM level, they are read from Redis again. The index is
+// emptied by the eviction callback.
+func (c *MultiLevelCache) clearRAM() {
+	c.clearing.Store(true)
+	defer c.clearing.Store(false)
+	c.ram.Clear()
+}
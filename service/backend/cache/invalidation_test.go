@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyInvalidation(t *testing.T) {
+	c, err := newRAMCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.invalidation = &invalidation{instance: "self", lastSeq: make(map[string]instanceSeq)}
+	set := func(keys ...string) {
+		for _, key := range keys {
+			if err := c.Set(key, "value", false, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.ram.Wait()
+	}
+	inRAM := func(key string) bool {
+		_, found := c.ram.Get(key)
+		return found
+	}
+
+	set("a", "b", "c")
+	c.applyInvalidation(invalidationEvent{Instance: "other", Seq: 1, Op: opSet, Keys: []string{"a"}})
+	c.ram.Wait()
+	if inRAM("a") || !inRAM("b") {
+		t.Fatal("event not applied")
+	}
+
+	// Own, repeated and older events are ignored
+	c.applyInvalidation(invalidationEvent{Instance: "self", Seq: 1, Op: opDelete, Keys: []string{"b"}})
+	c.applyInvalidation(invalidationEvent{Instance: "other", Seq: 1, Op: opDelete, Keys: []string{"b"}})
+	c.ram.Wait()
+	if !inRAM("b") {
+		t.Fatal("ignored event applied")
+	}
+
+	// A gap clears the RAM level
+	c.applyInvalidation(invalidationEvent{Instance: "other", Seq: 3, Op: opDelete, Keys: []string{"b"}})
+	c.ram.Wait()
+	if inRAM("b") || inRAM("c") {
+		t.Fatal("RAM level not cleared after a gap")
+	}
+	if keys, _ := c.Keys("*", 0); len(keys) != 0 {
+		t.Errorf("index not cleared: %+v", keys)
+	}
+	set("d")
+	c.applyInvalidation(invalidationEvent{Instance: "other", Seq: 4, Op: opDelete, Keys: []string{"x"}})
+	c.ram.Wait()
+	if !inRAM("d") {
+		t.Error("RAM level cleared without a gap")
+	}
+}
+
+// Instances without events are forgotten, every restart publishes with a new instance id
+func TestIdleInstancesAreForgotten(t *testing.T) {
+	c, err := newRAMCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.invalidation = &invalidation{instance: "self", lastSeq: make(map[string]instanceSeq)}
+	c.applyInvalidation(invalidationEvent{Instance: "stopped", Seq: 1, Op: opSet, Keys: []string{"a"}})
+	c.applyInvalidation(invalidationEvent{Instance: "running", Seq: 1, Op: opSet, Keys: []string{"a"}})
+
+	// Later the stopped instance was silent for instanceIdle, the running one was not
+	c.invalidation.lastSeq["stopped"] = instanceSeq{seq: 1, seenAt: time.Now().Add(-instanceIdle)}
+	c.invalidation.swept = time.Now().Add(-instanceIdle)
+	c.applyInvalidation(invalidationEvent{Instance: "running", Seq: 2, Op: opSet, Keys: []string{"a"}})
+	if _, found := c.invalidation.lastSeq["stopped"]; found || len(c.invalidation.lastSeq) != 1 {
+		t.Errorf("lastSeq = %+v", c.invalidation.lastSeq)
+	}
+}
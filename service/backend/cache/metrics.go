@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics are labeled by the prefix of the TypedCache and the level, "ram" or the backend of
+// the store ("redis" or "disk"). Every read starts in RAM, so the RAM hits and misses add up to
+// all reads of a prefix. Stale reads are counted per prefix, they don't depend on the level.
+
+const layerRAM = "ram"
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_hits_total",
+		Help: "Cache reads which found the key, per prefix and level",
+	}, []string{"prefix", "layer"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_misses_total",
+		Help: "Cache reads which did not find the key, per prefix and level",
+	}, []string{"prefix", "layer"})
+	cacheStale = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_stale_total",
+		Help: "Cache reads which returned an entry after its TTL for revalidation",
+	}, []string{"prefix"})
+	cacheSets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_sets_total",
+		Help: "Values written to the cache, per prefix and level",
+	}, []string{"prefix", "layer"})
+	cacheErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_errors_total",
+		Help: "Failed cache reads and writes, per prefix, level and operation",
+	}, []string{"prefix", "layer", "operation"})
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "githubclone_cache_evictions_total",
+		Help: "Entries removed from the RAM level because of its size or their TTL",
+	}, []string{"prefix", "layer"})
+	cacheValueSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "githubclone_cache_value_size_bytes",
+		Help:    "Stored size of the values written to the cache, after compression",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 10), // 256 B to 64 MiB
+	}, []string{"prefix", "layer"})
+)
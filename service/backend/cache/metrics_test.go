@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	c, err := NewMemoryCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	const prefix = "metricstest"
+	var value string
+	if found, _ := c.Get(prefix+":a", &value); found {
+		t.Fatal("unexpected hit")
+	}
+	if err := c.Set(prefix+":a", "value", false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	c.ram.Wait()
+	if found, _ := c.Get(prefix+":a", &value); !found {
+		t.Fatal("missing value")
+	}
+
+	if hits := testutil.ToFloat64(cacheHits.WithLabelValues(prefix, layerRAM)); hits != 1 {
+		t.Errorf("hits = %v", hits)
+	}
+	if misses := testutil.ToFloat64(cacheMisses.WithLabelValues(prefix, layerRAM)); misses != 1 {
+		t.Errorf("misses = %v", misses)
+	}
+	if sets := testutil.ToFloat64(cacheSets.WithLabelValues(prefix, layerRAM)); sets != 1 {
+		t.Errorf("sets = %v", sets)
+	}
+
+	// Clearing the RAM level is no eviction
+	c.clearRAM()
+	if evictions := testutil.ToFloat64(cacheEvictions.WithLabelValues(prefix, layerRAM)); evictions != 0 {
+		t.Errorf("evictions = %v", evictions)
+	}
+}
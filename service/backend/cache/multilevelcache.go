@@ -3,80 +3,251 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
 	"github.com/redis/go-redis/v9"
 )
 
 type MultiLevelCache struct {
-	ram   *ristretto.Cache
-	redis *redis.Client
-	ctx   context.Context
-	ttl   time.Duration
+	ram        *ristretto.Cache
+	store      Store         // nil keeps the entries only in RAM
+	storeLayer string        // Backend of the store for the metrics
+	redis      *redis.Client // set if the store is Redis
+	ctx        context.Context
+
+	// ristretto cannot list its keys, the index maps the hashes of the keys to the keys and sizes
+	index      map[uint64]ramEntry
+	indexMutex sync.Mutex
+	clearing   atomic.Bool // Clear evicts all entries, they are not counted as evictions
+
+	invalidation *invalidation // nil without Redis
+}
+
+type ramEntry struct {
+	key  string
+	size int64
+}
+
+// The backends which can be selected with Config.Backend
+const (
+	BackendRedis  = "redis"  // RAM and Redis, the default
+	BackendMemory = "memory" // Only RAM, e.g. for development and tests
+	BackendDisk   = "disk"   // RAM and a file, survives a restart without Redis
+)
+
+// Config selects the backend of the cache
+type Config struct {
+	Backend   string // One of the Backend constants, empty is BackendRedis
+	RedisAddr string // Address of Redis for BackendRedis
+	Path      string // File for BackendDisk
+}
+
+// New creates the cache for the configured backend
+func New(config Config) (*MultiLevelCache, error) {
+	switch config.Backend {
+	case "", BackendRedis:
+		return NewMultiLevelCache(config.RedisAddr)
+	case BackendMemory:
+		return NewMemoryCache()
+	case BackendDisk:
+		return NewDiskCache(config.Path)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", config.Backend)
+	}
+}
+
+// NewMultiLevelCache keeps the entries in RAM and Redis
+func NewMultiLevelCache(redisAddr string) (*MultiLevelCache, error) {
+	c, err := newRAMCache()
+	if err != nil {
+		return nil, err
+	}
+	c.redis = redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	c.store, c.storeLayer = &redisStore{client: c.redis}, BackendRedis
+	c.startInvalidation()
+	return c, nil
+}
+
+// NewMemoryCache keeps the entries only in RAM, persisted entries are lost on restart
+func NewMemoryCache() (*MultiLevelCache, error) {
+	return newRAMCache()
 }
 
-func NewMultiLevelCache(redisAddr string, ttl time.Duration) (*MultiLevelCache, error) {
+// NewDiskCache keeps the entries in RAM and persisted entries in a file
+func NewDiskCache(path string) (*MultiLevelCache, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the disk cache needs a file")
+	}
+	store, err := newDiskStore(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newRAMCache()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	c.store, c.storeLayer = store, BackendDisk
+	return c, nil
+}
+
+func newRAMCache() (*MultiLevelCache, error) {
+	c := &MultiLevelCache{
+		ctx:   context.Background(),
+		index: make(map[uint64]ramEntry),
+	}
 	ramCache, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e4,
 		MaxCost:     1 << 29, // 512MB
 		BufferItems: 64,
+		OnEvict:     c.evicted,
+		OnReject:    c.unindex,
 	})
 	if err != nil {
 		return nil, err
 	}
+	c.ram = ramCache
+	return c, nil
+}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
+func (c *MultiLevelCache) unindex(item *ristretto.Item) {
+	c.indexMutex.Lock()
+	delete(c.index, item.Key)
+	c.indexMutex.Unlock()
+}
 
-	return &MultiLevelCache{
-		ram:   ramCache,
-		redis: redisClient,
-		ctx:   context.Background(),
-		ttl:   ttl,
-	}, nil
+// evicted removes an entry which ristretto dropped because of the size of the RAM level or its
+// TTL from the index and counts it
+func (c *MultiLevelCache) evicted(item *ristretto.Item) {
+	c.indexMutex.Lock()
+	entry, known := c.index[item.Key]
+	delete(c.index, item.Key)
+	c.indexMutex.Unlock()
+	if known && !c.clearing.Load() {
+		cacheEvictions.WithLabelValues(keyPrefix(entry.key), layerRAM).Inc()
+	}
+}
+
+// setRAM stores the value in RAM and in the index. The entry is indexed before the set, so that
+// OnReject and OnEvict of ristretto find it. Sets which ristretto drops, e.g. under contention,
+// call no callback, their entry is removed here.
+func (c *MultiLevelCache) setRAM(key string, val []byte, ttl time.Duration) {
+	hash, _ := z.KeyToHash(key)
+	entry := ramEntry{key: key, size: int64(len(val))}
+	c.indexMutex.Lock()
+	c.index[hash] = entry
+	c.indexMutex.Unlock()
+	if !c.ram.SetWithTTL(key, val, entry.size, ttl) {
+		c.indexMutex.Lock()
+		if c.index[hash] == entry {
+			delete(c.index, hash)
+		}
+		c.indexMutex.Unlock()
+	}
+}
+
+// Redis returns the client of the second level, other state can be shared through it. It's nil
+// for the other backends.
+func (c *MultiLevelCache) Redis() *redis.Client {
+	return c.redis
 }
 
 func (c *MultiLevelCache) Close() error {
-	return c.redis.Close()
+	c.stopInvalidation()
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Close()
 }
 
 func (c *MultiLevelCache) Get(key string, dest interface{}) (bool, error) {
+	prefix := keyPrefix(key)
+
 	// 1. RAM
 	if val, found := c.ram.Get(key); found {
-		bytes, ok := val.([]byte)
+		stored, ok := val.([]byte)
 		if !ok {
 			return false, nil
 		}
-		return true, json.Unmarshal(bytes, dest)
+		cacheHits.WithLabelValues(prefix, layerRAM).Inc()
+		return true, c.unmarshalValue(prefix, layerRAM, stored, dest)
 	}
-
-	// 2. Redis
-	val, err := c.redis.Get(c.ctx, key).Bytes()
-	if err == redis.Nil {
+	cacheMisses.WithLabelValues(prefix, layerRAM).Inc()
+	if c.store == nil {
 		return false, nil
-	} else if err != nil {
+	}
+
+	// 2. Store
+	val, ttl, found, err := c.store.Get(c.ctx, key)
+	if err != nil {
+		cacheErrors.WithLabelValues(prefix, c.storeLayer, "get").Inc()
 		return false, err
 	}
+	if !found {
+		cacheMisses.WithLabelValues(prefix, c.storeLayer).Inc()
+		return false, nil
+	}
+	cacheHits.WithLabelValues(prefix, c.storeLayer).Inc()
+
+	// 3. RAM-Update, the copy expires together with the entry in the store
+	c.setRAM(key, val, ttl)
+	return true, c.unmarshalValue(prefix, c.storeLayer, val, dest)
+}
 
-	// 3. RAM-Update
-	c.ram.Set(key, val, int64(len(val)))
-	return true, json.Unmarshal(val, dest)
+// unmarshalValue decodes a stored value into dest, a value which cannot be decoded is counted as
+// error of the level it was read from
+func (c *MultiLevelCache) unmarshalValue(prefix, layer string, stored []byte, dest interface{}) error {
+	raw, err := decodeValue(stored)
+	if err == nil {
+		err = json.Unmarshal(raw, dest)
+	}
+	if err != nil {
+		cacheErrors.WithLabelValues(prefix, layer, "get").Inc()
+	}
+	return err
 }
 
-func (c *MultiLevelCache) Set(key string, val interface{}, persist bool) error {
-	bytes, err := json.Marshal(val)
+// Set stores the value in RAM and with persist also in the store. The entry expires after ttl,
+// 0 means it is kept until it's evicted. A value above the maximum size of its prefix is not
+// stored, an older entry of the key is removed.
+func (c *MultiLevelCache) Set(key string, val interface{}, persist bool, ttl time.Duration) error {
+	raw, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
+	prefix := keyPrefix(key)
+	stored := encodeValue(prefix, raw)
+	if limit := maxEntrySize(prefix); limit > 0 && len(stored) > limit {
+		rejectedEntries.WithLabelValues(prefix).Inc()
+		if persist {
+			_, err := c.Delete(key)
+			return err
+		}
+		c.deleteRAM(key)
+		return nil
+	}
 
 	// Always RAM
-	c.ram.Set(key, bytes, int64(len(bytes)))
+	c.setRAM(key, stored, ttl)
+	cacheSets.WithLabelValues(prefix, layerRAM).Inc()
+	cacheValueSize.WithLabelValues(prefix, layerRAM).Observe(float64(len(stored)))
 
-	// Optional Redis
-	if persist {
-		return c.redis.Set(c.ctx, key, bytes, c.ttl).Err()
+	// Optional store, the other instances drop their copy in RAM
+	if persist && c.store != nil {
+		if err := c.store.Set(c.ctx, key, stored, ttl); err != nil {
+			cacheErrors.WithLabelValues(prefix, c.storeLayer, "set").Inc()
+			return err
+		}
+		cacheSets.WithLabelValues(prefix, c.storeLayer).Inc()
+		cacheValueSize.WithLabelValues(prefix, c.storeLayer).Observe(float64(len(stored)))
+		c.announce(opSet, key)
 	}
 	return nil
 }
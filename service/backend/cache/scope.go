@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Scope legt fest, wer einen Eintrag eines TypedCache lesen darf
+type Scope int
+
+const (
+	ScopeToken      Scope = iota // Nur das Token, mit dem der Eintrag geladen wurde
+	ScopeConnection              // Alle Benutzer einer Verbindung, wenn der Principal Shared ist, sonst wie ScopeToken
+	ScopePublic                  // Alle, z. B. Konfigurationswerte
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeConnection:
+		return "connection"
+	case ScopePublic:
+		return "public"
+	default:
+		return "token"
+	}
+}
+
+// ErrScopeRequired wird geliefert, wenn ein Cache mit Scope ohne Principal verwendet wird
+var ErrScopeRequired = errors.New("cache entries are scoped, access them with For(principal)")
+
+// Principal ist der Benutzer, für den ein Eintrag gelesen oder geschrieben wird
+type Principal struct {
+	Connection uint
+	Token      string
+	Shared     bool // Die Daten sind für alle Benutzer der Verbindung sichtbar, z. B. ein öffentliches Repository
+}
+
+// View ist der Zugriff eines Principals auf einen TypedCache, die Keys erhalten den Scope als Präfix
+type View[T any] struct {
+	cache *TypedCache[T]
+	scope string // z. B. "t:<hash des tokens>" oder "c:<id der verbindung>"
+}
+
+// For liefert den Zugriff für einen Principal. Bei ScopeConnection wird nur geteilt, wenn der
+// Principal Shared ist, andernfalls sieht jedes Token seine eigenen Einträge.
+func (c *TypedCache[T]) For(p Principal) *View[T] {
+	switch {
+	case c.scope == ScopePublic:
+		return &View[T]{cache: c}
+	case c.scope == ScopeConnection && p.Shared:
+		return &View[T]{cache: c, scope: "c:" + strconv.FormatUint(uint64(p.Connection), 10)}
+	default:
+		sum := sha256.Sum256([]byte(p.Token))
+		return &View[T]{cache: c, scope: "t:" + hex.EncodeToString(sum[:8])}
+	}
+}
+
+func (v *View[T]) scoped(key string) string {
+	if v.scope == "" {
+		return key
+	}
+	return v.scope + ":" + key
+}
+
+// Get lädt ein Objekt im Scope des Principals
+func (v *View[T]) Get(key string) (*T, bool, error) {
+	return v.cache.get(v.scoped(key), key)
+}
+
+// GetStale lädt ein Objekt im Scope des Principals auch nach Ablauf der TTL
+func (v *View[T]) GetStale(key string) (*T, time.Time, bool, error) {
+	return v.cache.getStale(v.scoped(key), key)
+}
+
+// Lookup lädt ein Objekt im Scope des Principals samt Zeitpunkt des Schreibens und Status.
+// Ein Eintrag mit StatusStale wird geliefert und sollte im Hintergrund erneuert werden.
+func (v *View[T]) Lookup(key string) (*T, time.Time, Status, error) {
+	return v.cache.lookup(v.scoped(key), key)
+}
+
+// Set schreibt ein Objekt im Scope des Principals
+func (v *View[T]) Set(key string, val T) error {
+	return v.cache.set(v.scoped(key), key, val)
+}
+
+// SetNegative merkt sich im Scope des Principals, dass der Provider mit status geantwortet hat
+func (v *View[T]) SetNegative(key string, status int, message string) error {
+	return v.cache.setNegative(v.scoped(key), status, message)
+}
+
+// Key liefert den vollständigen Key inklusive Prefix und Scope
+func (v *View[T]) Key(key string) string {
+	return v.cache.buildKey(v.scoped(key))
+}
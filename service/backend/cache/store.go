@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store is the second level of the MultiLevelCache, it keeps the persisted entries beyond the
+// RAM level. The values are passed on as stored, i.e. possibly compressed.
+type Store interface {
+	// Get returns the value and its remaining TTL, 0 means no expiry
+	Get(ctx context.Context, key string) (value []byte, ttl time.Duration, found bool, err error)
+	// Set stores the value, it expires after ttl, 0 means no expiry
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys and returns how many existed
+	Delete(ctx context.Context, keys ...string) (int, error)
+	// Keys returns the keys matching the glob pattern with the size of their values, at most
+	// limit keys if limit is positive
+	Keys(ctx context.Context, pattern string, limit int) (map[string]int64, error)
+	Close() error
+}
+
+// redisStore keeps the entries in Redis, which is shared by all instances
+type redisStore struct {
+	client *redis.Client
+}
+
+// Get reads the value and the remaining TTL in one round trip
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, time.Duration, bool, error) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, 0, false, err
+	}
+	val, err := get.Bytes()
+	if err == redis.Nil {
+		return nil, 0, false, nil
+	} else if err != nil {
+		return nil, 0, false, err
+	}
+	ttl := pttl.Val()
+	if ttl < 0 {
+		ttl = 0 // -1 means no expiry
+	}
+	return val, ttl, true, nil
+}
+
+func (s *redisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *redisStore) Delete(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	deleted, err := s.client.Unlink(ctx, keys...).Result()
+	return int(deleted), err
+}
+
+// Keys scans Redis for the keys matching pattern, the sizes are read in one pipeline
+func (s *redisStore) Keys(ctx context.Context, pattern string, limit int) (map[string]int64, error) {
+	keys := make(map[string]int64)
+	var names []string
+	iter := s.client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		names = append(names, iter.Val())
+		if limit > 0 && len(names) >= limit {
+			break
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return keys, nil
+	}
+	cmds, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, name := range names {
+			pipe.StrLen(ctx, name)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		keys[names[i]] = cmd.(*redis.IntCmd).Val()
+	}
+	return keys, nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -2,10 +2,65 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 )
 
+// StaleWindow ist die Zeit nach Ablauf der TTL, in der ein Eintrag noch als veraltet ausgeliefert
+// werden kann, z. B. wenn der Provider nicht erreichbar ist. Setzbar mit CACHE_STALE_WINDOW.
+var StaleWindow = durationFromEnv("CACHE_STALE_WINDOW", time.Hour)
+
+// RevalidateWindow ist die Zeit nach Ablauf der TTL, in der ein Eintrag sofort ausgeliefert und im
+// Hintergrund erneuert wird. Die TTL ist der weiche Ablauf, TTL+RevalidateWindow der harte.
+// Setzbar mit CACHE_REVALIDATE_WINDOW, höchstens StaleWindow.
+var RevalidateWindow = min(durationFromEnv("CACHE_REVALIDATE_WINDOW", 10*time.Minute), StaleWindow)
+
+// NegativeTTL ist die Lebensdauer negativer Einträge, z. B. für nicht gefundene Dateien. Setzbar
+// mit CACHE_NEGATIVE_TTL, 0 schaltet das negative Caching ab.
+var NegativeTTL = durationFromEnv("CACHE_NEGATIVE_TTL", time.Minute)
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %v", name, value, fallback)
+	}
+	return fallback
+}
+
+// envelope speichert den Zeitpunkt des Schreibens zusammen mit dem Wert. Ein negativer Eintrag
+// hat statt des Werts den Fehler des Providers.
+type envelope[T any] struct {
+	StoredAt time.Time      `json:"storedAt"`
+	Value    T              `json:"value"`
+	Negative *NegativeEntry `json:"negative,omitempty"`
+}
+
+// NegativeEntry ist die Antwort des Providers, wenn es das Objekt nicht gibt oder es nicht
+// gelesen werden darf
+type NegativeEntry struct {
+	Status  int    `json:"status"` // HTTP-Status für den Client, z. B. 404
+	Message string `json:"message"`
+}
+
+// NegativeError liefert Lookup für einen negativen Eintrag, der Aufrufer antwortet mit Status,
+// ohne den Provider erneut zu fragen
+type NegativeError struct {
+	NegativeEntry
+	StoredAt time.Time
+}
+
+func (e *NegativeError) Error() string {
+	return fmt.Sprintf("cached negative result %d: %s", e.Status, e.Message)
+}
+
 // TTLPolicy erlaubt flexible TTL-Strategien (z. B. pro Typ, Keyspace, etc.)
 type TTLPolicy interface {
 	TTLForKey(key string) time.Duration
@@ -20,11 +75,69 @@ func (f FixedTTL) TTLForKey(_ string) time.Duration {
 	return f.Duration
 }
 
+// NoExpiry als TTL bedeutet, dass der Eintrag erst bei Verdrängung entfernt wird
+const NoExpiry time.Duration = 0
+
+// ImmutableTTL ist für Einträge, die sich nie ändern, z. B. Inhalte zu einer Commit-ID
+type ImmutableTTL struct{}
+
+func (ImmutableTTL) TTLForKey(_ string) time.Duration {
+	return NoExpiry
+}
+
+// PrefixTTL wählt die TTL nach dem längsten passenden Präfix des Keys, sonst gilt Default.
+// NoExpiry als TTL macht die Einträge eines Präfix unveränderlich.
+type PrefixTTL struct {
+	Prefixes map[string]time.Duration
+	Default  TTLPolicy
+}
+
+func (p PrefixTTL) TTLForKey(key string) time.Duration {
+	longest := -1
+	var ttl time.Duration
+	for prefix, d := range p.Prefixes {
+		if len(prefix) > longest && strings.HasPrefix(key, prefix) {
+			longest, ttl = len(prefix), d
+		}
+	}
+	if longest >= 0 {
+		return ttl
+	}
+	return p.Default.TTLForKey(key)
+}
+
+// PatternRule ordnet Keys, die auf den regulären Ausdruck passen, eine TTL zu
+type PatternRule struct {
+	Pattern *regexp.Regexp
+	TTL     time.Duration
+}
+
+// PatternTTL wendet die erste passende Regel an, sonst gilt Default
+type PatternTTL struct {
+	Rules   []PatternRule
+	Default TTLPolicy
+}
+
+// MatchTTL erstellt eine Regel, ein ungültiger Ausdruck ist ein Programmierfehler
+func MatchTTL(pattern string, ttl time.Duration) PatternRule {
+	return PatternRule{Pattern: regexp.MustCompile(pattern), TTL: ttl}
+}
+
+func (p PatternTTL) TTLForKey(key string) time.Duration {
+	for _, rule := range p.Rules {
+		if rule.Pattern.MatchString(key) {
+			return rule.TTL
+		}
+	}
+	return p.Default.TTLForKey(key)
+}
+
 // TypedCache[T] bietet typsicheren Zugriff auf den Cache
 type TypedCache[T any] struct {
 	ctx       context.Context
 	backend   CacheBackend
 	prefix    string    // z. B. "user" → Key wird zu "user:42"
+	scope     Scope     // wer die Einträge lesen darf
 	persist   bool      // ob Redis verwendet wird
 	ttlPolicy TTLPolicy // z. B. FixedTTL
 }
@@ -34,6 +147,7 @@ func NewTypedCache[T any](
 	ctx context.Context,
 	backend CacheBackend,
 	prefix string,
+	scope Scope,
 	persist bool,
 	ttl TTLPolicy,
 ) *TypedCache[T] {
@@ -41,6 +155,7 @@ func NewTypedCache[T any](
 		ctx:       ctx,
 		backend:   backend,
 		prefix:    prefix,
+		scope:     scope,
 		persist:   persist,
 		ttlPolicy: ttl,
 	}
@@ -51,28 +166,154 @@ func (c *TypedCache[T]) buildKey(key string) string {
 	return fmt.Sprintf("%s:%s", c.prefix, key)
 }
 
-// Get lädt ein Objekt vom Typ T aus dem Cache
-func (c *TypedCache[T]) Get(key string) (*T, bool, error) {
-	// return nil, false, nil
-	var val T
-	fullKey := c.buildKey(key)
-	found, err := c.backend.Get(fullKey, &val)
-	if err != nil || !found {
+// load liest den Eintrag samt Alter, Einträge im alten Format ohne Zeitstempel gelten als nicht vorhanden
+func (c *TypedCache[T]) load(storeKey string) (*envelope[T], bool, error) {
+	var entry envelope[T]
+	found, err := c.backend.Get(c.buildKey(storeKey), &entry)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return nil, false, nil
+	}
+	if err != nil || !found || entry.StoredAt.IsZero() {
 		return nil, false, err
 	}
-	return &val, true, nil
+	return &entry, true, nil
+}
+
+// Get lädt ein Objekt vom Typ T aus einem Cache mit ScopePublic, abgelaufene Einträge gelten als
+// nicht vorhanden. Caches mit anderem Scope werden über For(principal) gelesen.
+func (c *TypedCache[T]) Get(key string) (*T, bool, error) {
+	if c.scope != ScopePublic {
+		return nil, false, ErrScopeRequired
+	}
+	return c.get(key, key)
+}
+
+// GetStale lädt ein Objekt auch nach Ablauf der TTL, solange es innerhalb von StaleWindow liegt.
+// Zusätzlich wird der Zeitpunkt des Schreibens geliefert.
+func (c *TypedCache[T]) GetStale(key string) (*T, time.Time, bool, error) {
+	if c.scope != ScopePublic {
+		return nil, time.Time{}, false, ErrScopeRequired
+	}
+	return c.getStale(key, key)
 }
 
-// Set schreibt ein Objekt vom Typ T in den Cache, ggf. mit TTL
+// Set schreibt ein Objekt vom Typ T in einen Cache mit ScopePublic
 func (c *TypedCache[T]) Set(key string, val T) error {
-	fullKey := c.buildKey(key)
+	if c.scope != ScopePublic {
+		return ErrScopeRequired
+	}
+	return c.set(key, key, val)
+}
+
+// Scope liefert den Scope der Einträge
+func (c *TypedCache[T]) Scope() Scope {
+	return c.scope
+}
+
+// get liest unter storeKey, die TTL richtet sich nach dem Key ohne Scope
+func (c *TypedCache[T]) get(storeKey, key string) (*T, bool, error) {
+	entry, found, err := c.load(storeKey)
+	if err != nil || !found || entry.Negative != nil {
+		return nil, false, err
+	}
+	if ttl := c.ttlPolicy.TTLForKey(key); ttl > 0 && time.Since(entry.StoredAt) > ttl {
+		return nil, false, nil
+	}
+	return &entry.Value, true, nil
+}
+
+// Status beschreibt, wie aktuell ein gelesener Eintrag ist
+type Status int
+
+const (
+	StatusMiss  Status = iota // Nicht vorhanden oder nach dem harten Ablauf
+	StatusHit                 // Innerhalb der TTL
+	StatusStale               // Nach Ablauf der TTL, aber innerhalb von RevalidateWindow
+)
+
+// String liefert den Wert für den Header X-Cache
+func (s Status) String() string {
+	switch s {
+	case StatusHit:
+		return "HIT"
+	case StatusStale:
+		return "STALE"
+	default:
+		return "MISS"
+	}
+}
+
+// lookup liest einen Eintrag bis zum harten Ablauf. Die TTL ist der weiche Ablauf, danach ist
+// der Eintrag für RevalidateWindow veraltet und sollte im Hintergrund erneuert werden. Ein
+// negativer Eintrag wird innerhalb von NegativeTTL als *NegativeError mit StatusHit geliefert.
+func (c *TypedCache[T]) lookup(storeKey, key string) (*T, time.Time, Status, error) {
+	entry, found, err := c.load(storeKey)
+	if err != nil || !found {
+		return nil, time.Time{}, StatusMiss, err
+	}
+	if entry.Negative != nil {
+		if time.Since(entry.StoredAt) > NegativeTTL {
+			return nil, time.Time{}, StatusMiss, nil
+		}
+		return nil, entry.StoredAt, StatusHit, &NegativeError{NegativeEntry: *entry.Negative, StoredAt: entry.StoredAt}
+	}
+	ttl := c.ttlPolicy.TTLForKey(key)
+	age := time.Since(entry.StoredAt)
+	switch {
+	case ttl <= 0 || age <= ttl:
+		return &entry.Value, entry.StoredAt, StatusHit, nil
+	case age <= ttl+RevalidateWindow:
+		cacheStale.WithLabelValues(c.prefix).Inc()
+		return &entry.Value, entry.StoredAt, StatusStale, nil
+	}
+	return nil, time.Time{}, StatusMiss, nil
+}
 
-	// TTL temporär an MultiLevelCache durchreichen, wenn nötig
-	if mlc, ok := c.backend.(*MultiLevelCache); ok {
-		prevTTL := mlc.ttl
-		mlc.ttl = c.ttlPolicy.TTLForKey(key)
-		defer func() { mlc.ttl = prevTTL }()
+func (c *TypedCache[T]) getStale(storeKey, key string) (*T, time.Time, bool, error) {
+	entry, found, err := c.load(storeKey)
+	if err != nil || !found || entry.Negative != nil {
+		return nil, time.Time{}, false, err
+	}
+	if ttl := c.ttlPolicy.TTLForKey(key); ttl > 0 && time.Since(entry.StoredAt) > ttl+StaleWindow {
+		return nil, time.Time{}, false, nil
 	}
+	return &entry.Value, entry.StoredAt, true, nil
+}
+
+// set schreibt unter storeKey, der Eintrag bleibt zusätzlich für StaleWindow erhalten
+func (c *TypedCache[T]) set(storeKey, key string, val T) error {
+	ttl := c.ttlPolicy.TTLForKey(key)
+	if ttl > 0 {
+		ttl += StaleWindow
+	}
+	return c.backend.Set(c.buildKey(storeKey), envelope[T]{StoredAt: time.Now(), Value: val}, c.persist, ttl)
+}
+
+// setNegative schreibt unter storeKey einen negativen Eintrag, der nach NegativeTTL abläuft,
+// unabhängig von der TTL-Strategie. Er ersetzt einen vorhandenen Wert.
+func (c *TypedCache[T]) setNegative(storeKey string, status int, message string) error {
+	if NegativeTTL <= 0 {
+		return nil
+	}
+	entry := envelope[T]{StoredAt: time.Now(), Negative: &NegativeEntry{Status: status, Message: message}}
+	return c.backend.Set(c.buildKey(storeKey), entry, c.persist, NegativeTTL)
+}
+
+// Key liefert den vollständigen Key inklusive Prefix, z. B. für das Zusammenfassen von Anfragen.
+// Bei Caches mit Scope liefert View.Key den Key inklusive Scope.
+func (c *TypedCache[T]) Key(key string) string {
+	return c.buildKey(key)
+}
+
+// Info beschreibt einen TypedCache für die Administration
+type Info struct {
+	Prefix  string `json:"prefix"`
+	Scope   string `json:"scope"`
+	Persist bool   `json:"persist"`
+}
 
-	return c.backend.Set(fullKey, val, c.persist)
+// Info liefert Prefix, Scope und Persistenz des Caches
+func (c *TypedCache[T]) Info() Info {
+	return Info{Prefix: c.prefix, Scope: c.scope.String(), Persist: c.persist}
 }
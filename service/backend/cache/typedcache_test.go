@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBackend keeps the values as JSON and remembers the TTL of each Set
+type recordingBackend struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func newRecordingBackend() *recordingBackend {
+	return &recordingBackend{values: make(map[string][]byte), ttls: make(map[string]time.Duration)}
+}
+
+func (b *recordingBackend) Get(key string, dest interface{}) (bool, error) {
+	b.mu.Lock()
+	data, ok := b.values[key]
+	b.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, dest)
+}
+
+func (b *recordingBackend) Set(key string, val interface{}, _ bool, ttl time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.values[key], b.ttls[key] = data, ttl
+	b.mu.Unlock()
+	return nil
+}
+
+func TestTTLPolicies(t *testing.T) {
+	policy := PatternTTL{
+		Rules: []PatternRule{MatchTTL(`:oid:[0-9a-f]{40}$`, NoExpiry)},
+		Default: PrefixTTL{
+			Prefixes: map[string]time.Duration{"refs:": time.Minute, "refs-all:": time.Hour},
+			Default:  FixedTTL{Duration: 10 * time.Minute},
+		},
+	}
+	tests := []struct {
+		key  string
+		want time.Duration
+	}{
+		{"content:github:o:r:README.md:oid:0123456789abcdef0123456789abcdef01234567", NoExpiry},
+		// A branch named like a commit id is not marked
+		{"content:github:o:r:README.md:0123456789abcdef0123456789abcdef01234567", 10 * time.Minute},
+		{"content:github:o:r:README.md:main", 10 * time.Minute},
+		{"refs:github:o:r", time.Minute},
+		{"refs-all:github:o:r", time.Hour},
+	}
+	for _, tt := range tests {
+		if got := policy.TTLForKey(tt.key); got != tt.want {
+			t.Errorf("TTLForKey(%s) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+	if got := (ImmutableTTL{}).TTLForKey("any"); got != NoExpiry {
+		t.Errorf("immutable TTL is %v", got)
+	}
+}
+
+// Caches with different policies on one backend pass their own TTL with every Set
+func TestSetPassesTTL(t *testing.T) {
+	backend := newRecordingBackend()
+	short := NewTypedCache[string](context.Background(), backend, "short", ScopePublic, true, FixedTTL{Duration: time.Minute})
+	immutable := NewTypedCache[string](context.Background(), backend, "immutable", ScopePublic, true, ImmutableTTL{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); short.Set("k", "v") }()
+		go func() { defer wg.Done(); immutable.Set("k", "v") }()
+	}
+	wg.Wait()
+
+	if ttl := backend.ttls["short:k"]; ttl != time.Minute+StaleWindow {
+		t.Errorf("TTL of the short entry is %v", ttl)
+	}
+	if ttl := backend.ttls["immutable:k"]; ttl != NoExpiry {
+		t.Errorf("TTL of the immutable entry is %v", ttl)
+	}
+	if v, found, err := immutable.Get("k"); err != nil || !found || *v != "v" {
+		t.Errorf("Get = %v, %v, %v", v, found, err)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	backend := newRecordingBackend()
+	scoped := NewTypedCache[string](context.Background(), backend, "repo", ScopeConnection, true, FixedTTL{Duration: time.Minute})
+	alice := Principal{Connection: 1, Token: "alice"}
+	bob := Principal{Connection: 1, Token: "bob"}
+
+	if err := scoped.Set("k", "v"); err != ErrScopeRequired {
+		t.Errorf("unscoped Set returned %v", err)
+	}
+	scoped.For(alice).Set("k", "private")
+	if _, found, _ := scoped.For(bob).Get("k"); found {
+		t.Error("an entry of one token is visible to another token")
+	}
+
+	alice.Shared, bob.Shared = true, true
+	scoped.For(alice).Set("k", "public")
+	if v, found, _ := scoped.For(bob).Get("k"); !found || *v != "public" {
+		t.Error("a shared entry is not visible within the connection")
+	}
+	if _, found, _ := scoped.For(Principal{Connection: 2, Token: "bob", Shared: true}).Get("k"); found {
+		t.Error("a shared entry is visible to another connection")
+	}
+}
+
+func TestNegativeEntries(t *testing.T) {
+	backend := newRecordingBackend()
+	immutable := NewTypedCache[string](context.Background(), backend, "file", ScopeConnection, true, ImmutableTTL{})
+	view := immutable.For(Principal{Connection: 1, Token: "alice"})
+
+	if err := view.SetNegative("k", 404, "Not Found"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := backend.ttls[view.Key("k")]; ttl != NegativeTTL {
+		t.Errorf("TTL of the negative entry is %v", ttl)
+	}
+	_, _, status, err := view.Lookup("k")
+	var negative *NegativeError
+	if !errors.As(err, &negative) || negative.Status != 404 || status != StatusHit {
+		t.Errorf("Lookup = %v, %v", status, err)
+	}
+	if _, found, err := view.Get("k"); found || err != nil {
+		t.Errorf("Get of a negative entry = %v, %v", found, err)
+	}
+
+	view.Set("k", "v")
+	if v, _, status, err := view.Lookup("k"); err != nil || status != StatusHit || *v != "v" {
+		t.Errorf("Lookup after Set = %v, %v", status, err)
+	}
+}
@@ -23,10 +23,21 @@ var enumDefinitions = []struct {
 	CreateSQL string
 }{
 	{"user_type", `CREATE TYPE user_type AS ENUM ('admin', 'user')`},
-	{"connection_type", `CREATE TYPE connection_type AS ENUM ('github', 'gitlab', 'ghes')`},
+	{"connection_type", `CREATE TYPE connection_type AS ENUM ('github', 'gitlab', 'ghes', 'gitlab_selfmanaged', 'gitea', 'local')`},
 	{"permission_type", `CREATE TYPE permission_type AS ENUM ('CreateUser', 'DeleteUser', 'EditUser')`},
 }
 
+// Values which were added to an enum type after its first release. Databases created
+// before are migrated with ALTER TYPE, fresh databases get them with CREATE TYPE.
+var enumAdditions = []struct {
+	TypeName string
+	Value    string
+}{
+	{"connection_type", "gitlab_selfmanaged"},
+	{"connection_type", "gitea"},
+	{"connection_type", "local"},
+}
+
 func InitDB() error {
 	host := os.Getenv("DB_HOST")
 	if host == "" {
@@ -80,6 +91,17 @@ func initializeEnums() error {
 			log.Printf("Enum type %s already exists\n", enumDef.TypeName)
 		}
 	}
+	return migrateEnums()
+}
+
+func migrateEnums() error {
+	for _, addition := range enumAdditions {
+		// ALTER TYPE does not accept bind parameters, the values are constants of this file
+		sql := fmt.Sprintf(`ALTER TYPE %s ADD VALUE IF NOT EXISTS '%s'`, addition.TypeName, addition.Value)
+		if err := DB.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -97,6 +119,7 @@ func AutoMigrate() error {
 		&models.Configuration{},
 		&models.Session{},
 		&models.OAuth2Session{},
+		&models.Mirror{},
 		// Add further models here
 	}
 	for _, m := range models {
@@ -0,0 +1,243 @@
+// Package fakeforge is an in-process fake of the GitHub and GitLab APIs for integration tests.
+// It implements the part of the GraphQL and REST APIs the backend uses and the oauth2 authorize
+// and token endpoints. The forge is a http.Handler and a http.RoundTripper: it can be started
+// with httptest.NewServer or injected with common.SetUpstreamTransport, then the host names of
+// the requests don't matter.
+package fakeforge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rateLimit = 5000
+
+type Forge struct {
+	mu           sync.Mutex
+	users        map[string]*user
+	userOrder    []string
+	repositories map[string]*repository
+	tokens       map[string]*user
+	codes        map[string]*user // Issued authorization codes
+	remaining    map[string]int   // Rate limit per token
+	requests     int
+}
+
+// New creates a forge with the state of the seed
+func New(seed *Seed) (*Forge, error) {
+	f := &Forge{
+		users:        make(map[string]*user),
+		repositories: make(map[string]*repository),
+		tokens:       make(map[string]*user),
+		codes:        make(map[string]*user),
+		remaining:    make(map[string]int),
+	}
+	if err := f.apply(seed); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// NewFromFile creates a forge from a YAML seed file
+func NewFromFile(path string) (*Forge, error) {
+	seed, err := LoadSeed(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(seed)
+}
+
+// Requests returns the number of API requests, the oauth2 endpoints are not counted
+func (f *Forge) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// Push adds a commit to a branch, the branch is created if it does not exist
+func (f *Forge) Push(owner, name, branch string, seed CommitSeed) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repositories[owner+"/"+name]
+	if !ok {
+		return "", fmt.Errorf("unknown repository %s/%s", owner, name)
+	}
+	head := repo.branches[branch]
+	if head == nil {
+		head = repo.branches[repo.defaultBranch]
+	}
+	c, err := f.newCommit(repo, head, seed)
+	if err != nil {
+		return "", err
+	}
+	repo.branches[branch] = c
+	return c.oid, nil
+}
+
+// IssueToken creates a token for a user without the oauth2 flow
+func (f *Forge) IssueToken(login string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[login]
+	if !ok {
+		return "", fmt.Errorf("unknown user %s", login)
+	}
+	token := randomString("gho_")
+	f.tokens[token] = u
+	return token, nil
+}
+
+func randomString(prefix string) string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return prefix + hex.EncodeToString(data)
+}
+
+// RoundTrip answers the request in-process
+func (f *Forge) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	f.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func (f *Forge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/login/oauth/authorize" || path == "/oauth/authorize":
+		f.authorize(w, r)
+		return
+	case path == "/login/oauth/access_token" || path == "/oauth/token":
+		f.accessToken(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	viewer, token := f.authenticate(r)
+	if viewer == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+	f.rateLimitHeaders(w, token, path)
+
+	switch {
+	case path == "/graphql" || path == "/api/graphql":
+		f.graphQL(w, r, viewer)
+	case strings.HasPrefix(path, "/repos/") || strings.HasPrefix(path, "/api/v3/repos/"):
+		f.rest(w, r, viewer, strings.TrimPrefix(path, "/api/v3"))
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+// authenticate accepts "Bearer <token>" and "token <token>"
+func (f *Forge) authenticate(r *http.Request) (*user, string) {
+	header := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "bearer ", "token "} {
+		if token, ok := strings.CutPrefix(header, scheme); ok {
+			return f.tokens[token], token
+		}
+	}
+	return nil, ""
+}
+
+func (f *Forge) rateLimitHeaders(w http.ResponseWriter, token, path string) {
+	resource := "core"
+	if strings.HasSuffix(path, "/graphql") {
+		resource = "graphql"
+	}
+	key := token + ":" + resource
+	remaining, ok := f.remaining[key]
+	if !ok {
+		remaining = rateLimit
+	}
+	if remaining > 0 {
+		remaining--
+	}
+	f.remaining[key] = remaining
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rateLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Used", strconv.Itoa(rateLimit-remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	w.Header().Set("X-RateLimit-Resource", resource)
+}
+
+// authorize approves every request, the user is selected with the parameter login, by default
+// it's the first user of the seed
+func (f *Forge) authorize(w http.ResponseWriter, r *http.Request) {
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	if redirectURI == "" {
+		http.Error(w, "redirect_uri is missing", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	login := r.URL.Query().Get("login")
+	if login == "" && len(f.userOrder) > 0 {
+		login = f.userOrder[0]
+	}
+	u, ok := f.users[login]
+	code := randomString("code_")
+	if ok {
+		f.codes[code] = u
+	}
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown user", http.StatusBadRequest)
+		return
+	}
+
+	separator := "?"
+	if strings.Contains(redirectURI, "?") {
+		separator = "&"
+	}
+	location := redirectURI + separator + "code=" + code
+	if state := r.URL.Query().Get("state"); state != "" {
+		location += "&state=" + state
+	}
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+func (f *Forge) accessToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := r.Form.Get("code")
+	u, ok := f.codes[code]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad_verification_code"})
+		return
+	}
+	delete(f.codes, code)
+	token := randomString("gho_")
+	f.tokens[token] = u
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": token, "token_type": "bearer", "scope": "repo,user"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// repository returns a repository the viewer can see, private ones are visible for the owner
+func (f *Forge) repository(viewer *user, owner, name string) *repository {
+	repo, ok := f.repositories[owner+"/"+name]
+	if !ok || (repo.private && repo.owner != viewer.Login) {
+		return nil
+	}
+	return repo
+}
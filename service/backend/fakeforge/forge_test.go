@@ -0,0 +1,191 @@
+package fakeforge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func newTestForge(t *testing.T) *Forge {
+	t.Helper()
+	forge, err := NewFromFile("testdata/seed.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forge
+}
+
+func graphQL(t *testing.T, client *http.Client, endpoint, token, query string, variables map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req, _ := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestOAuthFlow(t *testing.T) {
+	forge := newTestForge(t)
+	server := httptest.NewServer(forge)
+	defer server.Close()
+
+	config := &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://backend.test/api/callback/github_enterprise",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  server.URL + "/login/oauth/authorize",
+			TokenURL: server.URL + "/login/oauth/access_token",
+		},
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(config.AuthCodeURL("state-1") + "&login=hubot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Query().Get("state") != "state-1" {
+		t.Fatalf("redirect to %q, want the callback with the state", resp.Header.Get("Location"))
+	}
+
+	token, err := config.Exchange(context.Background(), location.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := graphQL(t, http.DefaultClient, server.URL+"/graphql", token.AccessToken, "{ viewer { login } }", nil)
+	login := viewer["data"].(map[string]interface{})["viewer"].(map[string]interface{})["login"]
+	if login != "hubot" {
+		t.Errorf("viewer = %v, want hubot", login)
+	}
+
+	// A code is valid once
+	if _, err := config.Exchange(context.Background(), location.Query().Get("code")); err == nil {
+		t.Error("code was accepted twice")
+	}
+}
+
+func TestRepositoryQueries(t *testing.T) {
+	forge := newTestForge(t)
+	client := &http.Client{Transport: forge}
+	token, err := forge.IssueToken("octocat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const endpoint = "https://api.github.com/graphql"
+
+	tests := []struct {
+		name      string
+		token     string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			name:      "refs sorted by date",
+			token:     token,
+			query:     "query GetRepositoryRefs($owner: String!) {}",
+			variables: map[string]interface{}{"owner": "octocat", "name": "hello-world", "refPrefix": "refs/heads/", "first": 1},
+			want:      `"name":"feature"`,
+		},
+		{
+			name:      "history of a path",
+			token:     token,
+			query:     "query GetRepositoryCommits($owner: String!) {}",
+			variables: map[string]interface{}{"owner": "octocat", "name": "hello-world", "expression": "main", "path": "docs", "first": 10},
+			want:      `"totalCount":1`,
+		},
+		{
+			name:      "tree",
+			token:     token,
+			query:     "query GetRepositoryContents($owner: String!) {}",
+			variables: map[string]interface{}{"owner": "octocat", "name": "hello-world", "expression": "feature:docs"},
+			want:      `"entries":[{"mode":"100644","name":"guide.md","type":"blob"}]`,
+		},
+		{
+			name:      "private repository of another user",
+			token:     "hubot-token",
+			query:     "query GetRepository($owner: String!) {}",
+			variables: map[string]interface{}{"owner": "octocat", "name": "secret"},
+			want:      `"type":"NOT_FOUND"`,
+		},
+		{
+			name:  "last commits of entries",
+			token: token,
+			query: `query { repository(owner: "octocat", name: "hello-world") { ref(qualifiedName: "main") { target { ... on Commit {
+  f0: history(first: 1, path: "docs") { nodes { oid } }
+  f1: history(first: 1, path: "README.md") { nodes { oid } } } } } } }`,
+			want: `"message":"Describe the guide in the readme"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := graphQL(t, client, endpoint, tt.token, tt.query, tt.variables)
+			data, _ := json.Marshal(result)
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("answer does not contain %s: %s", tt.want, data)
+			}
+		})
+	}
+}
+
+func TestRESTAndPush(t *testing.T) {
+	forge := newTestForge(t)
+	client := &http.Client{Transport: forge}
+	get := func(path string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, "https://api.github.com"+path, nil)
+		req.Header.Set("Authorization", "Bearer hubot-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("/repos/octocat/hello-world/contributors?per_page=1")
+	if !strings.Contains(resp.Header.Get("Link"), `&page=2>; rel="last"`) || !strings.Contains(string(body), `"login":"octocat"`) {
+		t.Errorf("contributors: Link %q, body %s", resp.Header.Get("Link"), body)
+	}
+
+	if _, err := forge.Push("octocat", "hello-world", "main", CommitSeed{Message: "Update", Author: "hubot", Files: map[string]*string{"README.md": ptr("changed")}}); err != nil {
+		t.Fatal(err)
+	}
+	_, body = get("/repos/octocat/hello-world/contents/README.md?ref=main")
+	var file struct {
+		Content string `json:"content"`
+	}
+	json.Unmarshal(body, &file)
+	if content, _ := base64.StdEncoding.DecodeString(file.Content); string(content) != "changed" {
+		t.Errorf("content after push = %q, want changed", content)
+	}
+
+	if resp, _ := get("/repos/octocat/secret/contents/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("private repository: status %d, want 404", resp.StatusCode)
+	}
+	if forge.Requests() != 3 {
+		t.Errorf("requests = %d, want 3", forge.Requests())
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,497 @@
+package fakeforge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A GraphQL parser would be oversized for a fake, the queries of the backend are recognized by
+// their operation names. Queries without a name are recognized by their top level field.
+
+type object = map[string]interface{}
+
+var (
+	operationName = regexp.MustCompile(`^\s*query\s+(\w+)`)
+	aliasHistory  = regexp.MustCompile(`(\w+): history\(first: 1, path: ("(?:[^"\\]|\\.)*")\)`)
+	literalRepo   = regexp.MustCompile(`repository\(owner: ("(?:[^"\\]|\\.)*"), name: ("(?:[^"\\]|\\.)*")\)`)
+	literalRef    = regexp.MustCompile(`(ref\(qualifiedName|object\(expression): ("(?:[^"\\]|\\.)*")\)`)
+)
+
+type graphQLRequest struct {
+	Query     string `json:"query"`
+	Variables object `json:"variables"`
+}
+
+func (req graphQLRequest) str(name string) string {
+	value, _ := req.Variables[name].(string)
+	return value
+}
+
+func (req graphQLRequest) integer(name string, fallback int) int {
+	if value, ok := req.Variables[name].(float64); ok && value > 0 {
+		return int(value)
+	}
+	return fallback
+}
+
+func graphQLErrors(w http.ResponseWriter, data object, kind, field, message string) {
+	writeJSON(w, http.StatusOK, object{
+		"data":   data,
+		"errors": []object{{"type": kind, "path": []string{field}, "message": message}},
+	})
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("cursor:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) int {
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(data), "cursor:"))
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// page cuts a page out of n elements, the cursors are offsets
+func page(n, first int, after string) (int, int, object) {
+	start := 0
+	if after != "" {
+		start = decodeCursor(after) + 1
+	}
+	if start > n {
+		start = n
+	}
+	end := start + first
+	if end > n {
+		end = n
+	}
+	pageInfo := object{
+		"hasNextPage":     end < n,
+		"hasPreviousPage": start > 0,
+		"startCursor":     nil,
+		"endCursor":       nil,
+	}
+	if end > start {
+		pageInfo["startCursor"] = encodeCursor(start)
+		pageInfo["endCursor"] = encodeCursor(end - 1)
+	}
+	return start, end, pageInfo
+}
+
+func (f *Forge) graphQL(w http.ResponseWriter, r *http.Request, viewer *user) {
+	var req graphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, object{"message": "Problems parsing JSON"})
+		return
+	}
+	name := ""
+	if match := operationName.FindStringSubmatch(req.Query); match != nil {
+		name = match[1]
+	}
+
+	switch {
+	case name == "" && strings.Contains(req.Query, "currentUser"):
+		f.currentUser(w, viewer)
+	case name == "" && strings.Contains(req.Query, "viewer"):
+		f.viewer(w, viewer)
+	case name == "" && aliasHistory.MatchString(req.Query):
+		f.lastCommits(w, viewer, req.Query)
+	case name == "GetRepositories":
+		f.viewerRepositories(w, viewer, req)
+	case name == "GetRepository":
+		f.repositoryQuery(w, viewer, req)
+	case name == "GetRepositoryContents":
+		f.repositoryContents(w, viewer, req)
+	case name == "GetRepositoryBranchCommit":
+		f.branchCommit(w, viewer, req)
+	case name == "GetRepositoryRefs":
+		f.refs(w, viewer, req)
+	case name == "GetRepositoryCommits":
+		f.commits(w, viewer, req)
+	case name == "GetRepositoryBlame":
+		f.blame(w, viewer, req)
+	default:
+		writeJSON(w, http.StatusOK, object{"errors": []object{{"message": fmt.Sprintf("the fake forge does not implement the query %q", name)}}})
+	}
+}
+
+func (f *Forge) viewer(w http.ResponseWriter, viewer *user) {
+	writeJSON(w, http.StatusOK, object{"data": object{"viewer": object{
+		"login":      viewer.Login,
+		"name":       viewer.Name,
+		"email":      viewer.Email,
+		"bio":        viewer.Bio,
+		"avatarUrl":  viewer.avatarURL(),
+		"createdAt":  formatTime(viewer.createdAt),
+		"company":    viewer.Company,
+		"location":   viewer.Location,
+		"websiteUrl": htmlBase + "/" + viewer.Login,
+	}}})
+}
+
+// currentUser is the user query of GitLab
+func (f *Forge) currentUser(w http.ResponseWriter, viewer *user) {
+	id := 0
+	for i, login := range f.userOrder {
+		if login == viewer.Login {
+			id = i + 1
+		}
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"currentUser": object{
+		"id":          fmt.Sprintf("gid://gitlab/User/%d", id),
+		"username":    viewer.Login,
+		"name":        viewer.Name,
+		"publicEmail": viewer.Email,
+		"bio":         viewer.Bio,
+		"avatarUrl":   viewer.avatarURL(),
+		"location":    viewer.Location,
+		"webUrl":      htmlBase + "/" + viewer.Login,
+		"createdAt":   formatTime(viewer.createdAt),
+	}}})
+}
+
+func repositoryNode(repo *repository) object {
+	return object{
+		"name":           repo.name,
+		"description":    repo.description,
+		"url":            fmt.Sprintf("%s/%s/%s", htmlBase, repo.owner, repo.name),
+		"isArchived":     false,
+		"isPrivate":      repo.private,
+		"isFork":         false,
+		"parent":         nil,
+		"createdAt":      formatTime(repo.createdAt),
+		"updatedAt":      formatTime(repo.pushedAt()),
+		"pushedAt":       formatTime(repo.pushedAt()),
+		"stargazerCount": repo.stars,
+		"forkCount":      0,
+	}
+}
+
+func (f *Forge) viewerRepositories(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	var repos []*repository
+	for _, repo := range f.repositories {
+		if repo.owner == viewer.Login {
+			repos = append(repos, repo)
+		}
+	}
+	field := req.str("field")
+	sort.Slice(repos, func(i, j int) bool {
+		a, b := repos[i], repos[j]
+		switch field {
+		case "NAME":
+			return a.name < b.name
+		case "CREATED_AT":
+			return a.createdAt.Before(b.createdAt)
+		case "STARGAZER_COUNT":
+			return a.stars < b.stars
+		default:
+			return a.pushedAt().Before(b.pushedAt())
+		}
+	})
+	if req.str("direction") == "DESC" {
+		for i, j := 0, len(repos)-1; i < j; i, j = i+1, j-1 {
+			repos[i], repos[j] = repos[j], repos[i]
+		}
+	}
+	start, end, pageInfo := page(len(repos), req.integer("first", 10), req.str("after"))
+	nodes := []object{}
+	for _, repo := range repos[start:end] {
+		nodes = append(nodes, repositoryNode(repo))
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"viewer": object{
+		"avatarUrl":    viewer.avatarURL(),
+		"repositories": object{"pageInfo": pageInfo, "nodes": nodes},
+	}}})
+}
+
+// lookup returns the repository of the variables owner and name or writes the NOT_FOUND error
+func (f *Forge) lookup(w http.ResponseWriter, viewer *user, owner, name string) *repository {
+	repo := f.repository(viewer, owner, name)
+	if repo == nil {
+		graphQLErrors(w, object{"repository": nil}, "NOT_FOUND", "repository",
+			fmt.Sprintf("Could not resolve to a Repository with the name '%s/%s'.", owner, name))
+	}
+	return repo
+}
+
+type ref struct {
+	name   string
+	commit *commit
+}
+
+// sortedRefs returns the branches or tags, the newest commit first like TAG_COMMIT_DATE DESC
+func sortedRefs(refs map[string]*commit) []ref {
+	list := make([]ref, 0, len(refs))
+	for name, c := range refs {
+		list = append(list, ref{name, c})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].commit.date.Equal(list[j].commit.date) {
+			return list[i].commit.date.After(list[j].commit.date)
+		}
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+func refNames(refs []ref, limit int) object {
+	nodes := []object{}
+	for i := 0; i < len(refs) && i < limit; i++ {
+		nodes = append(nodes, object{"name": refs[i].name})
+	}
+	return object{"totalCount": len(refs), "nodes": nodes}
+}
+
+func (f *Forge) repositoryQuery(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	repo := f.lookup(w, viewer, req.str("owner"), req.str("name"))
+	if repo == nil {
+		return
+	}
+	node := repositoryNode(repo)
+	node["owner"] = object{"avatarUrl": f.users[repo.owner].avatarURL()}
+	node["languages"] = object{"totalSize": 0, "edges": []object{}}
+	node["defaultBranchRef"] = object{"name": repo.defaultBranch}
+	node["branches"] = refNames(sortedRefs(repo.branches), 9)
+	node["tags"] = refNames(sortedRefs(repo.tags), 10)
+	node["releases"] = object{"totalCount": 0, "nodes": []object{}}
+	node["deployments"] = object{"totalCount": 0, "nodes": []object{}}
+	node["licenseInfo"] = nil
+	node["watchers"] = object{"totalCount": 0}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": node}})
+}
+
+// repositoryContents lists a tree, the expression is "<ref>:<path>"
+func (f *Forge) repositoryContents(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	repo := f.lookup(w, viewer, req.str("owner"), req.str("name"))
+	if repo == nil {
+		return
+	}
+	revision, dir, _ := strings.Cut(req.str("expression"), ":")
+	var result interface{}
+	if c := repo.resolve(revision); c != nil {
+		if entries, ok := c.tree(dir); ok {
+			list := []object{}
+			for _, entry := range entries {
+				kind, mode := "blob", "100644"
+				if entry.isDir {
+					kind, mode = "tree", "040000"
+				}
+				list = append(list, object{"name": entry.name, "type": kind, "mode": mode})
+			}
+			result = object{"entries": list}
+		}
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"object": result}}})
+}
+
+func (f *Forge) commitAuthor(c *commit) object {
+	return object{
+		"name":  c.author.Name,
+		"email": c.author.Email,
+		"user": object{
+			"login":     c.author.Login,
+			"avatarUrl": c.author.avatarURL(),
+			"url":       htmlBase + "/" + c.author.Login,
+		},
+	}
+}
+
+func headline(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return line
+}
+
+// branchCommit resolves only references like GitHub's ref(qualifiedName:)
+func (f *Forge) branchCommit(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	repo := f.lookup(w, viewer, req.str("owner"), req.str("name"))
+	if repo == nil {
+		return
+	}
+	expression := req.str("expression")
+	c := repo.branches[strings.TrimPrefix(expression, "refs/heads/")]
+	if c == nil {
+		c = repo.tags[strings.TrimPrefix(expression, "refs/tags/")]
+	}
+	var result interface{}
+	if c != nil {
+		result = object{"target": object{
+			"oid":             c.oid,
+			"committedDate":   formatTime(c.date),
+			"messageHeadline": headline(c.message),
+			"author":          f.commitAuthor(c),
+			"signature":       nil,
+			"checkSuites":     object{"totalCount": 0, "nodes": []object{}},
+			"history":         object{"totalCount": len(c.history(""))},
+		}}
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"ref": result}}})
+}
+
+func (f *Forge) refs(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	repo := f.lookup(w, viewer, req.str("owner"), req.str("name"))
+	if repo == nil {
+		return
+	}
+	source := repo.branches
+	if req.str("refPrefix") == "refs/tags/" {
+		source = repo.tags
+	}
+	refs := sortedRefs(source)
+	start, end, pageInfo := page(len(refs), req.integer("first", 10), req.str("after"))
+	nodes := []object{}
+	for _, r := range refs[start:end] {
+		nodes = append(nodes, object{"name": r.name, "target": object{"oid": r.commit.oid, "committedDate": formatTime(r.commit.date)}})
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"refs": object{
+		"totalCount": len(refs),
+		"pageInfo":   pageInfo,
+		"nodes":      nodes,
+	}}}})
+}
+
+func (f *Forge) commits(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	repo := f.lookup(w, viewer, req.str("owner"), req.str("name"))
+	if repo == nil {
+		return
+	}
+	c := repo.resolve(req.str("expression"))
+	if c == nil {
+		writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"object": nil}}})
+		return
+	}
+	history := c.history(req.str("path"))
+	start, end, pageInfo := page(len(history), req.integer("first", 10), req.str("after"))
+	nodes := []object{}
+	for _, entry := range history[start:end] {
+		nodes = append(nodes, object{
+			"oid":             entry.oid,
+			"messageHeadline": headline(entry.message),
+			"committedDate":   formatTime(entry.date),
+			"author":          f.commitAuthor(entry),
+		})
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"object": object{"history": object{
+		"totalCount": len(history),
+		"pageInfo":   pageInfo,
+		"nodes":      nodes,
+	}}}}})
+}
+
+// blame assigns a line to the oldest commit from which on the line at the same position did
+// not change. That's coarser than git, inserted lines shift the following lines.
+func (f *Forge) blame(w http.ResponseWriter, viewer *user, req graphQLRequest) {
+	repo := f.lookup(w, viewer, req.str("owner"), req.str("name"))
+	if repo == nil {
+		return
+	}
+	c := repo.resolve(req.str("expression"))
+	filePath := strings.Trim(req.str("path"), "/")
+	if c == nil {
+		writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"object": nil}}})
+		return
+	}
+	content, ok := c.files[filePath]
+	if !ok {
+		graphQLErrors(w, object{"repository": object{"object": nil}}, "NOT_FOUND", "blame", "Could not resolve file for path '"+filePath+"'.")
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	history := c.history("")
+	ranges := []object{}
+	for i := 0; i < len(lines); {
+		origin := blameOrigin(c, filePath, lines, i)
+		start := i
+		for i < len(lines) && blameOrigin(c, filePath, lines, i) == origin {
+			i++
+		}
+		age := 1
+		for index, entry := range history {
+			if entry == origin && len(history) > 1 {
+				age = 1 + index*9/(len(history)-1)
+			}
+		}
+		ranges = append(ranges, object{
+			"startingLine": start + 1,
+			"endingLine":   i,
+			"age":          age,
+			"commit": object{
+				"oid":             origin.oid,
+				"messageHeadline": headline(origin.message),
+				"committedDate":   formatTime(origin.date),
+				"author":          object{"name": origin.author.Name, "email": origin.author.Email},
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"object": object{"blame": object{"ranges": ranges}}}}})
+}
+
+func blameOrigin(c *commit, filePath string, lines []string, i int) *commit {
+	origin := c
+	for origin.parent != nil {
+		parentContent, ok := origin.parent.files[filePath]
+		parentLines := strings.Split(strings.TrimSuffix(parentContent, "\n"), "\n")
+		if !ok || i >= len(parentLines) || parentLines[i] != lines[i] {
+			break
+		}
+		origin = origin.parent
+	}
+	return origin
+}
+
+// lastCommits answers the generated query with one history alias per entry of a directory
+func (f *Forge) lastCommits(w http.ResponseWriter, viewer *user, query string) {
+	repoMatch := literalRepo.FindStringSubmatch(query)
+	refMatch := literalRef.FindStringSubmatch(query)
+	if repoMatch == nil || refMatch == nil {
+		writeJSON(w, http.StatusOK, object{"errors": []object{{"message": "the fake forge expects repository and ref literals"}}})
+		return
+	}
+	owner, _ := strconv.Unquote(repoMatch[1])
+	name, _ := strconv.Unquote(repoMatch[2])
+	expression, _ := strconv.Unquote(refMatch[2])
+	// The commit is either the target of ref(qualifiedName:) or object(expression:) itself
+	byObject := strings.HasPrefix(refMatch[1], "object")
+	repo := f.lookup(w, viewer, owner, name)
+	if repo == nil {
+		return
+	}
+	c := repo.resolve(expression)
+	if c == nil {
+		field := "ref"
+		if byObject {
+			field = "object"
+		}
+		writeJSON(w, http.StatusOK, object{"data": object{"repository": object{field: nil}}})
+		return
+	}
+	target := object{}
+	for _, match := range aliasHistory.FindAllStringSubmatch(query, -1) {
+		entryPath, _ := strconv.Unquote(match[2])
+		nodes := []object{}
+		if history := c.history(entryPath); len(history) > 0 {
+			nodes = append(nodes, object{"oid": history[0].oid, "message": history[0].message, "committedDate": formatTime(history[0].date)})
+		}
+		target[match[1]] = object{"nodes": nodes}
+	}
+	if byObject {
+		writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"object": target}}})
+		return
+	}
+	writeJSON(w, http.StatusOK, object{"data": object{"repository": object{"ref": object{"target": target}}}})
+}
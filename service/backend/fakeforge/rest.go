@@ -0,0 +1,165 @@
+package fakeforge
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const htmlBase = "https://fake.forge"
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+// rest serves /repos/{owner}/{name}/contents/{path} and /repos/{owner}/{name}/contributors
+func (f *Forge) rest(w http.ResponseWriter, r *http.Request, viewer *user, urlPath string) {
+	segments := strings.SplitN(strings.TrimPrefix(urlPath, "/repos/"), "/", 4)
+	if len(segments) < 3 {
+		notFound(w)
+		return
+	}
+	repo := f.repository(viewer, segments[0], segments[1])
+	if repo == nil {
+		notFound(w)
+		return
+	}
+	switch segments[2] {
+	case "contents":
+		filePath := ""
+		if len(segments) == 4 {
+			filePath = segments[3]
+		}
+		f.contents(w, r, repo, filePath)
+	case "contributors":
+		f.contributors(w, r, repo)
+	default:
+		notFound(w)
+	}
+}
+
+func contentLinks(repo *repository, ref, filePath string, isDir bool) map[string]interface{} {
+	kind := "blob"
+	if isDir {
+		kind = "tree"
+	}
+	api := fmt.Sprintf("https://api.fake.forge/repos/%s/%s/contents/%s?ref=%s", repo.owner, repo.name, filePath, ref)
+	html := fmt.Sprintf("%s/%s/%s/%s/%s/%s", htmlBase, repo.owner, repo.name, kind, ref, filePath)
+	return map[string]interface{}{
+		"url":      api,
+		"html_url": html,
+		"_links":   map[string]string{"self": api, "html": html},
+	}
+}
+
+func (f *Forge) contents(w http.ResponseWriter, r *http.Request, repo *repository, filePath string) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = repo.defaultBranch
+	}
+	c := repo.resolve(ref)
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "No commit found for the ref " + ref})
+		return
+	}
+	filePath = strings.Trim(filePath, "/")
+
+	if content, ok := c.files[filePath]; ok {
+		entry := map[string]interface{}{
+			"name":     path.Base(filePath),
+			"path":     filePath,
+			"sha":      blobOID(content),
+			"size":     len(content),
+			"type":     "file",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+			"encoding": "base64",
+		}
+		for key, value := range contentLinks(repo, ref, filePath, false) {
+			entry[key] = value
+		}
+		writeJSON(w, http.StatusOK, entry)
+		return
+	}
+
+	entries, ok := c.tree(filePath)
+	if !ok {
+		notFound(w)
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		entryPath := strings.TrimPrefix(filePath+"/"+entry.name, "/")
+		item := map[string]interface{}{
+			"name": entry.name,
+			"path": entryPath,
+			"sha":  objectID("tree", c.oid, entryPath),
+			"size": 0,
+			"type": "dir",
+		}
+		if !entry.isDir {
+			item["sha"] = blobOID(c.files[entryPath])
+			item["size"] = entry.size
+			item["type"] = "file"
+		}
+		for key, value := range contentLinks(repo, ref, entryPath, entry.isDir) {
+			item[key] = value
+		}
+		list = append(list, item)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// contributors counts the commits of the default branch per author, with per_page and page
+func (f *Forge) contributors(w http.ResponseWriter, r *http.Request, repo *repository) {
+	counts := make(map[string]int)
+	authors := make(map[string]*user)
+	for _, c := range repo.branches[repo.defaultBranch].history("") {
+		counts[c.author.Login]++
+		authors[c.author.Login] = c.author
+	}
+	logins := make([]string, 0, len(counts))
+	for login := range counts {
+		logins = append(logins, login)
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if counts[logins[i]] != counts[logins[j]] {
+			return counts[logins[i]] > counts[logins[j]]
+		}
+		return logins[i] < logins[j]
+	})
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 30
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	last := (len(logins) + perPage - 1) / perPage
+	if last > 1 {
+		base := fmt.Sprintf("https://api.fake.forge/repos/%s/%s/contributors?per_page=%d", repo.owner, repo.name, perPage)
+		links := []string{}
+		if page < last {
+			links = append(links, fmt.Sprintf(`<%s&page=%d>; rel="next"`, base, page+1))
+		}
+		links = append(links, fmt.Sprintf(`<%s&page=%d>; rel="last"`, base, last))
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	list := []map[string]interface{}{}
+	for i := (page - 1) * perPage; i < len(logins) && i < page*perPage; i++ {
+		u := authors[logins[i]]
+		list = append(list, map[string]interface{}{
+			"login":         u.Login,
+			"contributions": counts[u.Login],
+			"avatar_url":    u.avatarURL(),
+			"html_url":      htmlBase + "/" + u.Login,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,366 @@
+package fakeforge
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The state of the fake forge is described in YAML:
+//
+//	users:
+//	  - login: octocat
+//	    name: The Octocat
+//	    token: seeded-token          # optional, usable without the oauth2 flow
+//	repositories:
+//	  - owner: octocat
+//	    name: hello-world
+//	    defaultBranch: main
+//	    branches:
+//	      - name: main
+//	        commits:
+//	          - message: Initial commit
+//	            author: octocat
+//	            date: 2024-01-02T10:00:00Z
+//	            files:
+//	              README.md: "# Hello"
+//	      - name: feature
+//	        from: main               # starts with the history of main
+//	        commits: [...]
+//	    tags:
+//	      - name: v1.0.0
+//	        branch: main             # the head of the branch
+//
+// The files of a commit are added to the files of its parent, a file with the content null is
+// removed. The object ids are derived from the content, they are stable between runs.
+
+type Seed struct {
+	Users        []UserSeed       `yaml:"users"`
+	Repositories []RepositorySeed `yaml:"repositories"`
+}
+
+type UserSeed struct {
+	Login    string `yaml:"login"`
+	Name     string `yaml:"name"`
+	Email    string `yaml:"email"`
+	Bio      string `yaml:"bio"`
+	Company  string `yaml:"company"`
+	Location string `yaml:"location"`
+	Token    string `yaml:"token"`
+}
+
+type RepositorySeed struct {
+	Owner         string       `yaml:"owner"`
+	Name          string       `yaml:"name"`
+	Description   string       `yaml:"description"`
+	Private       bool         `yaml:"private"`
+	DefaultBranch string       `yaml:"defaultBranch"`
+	Stars         int          `yaml:"stars"`
+	Branches      []BranchSeed `yaml:"branches"`
+	Tags          []TagSeed    `yaml:"tags"`
+}
+
+type BranchSeed struct {
+	Name    string       `yaml:"name"`
+	From    string       `yaml:"from"`
+	Commits []CommitSeed `yaml:"commits"`
+}
+
+type CommitSeed struct {
+	Message string             `yaml:"message"`
+	Author  string             `yaml:"author"`
+	Date    time.Time          `yaml:"date"`
+	Files   map[string]*string `yaml:"files"`
+}
+
+type TagSeed struct {
+	Name   string `yaml:"name"`
+	Branch string `yaml:"branch"`
+}
+
+// LoadSeed reads a seed from a YAML file
+func LoadSeed(path string) (*Seed, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSeed(data)
+}
+
+func ParseSeed(data []byte) (*Seed, error) {
+	var seed Seed
+	if err := yaml.Unmarshal(data, &seed); err != nil {
+		return nil, fmt.Errorf("invalid seed: %w", err)
+	}
+	return &seed, nil
+}
+
+type user struct {
+	UserSeed
+	createdAt time.Time
+}
+
+func (u *user) avatarURL() string {
+	return "https://avatars.fake.forge/u/" + u.Login
+}
+
+type commit struct {
+	oid     string
+	message string
+	author  *user
+	date    time.Time
+	parent  *commit
+	files   map[string]string // All files of the commit, the key is the path
+	changed map[string]bool   // Paths which differ from the parent
+}
+
+type repository struct {
+	owner         string
+	name          string
+	description   string
+	private       bool
+	defaultBranch string
+	stars         int
+	createdAt     time.Time
+	branches      map[string]*commit // Head commit per branch
+	tags          map[string]*commit
+}
+
+func objectID(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// newCommit applies the seed to the files of the parent
+func (f *Forge) newCommit(repo *repository, parent *commit, seed CommitSeed) (*commit, error) {
+	author, ok := f.users[seed.Author]
+	if !ok {
+		return nil, fmt.Errorf("unknown author %q of the commit %q", seed.Author, seed.Message)
+	}
+	c := &commit{
+		message: seed.Message,
+		author:  author,
+		date:    seed.Date,
+		parent:  parent,
+		files:   make(map[string]string),
+		changed: make(map[string]bool),
+	}
+	if c.date.IsZero() {
+		c.date = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		if parent != nil {
+			c.date = parent.date.Add(time.Hour)
+		}
+	}
+	parentOID := ""
+	if parent != nil {
+		parentOID = parent.oid
+		for path, content := range parent.files {
+			c.files[path] = content
+		}
+	}
+	paths := make([]string, 0, len(seed.Files))
+	for path, content := range seed.Files {
+		path = strings.Trim(path, "/")
+		paths = append(paths, path)
+		c.changed[path] = true
+		if content == nil {
+			delete(c.files, path)
+		} else {
+			c.files[path] = *content
+		}
+	}
+	sort.Strings(paths)
+	parts := []string{repo.owner, repo.name, parentOID, c.message, author.Login, c.date.Format(time.RFC3339)}
+	for _, path := range paths {
+		parts = append(parts, path, c.files[path])
+	}
+	c.oid = objectID(parts...)
+	return c, nil
+}
+
+// apply builds the state of the forge from the seed
+func (f *Forge) apply(seed *Seed) error {
+	for i, userSeed := range seed.Users {
+		if userSeed.Login == "" {
+			return fmt.Errorf("user %d has no login", i)
+		}
+		u := &user{UserSeed: userSeed, createdAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i)}
+		f.users[u.Login] = u
+		f.userOrder = append(f.userOrder, u.Login)
+		if u.Token != "" {
+			f.tokens[u.Token] = u
+		}
+	}
+
+	for _, repoSeed := range seed.Repositories {
+		if _, ok := f.users[repoSeed.Owner]; !ok {
+			return fmt.Errorf("unknown owner %q of the repository %q", repoSeed.Owner, repoSeed.Name)
+		}
+		repo := &repository{
+			owner:         repoSeed.Owner,
+			name:          repoSeed.Name,
+			description:   repoSeed.Description,
+			private:       repoSeed.Private,
+			defaultBranch: repoSeed.DefaultBranch,
+			stars:         repoSeed.Stars,
+			branches:      make(map[string]*commit),
+			tags:          make(map[string]*commit),
+		}
+		if repo.defaultBranch == "" {
+			repo.defaultBranch = "main"
+		}
+		// A branch can start from a branch which is declared before
+		for _, branchSeed := range repoSeed.Branches {
+			var head *commit
+			if branchSeed.From != "" {
+				var ok bool
+				if head, ok = repo.branches[branchSeed.From]; !ok {
+					return fmt.Errorf("branch %q of %s/%s starts from the unknown branch %q", branchSeed.Name, repo.owner, repo.name, branchSeed.From)
+				}
+			}
+			for _, commitSeed := range branchSeed.Commits {
+				c, err := f.newCommit(repo, head, commitSeed)
+				if err != nil {
+					return err
+				}
+				head = c
+			}
+			if head == nil {
+				return fmt.Errorf("branch %q of %s/%s has no commits", branchSeed.Name, repo.owner, repo.name)
+			}
+			repo.branches[branchSeed.Name] = head
+		}
+		if _, ok := repo.branches[repo.defaultBranch]; !ok {
+			return fmt.Errorf("default branch %q of %s/%s is missing", repo.defaultBranch, repo.owner, repo.name)
+		}
+		for _, tagSeed := range repoSeed.Tags {
+			head, ok := repo.branches[tagSeed.Branch]
+			if !ok {
+				return fmt.Errorf("tag %q of %s/%s points to the unknown branch %q", tagSeed.Name, repo.owner, repo.name, tagSeed.Branch)
+			}
+			repo.tags[tagSeed.Name] = head
+		}
+		repo.createdAt = repo.root().date
+		f.repositories[repo.owner+"/"+repo.name] = repo
+	}
+	return nil
+}
+
+// root returns the first commit of the default branch
+func (r *repository) root() *commit {
+	c := r.branches[r.defaultBranch]
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
+// pushedAt is the date of the newest commit of all branches
+func (r *repository) pushedAt() time.Time {
+	var newest time.Time
+	for _, head := range r.branches {
+		if head.date.After(newest) {
+			newest = head.date
+		}
+	}
+	return newest
+}
+
+// resolve finds the commit of a branch, a tag, HEAD or an object id
+func (r *repository) resolve(expression string) *commit {
+	switch {
+	case expression == "" || expression == "HEAD":
+		return r.branches[r.defaultBranch]
+	case strings.HasPrefix(expression, "refs/heads/"):
+		return r.branches[strings.TrimPrefix(expression, "refs/heads/")]
+	case strings.HasPrefix(expression, "refs/tags/"):
+		return r.tags[strings.TrimPrefix(expression, "refs/tags/")]
+	}
+	if c, ok := r.branches[expression]; ok {
+		return c
+	}
+	if c, ok := r.tags[expression]; ok {
+		return c
+	}
+	for _, head := range r.branches {
+		for c := head; c != nil; c = c.parent {
+			if c.oid == expression {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// history returns the commits which changed path (or all for an empty path), the newest first
+func (c *commit) history(path string) []*commit {
+	path = strings.Trim(path, "/")
+	var commits []*commit
+	for current := c; current != nil; current = current.parent {
+		if path == "" || current.touches(path) {
+			commits = append(commits, current)
+		}
+	}
+	return commits
+}
+
+func (c *commit) touches(path string) bool {
+	for changed := range c.changed {
+		if changed == path || strings.HasPrefix(changed, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type treeEntry struct {
+	name  string
+	isDir bool
+	size  int
+}
+
+// tree lists the entries of a directory, directories first and then by name like GitHub.
+// The second result is false if the directory does not exist.
+func (c *commit) tree(dir string) ([]treeEntry, bool) {
+	dir = strings.Trim(dir, "/")
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	seen := make(map[string]*treeEntry)
+	for path, content := range c.files {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(path, prefix)
+		name, _, isDir := strings.Cut(rest, "/")
+		if _, ok := seen[name]; !ok {
+			seen[name] = &treeEntry{name: name, isDir: isDir, size: len(content)}
+		}
+	}
+	if len(seen) == 0 && dir != "" {
+		return nil, false
+	}
+	entries := make([]treeEntry, 0, len(seen))
+	for _, entry := range seen {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].isDir != entries[j].isDir {
+			return entries[i].isDir
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries, true
+}
+
+// blobOID is the object id of a file content
+func blobOID(content string) string {
+	return objectID("blob", content)
+}
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"githubclone-backend/api"
 	"githubclone-backend/api/abstracted"
+	"githubclone-backend/api/common"
+	"githubclone-backend/api/gitserver"
 	"githubclone-backend/cachable"
 	"githubclone-backend/cache"
 	"githubclone-backend/db"
+	"githubclone-backend/mirror"
 	"githubclone-backend/restore"
 	"io"
 	"log"
@@ -52,11 +55,23 @@ func main() {
 	db.AutoMigrate()
 
 	ctx := context.Background()
-	mlc, err := cache.NewMultiLevelCache(redisAddr, time.Minute)
+	// CACHE_BACKEND selects redis (default), memory or disk, the disk backend uses the file CACHE_PATH
+	mlc, err := cache.New(cache.Config{
+		Backend:   os.Getenv("CACHE_BACKEND"),
+		RedisAddr: redisAddr,
+		Path:      os.Getenv("CACHE_PATH"),
+	})
 	if err != nil {
 		log.Fatalf("Cache init failed: %v", err)
 	}
 	facade := cachable.NewCacheFacade(ctx, mlc)
+	common.InitRateLimits(mlc.Redis())
+	common.InitRevalidation(facade.RevalidationCache)
+
+	// Optional mirror of selected repositories, it's disabled without a directory
+	if err := mirror.Init(os.Getenv("MIRROR_DIR")); err != nil {
+		log.Printf("Mirroring is disabled: %v", err)
+	}
 
 	// Gin-Engine
 	r := gin.New()
@@ -76,7 +91,9 @@ func main() {
 	api.ConnectionRoutes(r)
 	api.UserConnectionRoutes(r)
 	api.ConfigurationRoutes(r)
+	api.CacheAdminRoutes(r)
 	abstracted.SetupRoutes(r)
+	gitserver.SetupRoutes(r)
 
 	// Configure HTTP-Server
 	srv := &http.Server{
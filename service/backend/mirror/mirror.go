@@ -0,0 +1,502 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"githubclone-backend/api/local"
+	"githubclone-backend/models"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// The mirror keeps selected repositories of a provider as bare clones below a directory,
+// <root>/<connection id>/<owner>/<name>.git. Tree, last commit, blame and history requests
+// are answered from the clone as long as its refs match the refs of the remote, which
+// saves the rate limit of the provider. Stale mirrors are fetched in the background.
+
+// The result of a freshness check is reused for this duration, a check costs one ls-remote
+const checkInterval = time.Minute
+
+var ErrDisabled = errors.New("mirroring is not enabled, MIRROR_DIR is not set")
+
+var fullOID = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+type Manager struct {
+	root     string
+	store    Store
+	mu       sync.Mutex
+	checked  map[string]time.Time // Key and revision which were fresh at the given time
+	visible  map[string]time.Time // Key and token which could list the remote at the given time
+	fetching map[string]bool
+	locks    map[string]*sync.Mutex // Serializes clone and fetch of a repository
+}
+
+// Default is nil if mirroring is disabled
+var Default *Manager
+
+// Init enables the mirroring with the directory root
+func Init(root string) error {
+	if root == "" {
+		return nil
+	}
+	manager, err := NewManager(root, dbStore{})
+	if err != nil {
+		return err
+	}
+	Default = manager
+	log.Printf("Mirroring of repositories is enabled in %s", root)
+	return nil
+}
+
+// NewManager creates a manager which keeps the clones below root and the selection in store
+func NewManager(root string, store Store) (*Manager, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create mirror directory: %w", err)
+	}
+	return &Manager{
+		root:     root,
+		store:    store,
+		checked:  make(map[string]time.Time),
+		visible:  make(map[string]time.Time),
+		fetching: make(map[string]bool),
+		locks:    make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func Enabled() bool {
+	return Default != nil
+}
+
+func key(connectionID uint, owner, name string) string {
+	return fmt.Sprintf("%d/%s/%s", connectionID, owner, name)
+}
+
+// connectionRoot is the directory of the mirrors of a connection, it has the layout of the local provider
+func (m *Manager) connectionRoot(connectionID uint) string {
+	return filepath.Join(m.root, strconv.FormatUint(uint64(connectionID), 10))
+}
+
+func (m *Manager) path(connectionID uint, owner, name string) string {
+	return filepath.Join(m.connectionRoot(connectionID), owner, name+".git")
+}
+
+// cloneURL returns the https address of a repository of a connection
+func cloneURL(connection models.Connection, owner, name string) (string, error) {
+	var base string
+	switch connection.Type {
+	case "github":
+		base = "github.com"
+	case "gitlab":
+		base = "gitlab.com"
+	case "ghes", "gitlab_selfmanaged", "gitea":
+		if connection.URL == nil || *connection.URL == "" {
+			return "", fmt.Errorf("the connection %s has no URL", connection.ConnectionName)
+		}
+		base = *connection.URL
+	default:
+		return "", fmt.Errorf("the connection type %s cannot be mirrored", connection.Type)
+	}
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return fmt.Sprintf("%s/%s/%s.git", strings.TrimRight(base, "/"), owner, name), nil
+}
+
+// auth returns the credentials for git over https, the user name depends on the forge
+func auth(connection models.Connection, token string) *githttp.BasicAuth {
+	username := "oauth2"
+	if connection.Type == "github" || connection.Type == "ghes" {
+		username = "x-access-token"
+	}
+	return &githttp.BasicAuth{Username: username, Password: token}
+}
+
+func caBundle(connection models.Connection) []byte {
+	if connection.CACertificate == nil {
+		return nil
+	}
+	return []byte(*connection.CACertificate)
+}
+
+// proxyOptions returns the proxy of the connection, without one HTTPS_PROXY applies
+func proxyOptions(connection models.Connection) transport.ProxyOptions {
+	if connection.ProxyURL == nil {
+		return transport.ProxyOptions{}
+	}
+	return transport.ProxyOptions{URL: *connection.ProxyURL}
+}
+
+// Add selects a repository for mirroring and starts the first clone in the background. The
+// account behind token has to see the repository, otherwise ErrNotVisible is returned.
+func (m *Manager) Add(ctx context.Context, connectionID uint, token, owner, name string) (*models.Mirror, error) {
+	if !local.ValidName(owner) || !local.ValidName(name) {
+		return nil, fmt.Errorf("invalid repository %s/%s", owner, name)
+	}
+	connection, err := m.store.Connection(connectionID)
+	if err != nil {
+		return nil, fmt.Errorf("connection not found: %w", err)
+	}
+	if _, err := cloneURL(connection, owner, name); err != nil {
+		return nil, err
+	}
+	candidate := models.Mirror{ConnectionID: connectionID, Connection: connection, Owner: owner, Name: name}
+	if _, err := listRemote(ctx, candidate, token); err != nil {
+		log.Printf("ls-remote of mirror %s failed: %v", key(connectionID, owner, name), err)
+		return nil, ErrNotVisible
+	}
+	m.rememberVisible(candidate, token)
+
+	record := models.Mirror{ConnectionID: connectionID, Owner: owner, Name: name}
+	if err := m.store.Create(&record); err != nil {
+		return nil, fmt.Errorf("failed to create mirror: %w", err)
+	}
+	record.Connection = connection
+	m.syncAsync(record, token)
+	return &record, nil
+}
+
+// Remove deletes the selection and the clone of a repository. The account behind token has to
+// see the repository, otherwise ErrNotVisible is returned.
+func (m *Manager) Remove(ctx context.Context, connectionID uint, token, owner, name string) error {
+	record, err := m.store.Find(connectionID, owner, name)
+	if err != nil {
+		return err
+	}
+	if _, err := listRemote(ctx, *record, token); err != nil {
+		log.Printf("ls-remote of mirror %s failed: %v", key(connectionID, owner, name), err)
+		return ErrNotVisible
+	}
+	if err := m.store.Delete(record); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	k := key(connectionID, owner, name)
+	for checkedKey := range m.checked {
+		if strings.HasPrefix(checkedKey, k+"@") {
+			delete(m.checked, checkedKey)
+		}
+	}
+	for visibleKey := range m.visible {
+		if strings.HasPrefix(visibleKey, k+"@") {
+			delete(m.visible, visibleKey)
+		}
+	}
+	m.mu.Unlock()
+	return os.RemoveAll(m.path(connectionID, owner, name))
+}
+
+// List returns the mirrored repositories of a connection which the account behind token can see
+func (m *Manager) List(ctx context.Context, connectionID uint, token string) ([]models.Mirror, error) {
+	records, err := m.store.List(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]models.Mirror, 0, len(records))
+	for _, record := range records {
+		if m.checkVisible(ctx, record, token) == nil {
+			visible = append(visible, record)
+		}
+	}
+	return visible, nil
+}
+
+func (m *Manager) lock(k string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[k] == nil {
+		m.locks[k] = &sync.Mutex{}
+	}
+	return m.locks[k]
+}
+
+// Sync clones the repository or fetches all refs of an existing clone
+func (m *Manager) Sync(record models.Mirror, token string) error {
+	lock := m.lock(key(record.ConnectionID, record.Owner, record.Name))
+	lock.Lock()
+	defer lock.Unlock()
+
+	url, err := cloneURL(record.Connection, record.Owner, record.Name)
+	if err != nil {
+		return err
+	}
+	path := m.path(record.ConnectionID, record.Owner, record.Name)
+	credentials := auth(record.Connection, token)
+
+	repo, err := git.PlainOpen(path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainClone(path, true, &git.CloneOptions{
+			URL:             url,
+			Auth:            credentials,
+			Mirror:          true,
+			CABundle:        caBundle(record.Connection),
+			InsecureSkipTLS: record.Connection.InsecureSkipVerify,
+			ProxyOptions:    proxyOptions(record.Connection),
+		})
+		if err != nil {
+			os.RemoveAll(path)
+		}
+	} else if err == nil {
+		err = repo.Fetch(&git.FetchOptions{
+			RefSpecs:        []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+			Auth:            credentials,
+			CABundle:        caBundle(record.Connection),
+			InsecureSkipTLS: record.Connection.InsecureSkipVerify,
+			ProxyOptions:    proxyOptions(record.Connection),
+			Force:           true,
+			Prune:           true,
+		})
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			err = nil
+		}
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"last_fetched_at": now, "last_error": ""}
+	if err != nil {
+		updates = map[string]interface{}{"last_error": err.Error()}
+	} else if head, err1 := repo.Head(); err1 == nil {
+		updates["head_oid"] = head.Hash().String()
+	}
+	if err1 := m.store.Update(record.ID, updates); err1 != nil {
+		log.Printf("Cannot update mirror %s: %v", key(record.ConnectionID, record.Owner, record.Name), err1)
+	}
+	return err
+}
+
+// syncAsync runs Sync in the background, at most once per repository at a time
+func (m *Manager) syncAsync(record models.Mirror, token string) {
+	k := key(record.ConnectionID, record.Owner, record.Name)
+	m.mu.Lock()
+	if m.fetching[k] {
+		m.mu.Unlock()
+		return
+	}
+	m.fetching[k] = true
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			delete(m.fetching, k)
+			m.mu.Unlock()
+		}()
+		start := time.Now()
+		if err := m.Sync(record, token); err != nil {
+			log.Printf("Mirror %s could not be synchronized: %v", k, err)
+			return
+		}
+		log.Printf("Mirror %s synchronized in %v", k, time.Since(start))
+	}()
+}
+
+// remoteRef returns the name of the reference of revision which is compared between mirror and remote
+func remoteRef(refs []*plumbing.Reference, revision string) plumbing.ReferenceName {
+	revision = strings.TrimPrefix(revision, "refs/heads/")
+	if revision == "" || revision == "HEAD" {
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+				return ref.Target()
+			}
+		}
+		return plumbing.HEAD
+	}
+	for _, name := range []plumbing.ReferenceName{plumbing.NewBranchReferenceName(revision), plumbing.NewTagReferenceName(revision)} {
+		for _, ref := range refs {
+			if ref.Name() == name {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// listRemote lists the refs of the remote repository with the token of a user. It fails if the
+// account of the user cannot see the repository.
+func listRemote(ctx context.Context, record models.Mirror, token string) ([]*plumbing.Reference, error) {
+	url, err := cloneURL(record.Connection, record.Owner, record.Name)
+	if err != nil {
+		return nil, err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	return remote.ListContext(ctx, &git.ListOptions{
+		Auth:            auth(record.Connection, token),
+		CABundle:        caBundle(record.Connection),
+		InsecureSkipTLS: record.Connection.InsecureSkipVerify,
+		ProxyOptions:    proxyOptions(record.Connection),
+	})
+}
+
+// tokenKey identifies the principal of a check without keeping the token in memory
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// visibilityKey identifies the check of a repository for a token
+func visibilityKey(record models.Mirror, token string) string {
+	return key(record.ConnectionID, record.Owner, record.Name) + "@" + tokenKey(token)
+}
+
+// rememberVisible notes that token could list the remote, expired checks are dropped
+func (m *Manager) rememberVisible(record models.Mirror, token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for k, checkedAt := range m.visible {
+		if now.Sub(checkedAt) >= checkInterval {
+			delete(m.visible, k)
+		}
+	}
+	m.visible[visibilityKey(record, token)] = now
+}
+
+// checkVisible proves with an ls-remote that the account behind token can see the repository,
+// the result is reused for checkInterval
+func (m *Manager) checkVisible(ctx context.Context, record models.Mirror, token string) error {
+	m.mu.Lock()
+	checkedAt, ok := m.visible[visibilityKey(record, token)]
+	m.mu.Unlock()
+	if ok && time.Since(checkedAt) < checkInterval {
+		return nil
+	}
+	if _, err := listRemote(ctx, record, token); err != nil {
+		log.Printf("ls-remote of mirror %s failed: %v", key(record.ConnectionID, record.Owner, record.Name), err)
+		return ErrNotVisible
+	}
+	m.rememberVisible(record, token)
+	return nil
+}
+
+// isFresh compares the reference of revision in the mirror with the remote. Both ways check
+// with the token that the user can see the repository, otherwise ErrNotVisible is returned.
+func (m *Manager) isFresh(ctx context.Context, repo *git.Repository, record models.Mirror, token, revision string) (bool, error) {
+	// A commit does not change, it is enough that the mirror contains it. The fetch is skipped,
+	// the authorization is not.
+	if fullOID.MatchString(revision) {
+		if err := m.checkVisible(ctx, record, token); err != nil {
+			return false, err
+		}
+		_, err := repo.CommitObject(plumbing.NewHash(revision))
+		return err == nil, nil
+	}
+
+	refs, err := listRemote(ctx, record, token)
+	if err != nil {
+		log.Printf("ls-remote of mirror %s failed: %v", key(record.ConnectionID, record.Owner, record.Name), err)
+		return false, ErrNotVisible
+	}
+	m.rememberVisible(record, token)
+	name := remoteRef(refs, revision)
+	if name == "" {
+		return false, nil
+	}
+	var remoteHash plumbing.Hash
+	for _, ref := range refs {
+		if ref.Name() == name && ref.Type() == plumbing.HashReference {
+			remoteHash = ref.Hash()
+		}
+	}
+	localRef, err := repo.Reference(name, true)
+	return err == nil && !remoteHash.IsZero() && localRef.Hash() == remoteHash, nil
+}
+
+// Open returns the mirror of a repository if it's selected and fresh for revision. A stale
+// mirror is fetched in the background and nil is returned, so that the caller asks the provider.
+// Tokens which cannot see the repository get nil and don't start a fetch.
+func (m *Manager) Open(ctx context.Context, connectionID uint, token, owner, name, revision string) *git.Repository {
+	record, err := m.store.Find(connectionID, owner, name)
+	if err != nil {
+		return nil
+	}
+	repo, err := local.OpenRepository(m.connectionRoot(connectionID), owner, name)
+	if err != nil {
+		if m.checkVisible(ctx, *record, token) == nil {
+			m.syncAsync(*record, token)
+		}
+		return nil
+	}
+
+	// The check proves as well that the user can see the repository, so it's kept per token
+	checkKey := key(connectionID, owner, name) + "@" + revision + "@" + tokenKey(token)
+	m.mu.Lock()
+	checkedAt, ok := m.checked[checkKey]
+	m.mu.Unlock()
+	if ok && time.Since(checkedAt) < checkInterval {
+		return repo
+	}
+
+	fresh, err := m.isFresh(ctx, repo, *record, token, revision)
+	if err != nil {
+		return nil
+	}
+	if !fresh {
+		m.syncAsync(*record, token)
+		return nil
+	}
+	m.mu.Lock()
+	now := time.Now()
+	for k, at := range m.checked {
+		if now.Sub(at) >= checkInterval {
+			delete(m.checked, k)
+		}
+	}
+	m.checked[checkKey] = now
+	m.mu.Unlock()
+	return repo
+}
+
+var (
+	ErrNotMirrored = errors.New("repository is not mirrored")
+	ErrNotVisible  = errors.New("repository is not visible for the account of the provider")
+)
+
+// refsMatch reports whether the mirror has all branches and tags of the remote
+func refsMatch(repo *git.Repository, refs []*plumbing.Reference) bool {
+	for _, ref := range refs {
+		if ref.Type() != plumbing.HashReference || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+			continue
+		}
+		localRef, err := repo.Reference(ref.Name(), false)
+		if err != nil || localRef.Hash() != ref.Hash() {
+			return false
+		}
+	}
+	return true
+}
+
+// Prepare checks that the account behind token can see the repository and brings the mirror up
+// to date before it's served to a git client. It returns the directory of the bare clone.
+func (m *Manager) Prepare(ctx context.Context, connectionID uint, token, owner, name string) (string, error) {
+	record, err := m.store.Find(connectionID, owner, name)
+	if err != nil {
+		return "", ErrNotMirrored
+	}
+	refs, err := listRemote(ctx, *record, token)
+	if err != nil {
+		log.Printf("ls-remote of mirror %s failed: %v", key(connectionID, owner, name), err)
+		return "", ErrNotVisible
+	}
+	repo, err := local.OpenRepository(m.connectionRoot(connectionID), owner, name)
+	if err != nil || !refsMatch(repo, refs) {
+		if err := m.Sync(*record, token); err != nil {
+			return "", fmt.Errorf("mirror cannot be synchronized: %w", err)
+		}
+	}
+	return m.path(connectionID, owner, name), nil
+}
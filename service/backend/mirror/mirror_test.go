@@ -0,0 +1,144 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"githubclone-backend/mirror/mirrortest"
+	"githubclone-backend/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newTestManager mirrors the repositories of the remote as connection 1
+func newTestManager(t *testing.T, remote *mirrortest.Remote) (*Manager, *mirrortest.Store) {
+	t.Helper()
+	url := remote.URL
+	store := mirrortest.NewStore(models.Connection{Model: gorm.Model{ID: 1}, ConnectionName: "remote", Type: "gitea", URL: &url})
+	m, err := NewManager(t.TempDir(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The clones are removed with the directory, a running fetch would write into it
+	t.Cleanup(func() { waitIdle(t, m) })
+	return m, store
+}
+
+// waitIdle waits for the fetches in the background
+func waitIdle(t *testing.T, m *Manager) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		busy := len(m.fetching) > 0
+		m.mu.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the mirror is still fetching")
+}
+
+func headOf(t *testing.T, store *mirrortest.Store) string {
+	t.Helper()
+	record, err := store.Find(1, "alice", "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record.HeadOID
+}
+
+func TestMirrorFreshness(t *testing.T) {
+	ctx := context.Background()
+	remote := mirrortest.NewRemote(t, "alice-token")
+	first := remote.Commit(t, "alice", "demo", map[string]string{"README.md": "# demo\n"})
+	m, store := newTestManager(t, remote)
+
+	if _, err := m.Add(ctx, 1, remote.Token, "alice", "demo"); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+	if head := headOf(t, store); head != first {
+		t.Fatalf("head after the clone = %q, want %s", head, first)
+	}
+
+	// Fresh: the branch of the mirror matches the remote
+	repo := m.Open(ctx, 1, remote.Token, "alice", "demo", "main")
+	if repo == nil {
+		t.Fatal("the fresh mirror is not used")
+	}
+	if head, err := repo.Head(); err != nil || head.Hash().String() != first {
+		t.Fatalf("head of the mirror = %v, %v", head, err)
+	}
+	if m.Open(ctx, 1, remote.Token, "alice", "demo", first) == nil {
+		t.Error("the mirror is not used for a commit it contains")
+	}
+
+	// Stale: the remote has a new commit, the mirror is fetched in the background
+	second := remote.Commit(t, "alice", "demo", map[string]string{"README.md": "# changed\n"})
+	m.mu.Lock()
+	m.checked = make(map[string]time.Time)
+	m.mu.Unlock()
+	if m.Open(ctx, 1, remote.Token, "alice", "demo", "main") != nil {
+		t.Fatal("the stale mirror is used")
+	}
+	if m.Open(ctx, 1, remote.Token, "alice", "demo", second) != nil {
+		t.Error("the mirror is used for a commit it does not contain yet")
+	}
+	waitIdle(t, m)
+	if head := headOf(t, store); head != second {
+		t.Fatalf("head after the fetch = %q, want %s", head, second)
+	}
+	if m.Open(ctx, 1, remote.Token, "alice", "demo", "main") == nil {
+		t.Error("the fetched mirror is not used")
+	}
+}
+
+func TestMirrorNotVisible(t *testing.T) {
+	ctx := context.Background()
+	remote := mirrortest.NewRemote(t, "alice-token")
+	oid := remote.Commit(t, "alice", "demo", map[string]string{"README.md": "# demo\n"})
+	m, store := newTestManager(t, remote)
+
+	if _, err := m.Add(ctx, 1, "mallory-token", "alice", "demo"); !errors.Is(err, ErrNotVisible) {
+		t.Fatalf("Add with a foreign token: %v, want ErrNotVisible", err)
+	}
+	if mirrors, _ := m.List(ctx, 1, remote.Token); len(mirrors) != 0 {
+		t.Fatalf("a refused repository is listed: %+v", mirrors)
+	}
+
+	if _, err := m.Add(ctx, 1, remote.Token, "alice", "demo"); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+	// The checks of the owner must not open the mirror for other tokens
+	if m.Open(ctx, 1, remote.Token, "alice", "demo", "main") == nil || m.Open(ctx, 1, remote.Token, "alice", "demo", oid) == nil {
+		t.Fatal("the mirror is not used for the owner")
+	}
+	for _, revision := range []string{"main", oid} {
+		if m.Open(ctx, 1, "mallory-token", "alice", "demo", revision) != nil {
+			t.Errorf("the mirror is opened at %s with a foreign token", revision)
+		}
+	}
+	waitIdle(t, m)
+	if record, _ := store.Find(1, "alice", "demo"); record.LastError != "" {
+		t.Errorf("a foreign token started a fetch: %s", record.LastError)
+	}
+	if _, err := m.Prepare(ctx, 1, "mallory-token", "alice", "demo"); !errors.Is(err, ErrNotVisible) {
+		t.Errorf("Prepare with a foreign token: %v, want ErrNotVisible", err)
+	}
+	if mirrors, _ := m.List(ctx, 1, "mallory-token"); len(mirrors) != 0 {
+		t.Errorf("the mirror is listed for a foreign token: %+v", mirrors)
+	}
+	if mirrors, _ := m.List(ctx, 1, remote.Token); len(mirrors) != 1 {
+		t.Errorf("the mirror is not listed for the owner: %+v", mirrors)
+	}
+	if err := m.Remove(ctx, 1, "mallory-token", "alice", "demo"); !errors.Is(err, ErrNotVisible) {
+		t.Errorf("Remove with a foreign token: %v, want ErrNotVisible", err)
+	}
+	if err := m.Remove(ctx, 1, remote.Token, "alice", "demo"); err != nil {
+		t.Errorf("Remove by the owner: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package mirrortest provides a git remote over smart HTTP and a mirror store in memory for the
+// tests of the mirror and the git server. The remote is served by git http-backend, so the tests
+// are skipped where git is not installed.
+package mirrortest
+
+import (
+	"errors"
+	"githubclone-backend/models"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gorm.io/gorm"
+)
+
+// Store is a mirror.Store in memory
+type Store struct {
+	mu          sync.Mutex
+	connections map[uint]models.Connection
+	mirrors     map[uint]*models.Mirror
+	nextID      uint
+}
+
+func NewStore(connections ...models.Connection) *Store {
+	s := &Store{connections: make(map[uint]models.Connection), mirrors: make(map[uint]*models.Mirror)}
+	for _, connection := range connections {
+		s.connections[connection.ID] = connection
+	}
+	return s
+}
+
+func (s *Store) Connection(id uint) (models.Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	connection, ok := s.connections[id]
+	if !ok {
+		return models.Connection{}, gorm.ErrRecordNotFound
+	}
+	return connection, nil
+}
+
+// withConnection returns a copy of the record with its connection, the caller holds s.mu
+func (s *Store) withConnection(record *models.Mirror) models.Mirror {
+	result := *record
+	result.Connection = s.connections[record.ConnectionID]
+	return result
+}
+
+func (s *Store) Find(connectionID uint, owner, name string) (*models.Mirror, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.mirrors {
+		if record.ConnectionID == connectionID && record.Owner == owner && record.Name == name {
+			result := s.withConnection(record)
+			return &result, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *Store) List(connectionID uint) ([]models.Mirror, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []models.Mirror
+	for _, record := range s.mirrors {
+		if record.ConnectionID == connectionID {
+			records = append(records, s.withConnection(record))
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Owner+"/"+records[i].Name < records[j].Owner+"/"+records[j].Name
+	})
+	return records, nil
+}
+
+func (s *Store) Create(record *models.Mirror) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.connections[record.ConnectionID]; !ok {
+		return errors.New("connection not found")
+	}
+	for _, existing := range s.mirrors {
+		if existing.ConnectionID == record.ConnectionID && existing.Owner == record.Owner && existing.Name == record.Name {
+			*record = *existing
+			return nil
+		}
+	}
+	s.nextID++
+	record.ID = s.nextID
+	stored := *record
+	stored.Connection = models.Connection{}
+	s.mirrors[record.ID] = &stored
+	return nil
+}
+
+func (s *Store) Delete(record *models.Mirror) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mirrors, record.ID)
+	return nil
+}
+
+func (s *Store) Update(id uint, updates map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.mirrors[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	for column, value := range updates {
+		switch column {
+		case "head_oid":
+			record.HeadOID = value.(string)
+		case "last_error":
+			record.LastError = value.(string)
+		case "last_fetched_at":
+			fetchedAt := value.(time.Time)
+			record.LastFetchedAt = &fetchedAt
+		}
+	}
+	return nil
+}
+
+// Remote serves the repositories below a directory as <URL>/<owner>/<name>.git. Only requests
+// with Token as basic auth password are answered, as on a forge for a private repository.
+type Remote struct {
+	URL   string
+	Token string
+	root  string
+}
+
+func NewRemote(t testing.TB, token string) *Remote {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	r := &Remote{Token: token, root: t.TempDir()}
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + r.root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, password, ok := req.BasicAuth(); !ok || password != r.Token {
+			w.Header().Set("WWW-Authenticate", `Basic realm="remote"`)
+			http.Error(w, "not found", http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	r.URL = server.URL
+	return r
+}
+
+// Commit writes files to the branch main of a repository of the remote, the repository is created
+// with the first commit. It returns the id of the commit.
+func (r *Remote) Commit(t testing.TB, owner, name string, files map[string]string) string {
+	t.Helper()
+	// http-backend finds the .git directory below <name>.git
+	dir := filepath.Join(r.root, owner, name+".git")
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInitWithOptions(dir, &git.PlainInitOptions{InitOptions: git.InitOptions{DefaultBranch: plumbing.Main}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signature := &object.Signature{Name: owner, Email: owner + "@example.com", When: time.Now()}
+	hash, err := worktree.Commit("Change "+name, &git.CommitOptions{Author: signature, Committer: signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash.String()
+}
@@ -0,0 +1,56 @@
+package mirror
+
+import (
+	"githubclone-backend/db"
+	"githubclone-backend/models"
+)
+
+// Store keeps the repositories selected for mirroring. Missing rows are reported with
+// gorm.ErrRecordNotFound, the mirrors are returned with their connection.
+type Store interface {
+	Connection(id uint) (models.Connection, error)
+	Find(connectionID uint, owner, name string) (*models.Mirror, error)
+	List(connectionID uint) ([]models.Mirror, error)
+	// Create stores the record unless the repository is selected already, record is filled in
+	Create(record *models.Mirror) error
+	Delete(record *models.Mirror) error
+	Update(id uint, updates map[string]interface{}) error
+}
+
+// dbStore is the store of the platform database
+type dbStore struct{}
+
+func (dbStore) Connection(id uint) (models.Connection, error) {
+	var connection models.Connection
+	err := db.DB.First(&connection, id).Error
+	return connection, err
+}
+
+func (dbStore) Find(connectionID uint, owner, name string) (*models.Mirror, error) {
+	var record models.Mirror
+	err := db.DB.Preload("Connection").
+		Where("connection_id = ? AND owner = ? AND name = ?", connectionID, owner, name).
+		First(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (dbStore) List(connectionID uint) ([]models.Mirror, error) {
+	var records []models.Mirror
+	err := db.DB.Preload("Connection").Where("connection_id = ?", connectionID).Order("owner, name").Find(&records).Error
+	return records, err
+}
+
+func (dbStore) Create(record *models.Mirror) error {
+	return db.DB.Where(models.Mirror{ConnectionID: record.ConnectionID, Owner: record.Owner, Name: record.Name}).FirstOrCreate(record).Error
+}
+
+func (dbStore) Delete(record *models.Mirror) error {
+	return db.DB.Unscoped().Delete(record).Error
+}
+
+func (dbStore) Update(id uint, updates map[string]interface{}) error {
+	return db.DB.Model(&models.Mirror{}).Where("id = ?", id).Updates(updates).Error
+}
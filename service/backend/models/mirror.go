@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Mirror is a repository of a connection which is kept as bare clone in the mirror directory
+type Mirror struct {
+	gorm.Model
+	ConnectionID  uint       `gorm:"not null;uniqueIndex:idx_mirror_repository"`
+	Connection    Connection `gorm:"constraint:OnDelete:CASCADE;"`
+	Owner         string     `gorm:"not null;uniqueIndex:idx_mirror_repository"`
+	Name          string     `gorm:"not null;uniqueIndex:idx_mirror_repository"`
+	HeadOID       string     `gorm:"default:''"` // HEAD of the mirror after the last fetch
+	LastFetchedAt *time.Time `gorm:"default:NULL"`
+	LastError     string     `gorm:"default:''"`
+}
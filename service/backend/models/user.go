@@ -36,15 +36,20 @@ type UserPermission struct {
 
 type Connection struct {
 	gorm.Model
-	ConnectionName string    `gorm:"unique;not null"`
-	Type           string    `gorm:"type:connection_type;not null"`
-	URL            *string   // Optional, therefore defined as a pointer, mandatory only for GHES
-	ClientID       string    `gorm:"not null"`
-	ClientSecret   string    `gorm:"not null"`
-	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
-	UpdatedAt      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
-	Description    string    `gorm:"default:''"`
-	Deactivated    bool      `gorm:"not null"`
+	ConnectionName     string  `gorm:"unique;not null"`
+	Type               string  `gorm:"type:connection_type;not null"`
+	URL                *string // Optional, therefore defined as a pointer, mandatory for GHES, self-managed GitLab and Gitea, the directory for local
+	CACertificate      *string // Optional PEM bundle for instances whose certificates are signed by a private CA
+	ProxyURL           *string // Optional proxy for all requests to the instance, otherwise HTTPS_PROXY applies
+	ClientCertificate  *string // Optional PEM client certificate and key for instances requiring mTLS
+	ClientKey          *string
+	InsecureSkipVerify bool      `gorm:"not null;default:false"` // Skips the certificate verification, only for lab instances
+	ClientID           string    `gorm:"not null"`
+	ClientSecret       string    `gorm:"not null"`
+	CreatedAt          time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt          time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	Description        string    `gorm:"default:''"`
+	Deactivated        bool      `gorm:"not null"`
 }
 
 type UserConnection struct {